@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"godrop-common/atrest"
+)
+
+// The desktop app's receive shares can encrypt files as they are saved, in
+// the format of godrop-common/atrest. `godrop decrypt` opens them and
+// `godrop keygen` makes the key pair to encrypt them for, so the receiving
+// machine never holds a key that opens them.
+
+// keygenCommand implements `godrop keygen`
+func keygenCommand(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("o", "godrop.key", "File the secret key is written to")
+	fs.Parse(args)
+
+	public, secret, err := atrest.GenerateKeyPair()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = fmt.Fprintln(f, secret)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Secret key written to", *out, "- keep it safe, it opens every file encrypted for it.")
+	fmt.Println("Public key, for the receive share's encryption setting:")
+	fmt.Println(public)
+}
+
+// decryptCommand implements `godrop decrypt`
+func decryptCommand(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFile := fs.String("key", "", "Secret key file from `godrop keygen` (asks for the passphrase when empty)")
+	outDir := fs.String("o", "", "Directory decrypted files are saved to (default next to each file)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Usage: godrop decrypt [-key <file>] [-o <dir>] <file1.godrop> [file2.godrop...]")
+		os.Exit(2)
+	}
+
+	var secret string
+	if *keyFile != "" {
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		secret = strings.TrimSpace(string(data))
+	} else {
+		secret = prompt(bufio.NewReader(os.Stdin), "Passphrase: ")
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		dir := *outDir
+		if dir == "" {
+			dir = filepath.Dir(path)
+		}
+		dst, err := decryptFile(path, secret, dir)
+		if err != nil {
+			fmt.Println("Error:", err)
+			failed = true
+			continue
+		}
+		fmt.Println("Decrypted", dst)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// decryptFile opens a file encrypted at rest with a passphrase or secret key
+// and writes the plain file into dir, returning its path
+func decryptFile(path string, secret string, dir string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	base := filepath.Base(path)
+	plain, err := atrest.Decrypt(in, secret)
+	if errors.Is(err, atrest.ErrNeedsKey) {
+		return "", fmt.Errorf("%s was encrypted for a key pair, use -key", base)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", base, err)
+	}
+
+	dst, err := createUnique(dir, strings.TrimSuffix(base, atrest.Ext))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, plain)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"godrop-common/netaccess"
+	"godrop-common/ratelimit"
+)
+
+// accessCookie holds the grant issued once a visitor enters the security code
+const accessCookie = "godrop_access"
+
+const commandHelp = `Commands:
+  status              Show the current state of the share
+  extend <duration>   Move the expiry (e.g. 10m, -5m); sets one if there is none
+  limit <n>           Change the number of downloads allowed
+  password <code|->   Change the security code, or remove it with "-"
+  pause / resume      Take the link offline without closing it
+  stop                Close the share now
+  help                Show this list`
+
+// Stop signals the server to shut down. It is safe to call more than once.
+func (s *GodropState) Stop(reason string) {
+	s.stopOnce.Do(func() {
+		if reason != "" {
+			fmt.Println(reason)
+		}
+		close(s.done)
+	})
+}
+
+// Closing reports whether shutdown has begun
+func (s *GodropState) Closing() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetExpiry replaces the share's expiry, restarting the timer. A zero time
+// removes the expiry.
+func (s *GodropState) SetExpiry(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+		s.expiryTimer = nil
+	}
+	s.ExpiryTime = t
+	if !t.IsZero() {
+		s.expiryTimer = time.AfterFunc(time.Until(t), func() {
+			s.Stop("\nTimeout reached. Link expired.")
+		})
+	}
+}
+
+// An address that entered maxVerifyFailures wrong codes within verifyWindow
+// has /api/verify stop checking its guesses until the oldest leaves it
+const (
+	maxVerifyFailures = 5
+	verifyWindow      = 15 * time.Minute
+)
+
+var errTooManyFailures = errors.New("too many wrong codes")
+
+// maxRefusedReports bounds the refused addresses remembered, so a scan
+// can't grow the set without limit
+const maxRefusedReports = 1024
+
+// Unlock checks code against the security code and, on a match, hands the
+// visitor a cookie that /api/download accepts. An address that entered too
+// many wrong codes gets errTooManyFailures without a check.
+func (s *GodropState) Unlock(w http.ResponseWriter, r *http.Request, code string) (bool, error) {
+	ip := netaccess.ClientIP(r.RemoteAddr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures.Exceeded(ip) {
+		return false, errTooManyFailures
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(s.SecurityCode)) != 1 {
+		s.failures.Add(ip)
+		fmt.Printf("Wrong code from %s\n", ip)
+		return false, nil
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	grant := hex.EncodeToString(b)
+	s.grants[grant] = true
+	http.SetCookie(w, &http.Cookie{Name: accessCookie, Value: grant, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	return true, nil
+}
+
+// accessGuard turns away clients the -allow and -deny rules don't admit,
+// before any other handler sees the request. Each refused address is
+// reported once.
+func (s *GodropState) accessGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := netaccess.ClientIP(r.RemoteAddr)
+		if s.access.Admits(ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.mu.Lock()
+		if !s.refused[ip.String()] && len(s.refused) < maxRefusedReports {
+			s.refused[ip.String()] = true
+			fmt.Printf("Refused %s: not allowed by -allow/-deny\n", r.RemoteAddr)
+		}
+		s.mu.Unlock()
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// authorized reports whether the request may download. Callers must hold s.mu.
+func (s *GodropState) authorized(r *http.Request) bool {
+	if s.SecurityCode == "" {
+		return true
+	}
+	c, err := r.Cookie(accessCookie)
+	return err == nil && s.grants[c.Value]
+}
+
+// reserveDownload claims a download slot, returning its number and the limit
+// it was claimed against. When the share can't serve a download right now,
+// it returns the HTTP status to reply with. Callers must hold s.mu.
+func (s *GodropState) reserveDownload() (int, int, int, error) {
+	if s.Paused {
+		return 0, 0, http.StatusServiceUnavailable, fmt.Errorf("Share Paused")
+	}
+	if s.CurrentDownloads >= s.DownloadLimit {
+		return 0, 0, http.StatusGone, fmt.Errorf("Limit Exceeded")
+	}
+	if !s.ExpiryTime.IsZero() && time.Now().After(s.ExpiryTime) {
+		return 0, 0, http.StatusGone, fmt.Errorf("Link Expired")
+	}
+	s.CurrentDownloads++
+	return s.CurrentDownloads, s.DownloadLimit, 0, nil
+}
+
+// finishDownload begins shutdown once download num has used up the limit.
+// The limit is read again since it may have been raised meanwhile.
+func (s *GodropState) finishDownload(num int) {
+	s.mu.Lock()
+	exhausted := num >= s.DownloadLimit
+	s.mu.Unlock()
+	if exhausted {
+		s.Stop("\nDownload limit reached. System shutting down...")
+	}
+}
+
+// ReadCommands runs control commands read line by line from in until it is closed
+func (s *GodropState) ReadCommands(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fmt.Println(s.RunCommand(line))
+	}
+}
+
+// RunCommand applies a single control command to the running share and
+// returns a human-readable reply
+func (s *GodropState) RunCommand(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return commandHelp
+	}
+	cmd, args := strings.ToLower(fields[0]), fields[1:]
+
+	switch cmd {
+	case "status":
+		return s.Status()
+
+	case "extend":
+		if len(args) != 1 {
+			return "Usage: extend <duration>"
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return fmt.Sprintf("Invalid duration: %s", args[0])
+		}
+		s.mu.Lock()
+		base := s.ExpiryTime
+		s.mu.Unlock()
+		if base.IsZero() || base.Before(time.Now()) {
+			base = time.Now()
+		}
+		s.SetExpiry(base.Add(d))
+		return fmt.Sprintf("Expiry Time: %s", base.Add(d).Format("15:04:05"))
+
+	case "limit":
+		if len(args) != 1 {
+			return "Usage: limit <n>"
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return "Limit must be a positive number"
+		}
+		s.mu.Lock()
+		s.DownloadLimit = n
+		exhausted := s.CurrentDownloads >= n
+		s.mu.Unlock()
+		if exhausted {
+			s.Stop("\nDownload limit reached. System shutting down...")
+		}
+		return fmt.Sprintf("Downloads Allowed: %d", n)
+
+	case "password", "code":
+		if len(args) != 1 {
+			return "Usage: password <code|->"
+		}
+		code := args[0]
+		if code == "-" {
+			code = ""
+		}
+		s.mu.Lock()
+		s.SecurityCode = code
+		s.grants = make(map[string]bool) // Visitors must unlock again with the new code
+		s.failures = ratelimit.New(maxVerifyFailures, verifyWindow)
+		s.mu.Unlock()
+		s.advertise()
+		if code == "" {
+			return "Security code removed"
+		}
+		return fmt.Sprintf("Security Code REQUIRED: %s", code)
+
+	case "pause", "resume":
+		s.mu.Lock()
+		s.Paused = cmd == "pause"
+		s.mu.Unlock()
+		if cmd == "pause" {
+			return "Share paused"
+		}
+		return "Share resumed"
+
+	case "stop":
+		s.Stop("\nStopped by operator.")
+		return "Stopping..."
+
+	case "help":
+		return commandHelp
+	}
+	return fmt.Sprintf("Unknown command: %s\n%s", cmd, commandHelp)
+}
+
+// Status summarises the share for the status command
+func (s *GodropState) Status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := "LIVE"
+	if s.Paused {
+		state = "PAUSED"
+	}
+	expiry := "none"
+	if !s.ExpiryTime.IsZero() {
+		expiry = s.ExpiryTime.Format("15:04:05")
+	}
+	code := "none"
+	if s.SecurityCode != "" {
+		code = s.SecurityCode
+	}
+	return fmt.Sprintf("%s: %s | Downloads %d/%d | Expires %s | Code %s",
+		state, s.FileName, s.CurrentDownloads, s.DownloadLimit, expiry, code)
+}
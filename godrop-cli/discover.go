@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"godrop-common/certs"
+	"godrop-common/discovery"
+)
+
+// discoverCommand implements `godrop discover`: it lists the shares nearby
+// devices advertise and lets the user download from or upload to one of them
+func discoverCommand(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	wait := fs.Duration("wait", 2*time.Second, "How long to listen for shares")
+	outDir := fs.String("o", ".", "Directory downloads are saved to")
+	fs.Parse(args)
+
+	fmt.Println("Looking for shares nearby...")
+	shares, err := discovery.Browse(context.Background(), *wait)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if len(shares) == 0 {
+		fmt.Println("No shares found nearby.")
+		return
+	}
+
+	fmt.Printf("%-3s %-24s %-30s %-10s %-9s %-5s %s\n", "#", "HOST", "NAME", "SIZE", "MODE", "CODE", "URL")
+	for i, s := range shares {
+		size := "-"
+		if s.Size > 0 {
+			size = formatSize(s.Size)
+		}
+		code := "no"
+		if s.Code || s.Nameplate != "" {
+			code = "yes"
+		}
+		fmt.Printf("%-3d %-24s %-30s %-10s %-9s %-5s %s\n", i+1, s.Host, s.Name, size, s.Mode, code, s.URL)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	choice := prompt(in, "\nPick a share (number, Enter to quit): ")
+	n, err := strconv.Atoi(choice)
+	if err != nil || n < 1 || n > len(shares) {
+		return
+	}
+	share := shares[n-1]
+
+	if share.Nameplate != "" {
+		fmt.Printf("This share needs its code. Ask the sender for it and run: godrop get %s-...\n", share.Nameplate)
+		return
+	}
+	if share.Encrypted {
+		fmt.Println("This share is end-to-end encrypted. Ask the sender for its link and run: godrop get '<link>'")
+		return
+	}
+	switch share.Mode {
+	case "send":
+		code := ""
+		if share.Code {
+			code = prompt(in, "Security code: ")
+		}
+		path, err := downloadShare(share, code, *outDir)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Saved to", path)
+
+	case "receive":
+		fmt.Println("Files to send, one per line (empty line to start):")
+		var files []string
+		for {
+			path := prompt(in, "> ")
+			if path == "" {
+				break
+			}
+			files = append(files, path)
+		}
+		for _, path := range files {
+			fmt.Printf("Sending %s...\n", filepath.Base(path))
+			if err := uploadShare(share, path); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+		if len(files) > 0 {
+			fmt.Println("Done.")
+		}
+
+	default:
+		fmt.Printf("Open %s in a browser.\n", share.URL)
+	}
+}
+
+// prompt prints label and reads one trimmed line from in
+func prompt(in *bufio.Reader, label string) string {
+	fmt.Print(label)
+	line, _ := in.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// pinnedClient returns an HTTP client for a share whose certificate has
+// fingerprint fp, or a plain one when fp is empty
+func pinnedClient(fp string) *http.Client {
+	if fp == "" {
+		return &http.Client{}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: certs.PinnedTLS(fp), ForceAttemptHTTP2: true}}
+}
+
+// shareClient returns an HTTP client for share, entering code first when the
+// share asks for one so the access cookie is sent along afterwards
+func shareClient(share discovery.Share, code string) (*http.Client, error) {
+	jar, _ := cookiejar.New(nil)
+	client := pinnedClient(share.Fingerprint)
+	client.Jar = jar
+	if !share.Code {
+		return client, nil
+	}
+
+	body, _ := json.Marshal(map[string]string{"code": code})
+	resp, err := client.Post(share.Endpoint("/api/verify"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct{ Success bool }
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Success {
+		return nil, fmt.Errorf("wrong security code")
+	}
+	return client, nil
+}
+
+// downloadShare saves the file offered by share into dir without overwriting
+// anything, and returns where it went
+func downloadShare(share discovery.Share, code string, dir string) (string, error) {
+	client, err := shareClient(share, code)
+	if err != nil {
+		return "", err
+	}
+	resp, err := fetchTransfer(client, share)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	name := share.Name
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	name = safeName(name)
+	dst, err := createUnique(dir, name)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Downloading %s...\n", name)
+	_, err = io.Copy(dst, resp.Body)
+	dst.Close()
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// fetchTransfer starts the download of share, failing unless the server
+// answers with a file
+func fetchTransfer(client *http.Client, share discovery.Share) (*http.Response, error) {
+	// A link doesn't say which app made it: the desktop app serves the file
+	// at /download, the CLI at /api/download
+	transfers := []string{share.Transfer}
+	if share.Transfer == "" {
+		transfers = []string{"/download", "/api/download"}
+	}
+	var resp *http.Response
+	var err error
+	for i, transfer := range transfers {
+		resp, err = client.Get(share.Endpoint(transfer))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusNotFound || i == len(transfers)-1 {
+			break
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return nil, fmt.Errorf("%s", bytes.TrimSpace(msg))
+	}
+	if resp.Header.Get("Content-Disposition") == "" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("there is no file to download at %s", share.URL)
+	}
+	return resp, nil
+}
+
+// shareFromURL turns a share link into a Share, and the key of an encrypted
+// share if the link has one. An https link must carry the certificate
+// fingerprint (#sha256=...), which is then the only certificate accepted.
+func shareFromURL(raw string) (discovery.Share, []byte, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return discovery.Share{}, nil, err
+	}
+	port, _ := strconv.Atoi(u.Port())
+	share := discovery.Share{IP: u.Hostname(), Port: port, URL: raw}
+	fragment, _ := url.ParseQuery(u.Fragment)
+	switch u.Scheme {
+	case "http":
+		if port == 0 {
+			share.Port = 80
+		}
+	case "https":
+		share.Fingerprint = fragment.Get("sha256")
+		if share.Fingerprint == "" {
+			return discovery.Share{}, nil, fmt.Errorf("the link has no certificate fingerprint (#sha256=...) to check the share against")
+		}
+		if port == 0 {
+			share.Port = 443
+		}
+	default:
+		return discovery.Share{}, nil, fmt.Errorf("unsupported link %s", raw)
+	}
+
+	var key []byte
+	if k := fragment.Get("key"); k != "" {
+		if key, err = base64.RawURLEncoding.DecodeString(k); err != nil || len(key) != 32 {
+			return discovery.Share{}, nil, fmt.Errorf("the key in the link is damaged, was it copied completely?")
+		}
+	}
+	return share, key, nil
+}
+
+// uploadShare sends the file at path to a receive share, streaming it
+func uploadShare(share discovery.Share, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	resp, err := pinnedClient(share.Fingerprint).Post(share.Endpoint(share.Transfer), mw.FormDataContentType(), pr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// safeName reduces a file name suggested by the other side to a bare name,
+// so it can't point outside the download folder
+func safeName(name string) string {
+	name = filepath.Base(name)
+	if name == "." || name == string(filepath.Separator) {
+		return "godrop-download"
+	}
+	return name
+}
+
+// createUnique creates name in dir, adding " (1)", " (2)"... before the
+// extension when a file of that name already exists
+func createUnique(dir string, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+		}
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// formatSize renders a byte count the way people read it, e.g. 4.2 MB
+func formatSize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(n)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
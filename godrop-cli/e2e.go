@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"godrop-common/discovery"
+	"godrop-common/stream"
+)
+
+// With -e2e the file is encrypted before it is served, under a random key
+// that only appears in the link's fragment (#key=...). Browsers never send
+// the fragment, so the file sits encrypted on this machine and passes any
+// proxy encrypted. The landing page decrypts it with WebCrypto as it arrives,
+// and `godrop get <link>` does the same natively. The format is package
+// stream's, from godrop-common.
+
+// fileMeta is what the receiver learns about a file before it arrives
+type fileMeta struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// deriveKey derives a key for one purpose from a shared secret, so the same
+// key never encrypts two kinds of data
+func deriveKey(secret []byte, purpose string) []byte {
+	key, err := hkdf.Key(sha256.New, secret, nil, "godrop "+purpose, 32)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// newLinkKey makes a key for an encrypted share and its form in the link
+func newLinkKey() ([]byte, string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	return key, base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// encryptFile writes an encrypted copy of src into dir and returns its path
+func encryptFile(src string, dir string, key []byte) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(dir, "godrop-*.enc")
+	if err != nil {
+		return "", err
+	}
+	sw, err := stream.NewWriter(deriveKey(key, "e2e file"), out)
+	if err == nil {
+		if _, err = io.Copy(sw, in); err == nil {
+			err = sw.Close()
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// sealFileMeta encrypts the name and size the landing page shows, so the
+// server's /api/stats gives nothing away either
+func sealFileMeta(key []byte, name string, size int64) ([]byte, error) {
+	meta, _ := json.Marshal(fileMeta{Name: name, Size: size})
+	return stream.SealMessage(deriveKey(key, "e2e metadata"), meta)
+}
+
+// downloadEncrypted fetches an encrypted share given by its link and saves
+// the decrypted file into dir
+func downloadEncrypted(share discovery.Share, code string, key []byte, dir string) (string, error) {
+	client, err := shareClient(share, code)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Get(share.Endpoint("/api/stats"))
+	if err != nil {
+		return "", err
+	}
+	var stats struct {
+		Encrypted bool
+		Meta      []byte
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+	if err != nil || !stats.Encrypted {
+		return "", fmt.Errorf("the link has a key, but the share isn't encrypted")
+	}
+	metaJSON, err := stream.OpenMessage(deriveKey(key, "e2e metadata"), stats.Meta)
+	if err != nil {
+		return "", fmt.Errorf("the key in the link doesn't fit this share")
+	}
+	var meta fileMeta
+	if err := json.Unmarshal(metaJSON, &meta); err != nil {
+		return "", err
+	}
+
+	resp, err = fetchTransfer(client, share)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	sr, err := stream.NewReader(deriveKey(key, "e2e file"), resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := createUnique(dir, safeName(meta.Name))
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Downloading and decrypting %s (%s)...\n", meta.Name, formatSize(meta.Size))
+	_, err = io.Copy(dst, sr)
+	dst.Close()
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
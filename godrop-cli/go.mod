@@ -2,4 +2,10 @@ module godrop
 
 go 1.25.3
 
-require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e // indirect
+require (
+	filippo.io/edwards25519 v1.2.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	godrop-common v0.0.0
+)
+
+replace godrop-common => ../godrop-common
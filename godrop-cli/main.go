@@ -2,58 +2,180 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"godrop-common/certs"
+	"godrop-common/discovery"
+	"godrop-common/netaccess"
+	"godrop-common/ratelimit"
+	"godrop-common/workdir"
+
 	"github.com/skip2/go-qrcode"
 )
 
 type GodropState struct {
+	ID               string // Identifies the share to `godrop status` and `godrop stop`
+	URL              string
+	Port             int
 	FileName         string
 	FilePath         string
 	FileSize         int64
 	DownloadLimit    int
 	CurrentDownloads int
 	SecurityCode     string
+	Fingerprint      string // SHA-256 of the certificate with -tls, empty over plain HTTP
+	Encrypted        bool   // -e2e: FilePath is encrypted with the key in the link
+	sealedMeta       []byte // Name and size, encrypted for the landing page
 	StartTime        time.Time
 	ExpiryTime       time.Time
 	IsTemp           bool       // If true, the file is a temporary zip and should be deleted on exit
+	Paused           bool       // While paused the link stays up but downloads are refused
 	mu               sync.Mutex // The "Key" that ensures only one goroutine touches 'CurrentDownloads' at a time
-	done             chan bool
+	grants           map[string]bool
+	failures         *ratelimit.Limiter // Wrong codes by address
+	access           *netaccess.List    // Clients the share admits, from -allow and -deny
+	refused          map[string]bool    // Addresses already reported as refused
+	expiryTimer      *time.Timer
+	stopOnce         sync.Once
+	done             chan struct{}
+	mdns             *discovery.Responder // Advertises the share on the local network, nil when off
+	wormhole         *wormhole            // Set for `godrop send` shares, which need the code instead of a link
 }
 
 func main() {
+	// --- PART 0: SUBCOMMANDS ---
+	// These talk to shares that are already running in other godrop processes
+	sendMode := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "status":
+			statusCommand()
+			return
+		case "stop":
+			stopCommand(os.Args[2:])
+			return
+		case "cleanup":
+			cleanupCommand(os.Args[2:])
+			return
+		case "discover":
+			discoverCommand(os.Args[2:])
+			return
+		case "get":
+			getCommand(os.Args[2:])
+			return
+		case "decrypt":
+			decryptCommand(os.Args[2:])
+			return
+		case "keygen":
+			keygenCommand(os.Args[2:])
+			return
+		case "send":
+			// A normal share, but found and unlocked with a short code
+			sendMode = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	// --- PART 1: CLI FLAGS ---
 	limit := flag.Int("limit", 1, "Number of downloads allowed before the server stops")
 	code := flag.String("code", "", "Optional security code the user must enter on the landing page")
 	port := flag.String("port", "8080", "The port the web server will listen on")
 	timeout := flag.Duration("timeout", 0, "Time limit for the share (e.g. 10m, 1h). 0 means no timeout.")
+	iface := flag.String("interface", "", "Network interface whose address goes into the share link (e.g. wlan0)")
+	ipFlag := flag.String("ip", "", "IP address to put in the share link, overriding -interface")
+	bind := flag.String("bind", "all", `Where to listen: "all", "localhost", an interface name or an IP`)
+	allow := flag.String("allow", netaccess.Subnet, `Comma separated clients to admit: IPs, CIDR blocks, interfaces, "subnet", "localhost" or "any"`)
+	deny := flag.String("deny", "", "Comma separated clients to turn away, in the same forms as -allow; these win over -allow")
+	workRoot := flag.String("workdir", workdir.DefaultRoot(), "Directory for temporary zips (a per-process folder is created inside)")
+	mdns := flag.Bool("mdns", true, "Advertise the share on the local network over mDNS")
+	useTLS := flag.Bool("tls", false, "Serve over HTTPS with a self-signed certificate made for this share")
+	e2e := flag.Bool("e2e", false, "Encrypt the file end to end; the key only goes into the link (implies -tls)")
+	grace := flag.Duration("grace", 30*time.Second, "How long to wait for active transfers to finish when shutting down")
 	flag.Parse()
 
 	// Get any remaining arguments (these are the file paths)
 	files := flag.Args()
 	if len(files) == 0 {
 		fmt.Println("Usage: godrop [-limit <n>] [-code <code>] [-timeout <duration>] <file1> [file2...]")
+		fmt.Println("       godrop status")
+		fmt.Println("       godrop stop <id>")
+		fmt.Println("       godrop cleanup [-workdir <dir>]")
+		fmt.Println("       godrop send [-limit <n>] <file1> [file2...]")
+		fmt.Println("       godrop get [-o <dir>] <code>")
+		fmt.Println("       godrop decrypt [-key <file>] [-o <dir>] <file.godrop...>")
+		fmt.Println("       godrop keygen [-o <file>]")
+		return
+	}
+	if *e2e {
+		// Browsers only offer WebCrypto on HTTPS pages, so -e2e needs -tls
+		// unless it was turned off explicitly for `godrop get` only
+		tlsSet := false
+		flag.Visit(func(f *flag.Flag) {
+			tlsSet = tlsSet || f.Name == "tls"
+		})
+		if !tlsSet {
+			*useTLS = true
+		}
+	}
+	if sendMode && *e2e {
+		fmt.Println("Error: godrop send always encrypts end to end, -e2e can't be used with it.")
+		return
+	}
+	if sendMode && *code != "" {
+		fmt.Println("Error: godrop send makes up its own code, -code can't be used with it.")
+		return
+	}
+	if sendMode && (!*mdns || *bind == "localhost") {
+		fmt.Println("Error: godrop send needs mDNS so the receiver can find it.")
+		return
+	}
+	access, err := netaccess.New(netaccess.Clean(strings.Split(*allow, ",")), netaccess.Clean(strings.Split(*deny, ",")))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	// Sweep zips left behind by godrop processes that crashed, then claim our own folder
+	if removed, err := workdir.Sweep(*workRoot); err == nil && len(removed) > 0 {
+		fmt.Printf("Removed %d stale archive folder(s) from crashed sessions.\n", len(removed))
+	}
+	work, err := workdir.Open(*workRoot)
+	if err != nil {
+		fmt.Println("Error creating work directory:", err)
 		return
 	}
+	// Removing our folder also removes the temporary zip, whichever way we exit
+	defer work.Close()
 
 	var targetFile string
 	var isTemp bool
 	var fileName string
 	var fileSize int64
 
+	// Catch Ctrl+C and SIGTERM ourselves so the temporary zip is always removed
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
 	// --- PART 2: FILE PREPARATION ---
 	if len(files) > 1 {
 		// If multiple files are provided, we bundle them into a single .zip file
 		fmt.Println("Packaging multiple files into a temporary archive...")
-		tmpZip, err := os.CreateTemp("", "godrop-*.zip")
+		tmpZip, err := os.CreateTemp(work.Path, "godrop-*.zip")
 		if err != nil {
 			fmt.Println("Error creating temp zip:", err)
 			return
@@ -62,6 +184,15 @@ func main() {
 		// Setup the zip writer
 		zw := zip.NewWriter(tmpZip)
 		for _, f := range files {
+			select {
+			case <-sigs:
+				fmt.Println("\nInterrupted. Removing temporary archive...")
+				zw.Close()
+				tmpZip.Close()
+				os.Remove(tmpZip.Name())
+				return
+			default:
+			}
 			if err := addFileToZip(zw, f); err != nil {
 				fmt.Printf("Warning: Failed to add %s to zip: %v\n", f, err)
 			}
@@ -86,38 +217,163 @@ func main() {
 		fileSize = fileInfo.Size()
 	}
 
+	// With -e2e only an encrypted copy is served; the key goes into the link
+	var linkKey string
+	var sealedMeta []byte
+	if *e2e {
+		fmt.Println("Encrypting...")
+		key, encoded, err := newLinkKey()
+		var encPath string
+		if err == nil {
+			encPath, err = encryptFile(targetFile, work.Path, key)
+		}
+		if err == nil {
+			sealedMeta, err = sealFileMeta(key, fileName, fileSize)
+		}
+		if err != nil {
+			fmt.Println("Error encrypting file:", err)
+			return
+		}
+		if isTemp {
+			os.Remove(targetFile)
+		}
+		targetFile, isTemp, linkKey = encPath, true, encoded
+	}
+
 	// Initialize our state object
 	state := &GodropState{
+		ID:            strconv.Itoa(os.Getpid()),
 		FileName:      fileName,
 		FilePath:      targetFile,
 		FileSize:      fileSize,
 		DownloadLimit: *limit,
 		SecurityCode:  *code,
+		Encrypted:     *e2e,
+		sealedMeta:    sealedMeta,
 		StartTime:     time.Now(),
 		IsTemp:        isTemp,
-		done:          make(chan bool),
+		grants:        make(map[string]bool),
+		failures:      ratelimit.New(maxVerifyFailures, verifyWindow),
+		access:        access,
+		refused:       make(map[string]bool),
+		done:          make(chan struct{}),
 	}
 
 	// --- PART 3: TIMEOUT LOGIC ---
+	// The expiry timer can be moved later with the "extend" command
 	if *timeout > 0 {
-		state.ExpiryTime = state.StartTime.Add(*timeout)
-		// Run a background "timer" that shuts down the server when time is up
-		go func() {
-			time.Sleep(*timeout)
-			fmt.Println("\nTimeout reached. Link expired.")
-			state.done <- true
-		}()
+		state.SetExpiry(state.StartTime.Add(*timeout))
 	}
 
 	// --- PART 4: NETWORK & QR CODE ---
 	// Get the local IP address so we can generate the correct link
-	ip := GetOutboundIP()
-	fullURL := fmt.Sprintf("http://%s:%s", ip, *port)
+	pref := *iface
+	if *ipFlag != "" {
+		pref = *ipFlag
+	}
+	ip, err := ResolveIP(pref)
+	if err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println("Available interfaces:")
+		for _, n := range ListInterfaces() {
+			fmt.Printf("  %-12s %s\n", n.Name, n.IP)
+		}
+		return
+	}
+	if ip == "127.0.0.1" {
+		fmt.Println("Warning: no network interface found, the link only works on this machine.")
+	}
+
+	// Open the listeners now, so a busy port or bad -bind is reported before we print links
+	hosts, err := BindAddresses(*bind)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	var listeners []net.Listener
+	for _, host := range hosts {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, *port))
+		if err != nil {
+			fmt.Println("Error:", err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return
+		}
+		listeners = append(listeners, ln)
+	}
+
+	// One link per address we can be reached on, the preferred one first.
+	// When bound to specific addresses, the preferred IP must be one of them.
+	reach := ReachableAddresses(*bind)
+	if *bind != "all" && len(reach) > 0 && !hasAddress(reach, ip) {
+		ip = reach[0].IP
+	}
+	// With -tls every run gets a new certificate; its key never touches the disk
+	var cert tls.Certificate
+	if *useTLS {
+		names := []string{ip, "localhost", discovery.Hostname() + ".local"}
+		for _, addr := range reach {
+			names = append(names, addr.IP)
+		}
+		cert, state.Fingerprint, err = certs.NewCertificate(names)
+		if err != nil {
+			fmt.Println("Error creating certificate:", err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return
+		}
+	}
+
+	links := []string{ShareURL(ip, *port, state.Fingerprint, linkKey)}
+	for _, addr := range reach {
+		if addr.IP != ip {
+			links = append(links, ShareURL(addr.IP, *port, state.Fingerprint, linkKey))
+		}
+	}
+	fullURL := links[0]
+	state.URL = fullURL
+	state.Port, _ = strconv.Atoi(*port)
+
+	// Advertise the share over mDNS, which also makes <host>-godrop.local
+	// resolve to us. A share bound to localhost is of no use to anyone else.
+	if *mdns && *bind != "localhost" {
+		addrs := func() []string {
+			list := []string{ip}
+			for _, addr := range reach {
+				list = append(list, addr.IP)
+			}
+			return list
+		}
+		if r, err := discovery.NewResponder(addrs); err != nil && sendMode {
+			fmt.Println("Error: the share can't be advertised on the network:", err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return
+		} else if err != nil {
+			fmt.Println("Warning: the share won't be advertised on the network:", err)
+		} else {
+			state.mdns = r
+			links = append(links, ShareURL(r.Host(), *port, state.Fingerprint, linkKey))
+		}
+	}
+
+	if sendMode {
+		state.wormhole = newWormhole(pickCode())
+	}
 
 	fmt.Println("----------------------------------------")
-	// Generate the QR code for terminal display
-	q, _ := qrcode.New(fullURL, qrcode.Medium)
-	fmt.Println(q.ToString(false))
+	// Generate a QR code for each link for terminal display
+	for _, link := range links {
+		if sendMode {
+			break // The code is the only way in
+		}
+		q, _ := qrcode.New(link, qrcode.Medium)
+		fmt.Println(q.ToString(false))
+		fmt.Printf("^ %s\n\n", link)
+	}
 	fmt.Printf("Hosting: %s\n", state.FileName)
 	fmt.Printf("Downloads Allowed: %d\n", state.DownloadLimit)
 	if state.SecurityCode != "" {
@@ -126,7 +382,19 @@ func main() {
 	if *timeout > 0 {
 		fmt.Printf("Expiry Time: %s\n", state.ExpiryTime.Format("15:04:05"))
 	}
-	fmt.Printf("Share Link: %s\n", fullURL)
+	if sendMode {
+		fmt.Printf("Code: %s\n", state.wormhole.code)
+		fmt.Printf("On the other computer, run: godrop get %s\n", state.wormhole.code)
+	} else {
+		fmt.Printf("Share Link: %s\n", fullURL)
+	}
+	if state.Encrypted {
+		fmt.Println("End-to-end encrypted: the key is in the link after '#' and never reaches this server.")
+	}
+	if state.Fingerprint != "" {
+		fmt.Printf("Certificate (SHA-256): %s\n", certs.FormatFingerprint(state.Fingerprint))
+	}
+	fmt.Printf("Share ID: %s (godrop stop %s)\n", state.ID, state.ID)
 	fmt.Println("----------------------------------------")
 
 	// --- PART 5: HTTP ROUTES (The SaaS API) ---
@@ -145,12 +413,21 @@ func main() {
 			"Limit":      state.DownloadLimit,
 			"Current":    state.CurrentDownloads,
 			"HasCode":    state.SecurityCode != "",
+			"Unlocked":   state.authorized(r),
+			"Paused":     state.Paused,
 			"StartTime":  state.StartTime.Unix(),
 			"ExpiryTime": state.ExpiryTime.Unix(),
 		}
 		if state.ExpiryTime.IsZero() {
 			stats["ExpiryTime"] = 0
 		}
+		// The page decrypts the real name and size with the key in the link
+		stats["Encrypted"] = state.Encrypted
+		if state.Encrypted {
+			stats["FileName"] = ""
+			stats["FileSize"] = 0
+			stats["Meta"] = state.sealedMeta
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(stats)
 	})
@@ -163,64 +440,117 @@ func main() {
 			return
 		}
 
-		state.mu.Lock()
-		success := body.Code == state.SecurityCode
-		state.mu.Unlock()
+		success, err := state.Unlock(w, r, body.Code)
 
 		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
 		json.NewEncoder(w).Encode(map[string]bool{"success": success})
 	})
 
 	// 4. API: Download - The actual file transfer endpoint
 	http.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
-		state.mu.Lock()
-		// Check limits before allowing download
-		if state.CurrentDownloads >= state.DownloadLimit {
-			state.mu.Unlock()
-			http.Error(w, "Limit Exceeded", http.StatusGone)
+		// Once shutdown has begun, only transfers already running are finished
+		if state.Closing() {
+			http.Error(w, "Shutting Down", http.StatusServiceUnavailable)
 			return
 		}
-		// Check timeout before allowing download
-		if !state.ExpiryTime.IsZero() && time.Now().After(state.ExpiryTime) {
+
+		state.mu.Lock()
+		// Refuse visitors who have not entered the current security code
+		if !state.authorized(r) {
 			state.mu.Unlock()
-			http.Error(w, "Link Expired", http.StatusGone)
+			http.Error(w, "Security Code Required", http.StatusForbidden)
 			return
 		}
-
-		state.CurrentDownloads++
-		myNum := state.CurrentDownloads
+		// Check pause, limit and timeout before allowing download
+		myNum, myLimit, status, err := state.reserveDownload()
 		state.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
 
-		fmt.Printf("[%d/%d] Sending file to %s...\n", myNum, state.DownloadLimit, r.RemoteAddr)
+		fmt.Printf("[%d/%d] Sending file to %s...\n", myNum, myLimit, r.RemoteAddr)
 
 		// Set headers to tell the browser this IS a file download
-		w.Header().Set("Content-Disposition", "attachment; filename="+state.FileName)
+		name := state.FileName
+		if state.Encrypted {
+			name = "encrypted.godrop"
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename="+name)
 		http.ServeFile(w, r, state.FilePath)
 
-		// If this was the last allowed download, signal shutdown after a short delay
-		if myNum >= state.DownloadLimit {
-			go func() {
-				time.Sleep(2 * time.Second) // Wait for the network packet to clear
-				fmt.Println("\nDownload limit reached. System shutting down...")
-				state.done <- true
-			}()
-		}
+		// If this was the last allowed download, begin shutdown. Other transfers
+		// still running are drained rather than cut off.
+		state.finishDownload(myNum)
 	})
 
 	// --- PART 6: SERVER LIFECYCLE ---
-	server := &http.Server{Addr: ":" + *port}
+	var handler http.Handler = http.DefaultServeMux
+	if sendMode {
+		handler = state.wormholeHandler()
+	}
+	server := &http.Server{Handler: state.accessGuard(handler)}
+	if *useTLS {
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			fmt.Printf("GODROP Server Live on %s\n", ln.Addr())
+			var err error
+			if *useTLS {
+				err = server.ServeTLS(ln, "", "") // Also negotiates HTTP/2
+			} else {
+				err = server.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				// Stop rather than exit so the temporary zip is still cleaned up
+				state.Stop(fmt.Sprintf("Critical Server Error: %v", err))
+			}
+		}(ln)
+	}
+
+	// Ctrl+C or SIGTERM starts a graceful shutdown; a second one skips the wait
 	go func() {
-		fmt.Printf("GODROP Server Live on :%s\n", *port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Println("Critical Server Error:", err)
-			os.Exit(1)
-		}
+		<-sigs
+		state.Stop("\nInterrupted. Shutting down...")
+		<-sigs
+		server.Close()
 	}()
 
-	// Wait for the shutdown signal (either from timeout or download limit)
+	state.advertise()
+
+	// Expose the control socket so other godrop processes can find and stop us
+	control, err := state.ListenControl()
+	if err != nil {
+		fmt.Println("Warning: control socket unavailable:", err)
+	}
+
+	// Accept control commands (extend, limit, password, pause...) on stdin
+	fmt.Println("Type 'help' for live control commands.")
+	go state.ReadCommands(os.Stdin)
+
+	// Wait for the shutdown signal (timeout, download limit, "stop" or a signal)
 	<-state.done
 	fmt.Println("Closing connections...")
-	server.Close()
+	if state.mdns != nil {
+		state.mdns.Close() // Tell nearby devices the share is gone
+	}
+	// Shutdown stops accepting connections and waits for active transfers
+	ctx, cancel := context.WithTimeout(context.Background(), *grace)
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println("Grace period over, dropping remaining transfers.")
+		server.Close()
+	}
+	cancel()
+	if control != nil {
+		control.Close()
+		os.Remove(socketPath(state.ID))
+	}
 
 	// Final Cleanup: delete the zip if we created one
 	if isTemp {
@@ -0,0 +1,40 @@
+package main
+
+import "godrop-common/discovery"
+
+// The share is advertised on the local network over multicast DNS and
+// DNS-SD (godrop-common/discovery), so nearby devices can find it without a
+// QR code
+
+// advertise publishes the share on r, or refreshes it after a change such as
+// a new security code
+func (s *GodropState) advertise() {
+	s.mu.Lock()
+	r := s.mdns
+	svc := discovery.Service{
+		ID:   s.ID,
+		Name: s.FileName,
+		Mode: "send",
+		Size: s.FileSize,
+		Code: s.SecurityCode != "",
+		Port: s.Port,
+		Path: "/",
+
+		Transfer: "/api/download",
+
+		Fingerprint: s.Fingerprint,
+	}
+	if s.Encrypted {
+		// The name and size are part of what the link's key protects
+		svc = discovery.Service{ID: s.ID, Mode: "send", Code: s.SecurityCode != "", Port: s.Port, Path: "/", Fingerprint: s.Fingerprint, Encrypted: true}
+	}
+	if s.wormhole != nil {
+		// The code protects the name and size too; only the nameplate is public
+		nameplate, _, _ := parseCode(s.wormhole.code)
+		svc = discovery.Service{ID: s.ID, Mode: "send", Code: true, Port: s.Port, Nameplate: nameplate, Fingerprint: s.Fingerprint}
+	}
+	s.mu.Unlock()
+	if r != nil {
+		r.Set(svc)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"filippo.io/edwards25519"
+)
+
+// SPAKE2 (RFC 9382) over edwards25519, the SPAKE2-edwards25519-SHA256-HKDF-HMAC
+// suite. Both sides mix the share code into their Diffie-Hellman messages, so
+// only someone who knows the code ends up with the same key. An eavesdropper
+// learns nothing, and an attacker gets one guess per exchange, which the
+// sender counts.
+//
+// The curve arithmetic is filippo.io/edwards25519, which is constant time. M
+// and N are the RFC's, which it derives by hashing a seed onto the curve so
+// that nobody knows their discrete logarithms (pake_test.go checks them).
+
+const (
+	pakeIDA = "godrop-get"  // The side that asks for the file
+	pakeIDB = "godrop-send" // The side that offers it
+)
+
+var (
+	errPakeMismatch = errors.New("wrong code")
+	errPakeMessage  = errors.New("invalid key exchange message")
+	pakeM           = mustPoint("d048032c6ea0b6d697ddc2e86bda85a33adac920f1bf18e1b0c6d166a5cecdaf")
+	pakeN           = mustPoint("d3bfb518f44f3430f29d0c92af503865a1ed3281dc69b35dd868ba85f886c4ab")
+)
+
+func mustPoint(h string) *edwards25519.Point {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		panic(err)
+	}
+	p, err := new(edwards25519.Point).SetBytes(b)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// spake is one side of an exchange in progress
+type spake struct {
+	idA  bool // We are side A
+	w    *edwards25519.Scalar
+	x    *edwards25519.Scalar // Our secret scalar
+	mine []byte               // Our public message
+}
+
+// pakePassword turns the share code into the scalar w. The RFC asks for a
+// memory-hard function here to slow down offline guessing, but only the two
+// sides ever see anything derived from w, and online guesses are counted.
+func pakePassword(password string) *edwards25519.Scalar {
+	h := sha512.Sum512([]byte("godrop SPAKE2 password\x00" + password))
+	w, _ := edwards25519.NewScalar().SetUniformBytes(h[:])
+	return w
+}
+
+// newSpake starts an exchange for password. The returned message goes to the
+// other side.
+func newSpake(sideA bool, password string) (*spake, []byte, error) {
+	seed := make([]byte, 64)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, nil, err
+	}
+	x, _ := edwards25519.NewScalar().SetUniformBytes(seed)
+	s, msg := startSpake(sideA, pakePassword(password), x)
+	return s, msg, nil
+}
+
+// startSpake builds our message x·G + w·M (side A) or x·G + w·N (side B)
+func startSpake(sideA bool, w, x *edwards25519.Scalar) (*spake, []byte) {
+	blind := pakeN
+	if sideA {
+		blind = pakeM
+	}
+	p := new(edwards25519.Point).ScalarBaseMult(x)
+	p.Add(p, new(edwards25519.Point).ScalarMult(w, blind))
+	s := &spake{idA: sideA, w: w, x: x, mine: p.Bytes()}
+	return s, s.mine
+}
+
+// finish combines the other side's message into the shared secrets. key is
+// the session key, and confirmA and confirmB are the MACs each side sends to
+// prove it derived the same key.
+func (s *spake) finish(theirs []byte) (key, confirmA, confirmB []byte, err error) {
+	p, err := new(edwards25519.Point).SetBytes(theirs)
+	if err != nil {
+		return nil, nil, nil, errPakeMessage
+	}
+
+	// Remove their blinding and clear the cofactor:
+	// K = h·x·(theirs - w·N) on side A, h·x·(theirs - w·M) on side B
+	blind := pakeM
+	if s.idA {
+		blind = pakeN
+	}
+	p.Subtract(p, new(edwards25519.Point).ScalarMult(s.w, blind))
+	p.MultByCofactor(p)
+	k := new(edwards25519.Point).ScalarMult(s.x, p)
+	if k.Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, nil, nil, errPakeMessage
+	}
+
+	pA, pB := s.mine, theirs
+	if !s.idA {
+		pA, pB = theirs, s.mine
+	}
+	var tt []byte
+	for _, part := range [][]byte{[]byte(pakeIDA), []byte(pakeIDB), pA, pB, k.Bytes(), s.w.Bytes()} {
+		tt = binary.LittleEndian.AppendUint64(tt, uint64(len(part)))
+		tt = append(tt, part...)
+	}
+
+	sum := sha256.Sum256(tt)
+	ke, ka := sum[:16], sum[16:]
+	kc, err := hkdf.Key(sha256.New, ka, nil, "ConfirmationKeys", 32)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ke, pakeMAC(kc[:16], tt), pakeMAC(kc[16:], tt), nil
+}
+
+func pakeMAC(key []byte, tt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(tt)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"filippo.io/edwards25519"
+)
+
+// exchangeKeys runs both sides of an exchange, passing B's message through
+// tamper on its way to A
+func exchangeKeys(t *testing.T, codeA, codeB string, tamper func([]byte) []byte) (a, b [3][]byte, errA error) {
+	t.Helper()
+	spA, msgA, err := newSpake(true, codeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spB, msgB, err := newSpake(false, codeB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		msgB = tamper(bytes.Clone(msgB))
+	}
+	b[0], b[1], b[2], err = spB.finish(msgA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a[0], a[1], a[2], errA = spA.finish(msgB)
+	return a, b, errA
+}
+
+func TestPakeMatchingCode(t *testing.T) {
+	a, b, err := exchangeKeys(t, "crimson-otter-lantern", "crimson-otter-lantern", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"key", "confirmA", "confirmB"} {
+		if !bytes.Equal(a[i], b[i]) {
+			t.Errorf("%s differs between the sides", name)
+		}
+	}
+	if len(a[0]) != 16 {
+		t.Errorf("key is %d bytes, want 16", len(a[0]))
+	}
+	if bytes.Equal(a[1], a[2]) {
+		t.Error("both sides send the same confirmation")
+	}
+
+	// A second exchange with the same code ends up with a different key
+	c, _, err := exchangeKeys(t, "crimson-otter-lantern", "crimson-otter-lantern", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a[0], c[0]) {
+		t.Error("two exchanges derived the same key")
+	}
+}
+
+func TestPakeWrongCode(t *testing.T) {
+	a, b, err := exchangeKeys(t, "crimson-otter-lantern", "crimson-otter-lanterm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a[0], b[0]) {
+		t.Error("a wrong code derived the same key")
+	}
+	if hmac.Equal(a[1], b[1]) || hmac.Equal(a[2], b[2]) {
+		t.Error("a wrong code passed confirmation")
+	}
+}
+
+func TestPakeTamperedMessage(t *testing.T) {
+	flip := func(msg []byte) []byte {
+		msg[3] ^= 0x10
+		return msg
+	}
+	a, b, err := exchangeKeys(t, "crimson-otter-lantern", "crimson-otter-lantern", flip)
+	if err == nil && (bytes.Equal(a[0], b[0]) || hmac.Equal(a[2], b[2])) {
+		t.Error("a tampered message went unnoticed")
+	}
+
+	for _, msg := range [][]byte{
+		nil,
+		make([]byte, 31),
+		append([]byte{2}, make([]byte, 31)...), // y = 2 is not on the curve
+	} {
+		sp, _, err := newSpake(true, "crimson-otter-lantern")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := sp.finish(msg); err == nil {
+			t.Errorf("finish(%x) accepted an invalid message", msg)
+		}
+	}
+}
+
+// A message of w·N plus a small order point leaves nothing after the
+// cofactor is cleared, which finish must refuse rather than derive a key from
+func TestPakeSmallOrder(t *testing.T) {
+	sp, _, err := newSpake(true, "crimson-otter-lantern")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (0, -1) has order 2
+	order2 := mustPoint("ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f")
+	msg := new(edwards25519.Point).ScalarMult(sp.w, pakeN)
+	msg.Add(msg, order2)
+	if _, _, _, err := sp.finish(msg.Bytes()); err != errPakeMessage {
+		t.Errorf("finish accepted a small order point: %v", err)
+	}
+}
+
+// TestPakePoints derives M and N the way RFC 9382 does: hash its seed until
+// the hash decodes to a point of prime order
+func TestPakePoints(t *testing.T) {
+	one, _ := edwards25519.NewScalar().SetCanonicalBytes(append([]byte{1}, make([]byte, 31)...))
+	minusOne := edwards25519.NewScalar().Negate(one)
+
+	for _, tc := range []struct {
+		name string
+		want *edwards25519.Point
+	}{{"M", pakeM}, {"N", pakeN}} {
+		h := []byte(fmt.Sprintf("edwards25519 point generation seed (%s)", tc.name))
+		var got *edwards25519.Point
+		for i := 1; i < 1000 && got == nil; i++ {
+			sum := sha256.Sum256(h)
+			h = sum[:]
+			p, err := new(edwards25519.Point).SetBytes(h)
+			if err != nil || p.Equal(edwards25519.NewIdentityPoint()) == 1 {
+				continue
+			}
+			// (l-1)·P + P is the identity only for points of order l
+			q := new(edwards25519.Point).ScalarMult(minusOne, p)
+			if q.Add(q, p).Equal(edwards25519.NewIdentityPoint()) == 1 {
+				got = p
+			}
+		}
+		if got == nil || got.Equal(tc.want) != 1 {
+			t.Errorf("%s is not the point derived from its seed", tc.name)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"godrop-common/workdir"
+)
+
+// ShareInfo is what a running share reports over its control socket
+type ShareInfo struct {
+	ID         string `json:"id"`
+	FileName   string `json:"fileName"`
+	URL        string `json:"url"`
+	Downloads  int    `json:"downloads"`
+	Limit      int    `json:"limit"`
+	ExpiryTime int64  `json:"expiryTime"` // Unix seconds, 0 for no expiry
+	Paused     bool   `json:"paused"`
+	HasCode    bool   `json:"hasCode"`
+}
+
+// socketDir is where every godrop process places its control socket.
+// It is private to the current user, which workdir.MkdirPrivate and
+// workdir.CheckPrivate make sure of before it is used.
+func socketDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "godrop")
+	}
+	return workdir.UserTempDir()
+}
+
+func socketPath(id string) string {
+	return filepath.Join(socketDir(), id+".sock")
+}
+
+// Info snapshots the share for the status command
+func (s *GodropState) Info() ShareInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := ShareInfo{
+		ID:        s.ID,
+		FileName:  s.FileName,
+		URL:       s.URL,
+		Downloads: s.CurrentDownloads,
+		Limit:     s.DownloadLimit,
+		Paused:    s.Paused,
+		HasCode:   s.SecurityCode != "",
+	}
+	if !s.ExpiryTime.IsZero() {
+		info.ExpiryTime = s.ExpiryTime.Unix()
+	}
+	return info
+}
+
+// ListenControl opens the share's control socket. Each connection sends one
+// command line and gets one reply: "info" answers with JSON, anything else
+// is handled like a command typed into the terminal.
+func (s *GodropState) ListenControl() (net.Listener, error) {
+	if err := workdir.MkdirPrivate(socketDir()); err != nil {
+		return nil, err
+	}
+	path := socketPath(s.ID)
+	os.Remove(path) // A leftover from a crashed process with the same PID
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveControl(conn)
+		}
+	}()
+	return ln, nil
+}
+
+func (s *GodropState) serveControl(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	line = strings.TrimSpace(line)
+	if line == "info" {
+		json.NewEncoder(conn).Encode(s.Info())
+		return
+	}
+	fmt.Fprintln(conn, s.RunCommand(line))
+}
+
+// sendControl sends one command to the share with the given ID and returns its reply
+func sendControl(id string, command string) (string, error) {
+	if err := workdir.CheckPrivate(socketDir()); err != nil {
+		return "", err
+	}
+	conn, err := net.DialTimeout("unix", socketPath(id), 2*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", err
+	}
+	var reply strings.Builder
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		reply.WriteString(scanner.Text())
+		reply.WriteString("\n")
+	}
+	return strings.TrimSpace(reply.String()), scanner.Err()
+}
+
+// runningShares asks every control socket for its share's details. Sockets
+// nobody answers on belong to processes that died and are removed.
+func runningShares() []ShareInfo {
+	if workdir.CheckPrivate(socketDir()) != nil {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(socketDir(), "*.sock"))
+
+	var shares []ShareInfo
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".sock")
+		reply, err := sendControl(id, "info")
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		var info ShareInfo
+		if json.Unmarshal([]byte(reply), &info) == nil {
+			shares = append(shares, info)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		a, _ := strconv.Atoi(shares[i].ID)
+		b, _ := strconv.Atoi(shares[j].ID)
+		return a < b
+	})
+	return shares
+}
+
+// statusCommand implements `godrop status`
+func statusCommand() {
+	shares := runningShares()
+	if len(shares) == 0 {
+		fmt.Println("No running shares.")
+		return
+	}
+
+	fmt.Printf("%-8s %-30s %-28s %-10s %s\n", "ID", "FILE", "URL", "DOWNLOADS", "EXPIRES")
+	for _, s := range shares {
+		expiry := "never"
+		if s.ExpiryTime > 0 {
+			expiry = time.Unix(s.ExpiryTime, 0).Format("15:04:05")
+		}
+		if s.Paused {
+			expiry += " (paused)"
+		}
+		fmt.Printf("%-8s %-30s %-28s %-10s %s\n", s.ID, s.FileName, s.URL, fmt.Sprintf("%d/%d", s.Downloads, s.Limit), expiry)
+	}
+}
+
+// stopCommand implements `godrop stop <id>`
+func stopCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: godrop stop <id>")
+		os.Exit(2)
+	}
+	reply, err := sendControl(args[0], "stop")
+	if err != nil {
+		fmt.Printf("Error: no running share with ID %s\n", args[0])
+		os.Exit(1)
+	}
+	fmt.Println(reply)
+}
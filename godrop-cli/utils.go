@@ -1,29 +1,193 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"net"
+	"net/netip"
+	"sort"
+	"strings"
 )
 
-// GetOutboundIP is a clever way to find the local IP address of your machine.
-// Instead of listing ALL network interfaces (which can be many and confusing),
-// we attempt to "dial" a public IP (Google's DNS 8.8.8.8) using UDP.
-// This doesn't actually send a packet or require internet; it just asks the
-// OS: "If I wanted to send a packet to this address, which of my local IPs would I use?"
-func GetOutboundIP() string {
+// NetInterface is one local address a phone could use to reach us
+type NetInterface struct {
+	Name string
+	IP   string
+	Rank int // Lower is better
+}
+
+// virtualPrefixes are interface names that usually belong to Docker bridges,
+// virtual machines or VPN tunnels. Other devices on the Wi-Fi can't reach
+// those addresses, so we rank them last.
+var virtualPrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vmnet", "vboxnet", "vethernet",
+	"tun", "tap", "utun", "wg", "zt", "tailscale", "ppp", "ipsec", "llw", "awdl",
+}
 
-	// We use UDP (connectionless) so it doesn't wait for a handshake.
-	// It just picks the interface that would be used to reach the internet.
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// ListInterfaces walks every network interface that is up (skipping loopback)
+// and returns its addresses, best candidates first. Unlike dialing a public
+// IP, this works offline and lets the user see every option. IPv6 link-local
+// addresses (fe80::...) only make sense together with their interface, so we
+// keep it attached as a zone: fe80::1%wlan0.
+func ListInterfaces() []NetInterface {
+	ifaces, err := net.Interfaces()
 	if err != nil {
-		log.Fatal(err)
+		return nil
+	}
+
+	var list []NetInterface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipnet.IP.String()
+			if ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+				ip += "%" + iface.Name
+			}
+			list = append(list, NetInterface{Name: iface.Name, IP: ip, Rank: rankAddress(iface.Name, ipnet.IP)})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Rank < list[j].Rank })
+	return list
+}
+
+// rankAddress prefers private LAN addresses (192.168.x.x, 10.x.x.x, ...) on
+// real network cards over virtual adapters and self-assigned 169.254.x.x ones
+func rankAddress(name string, ip net.IP) int {
+	rank := 0
+	lower := strings.ToLower(name)
+	for _, p := range virtualPrefixes {
+		if strings.HasPrefix(lower, p) {
+			rank += 100
+			break
+		}
+	}
+	if ip.To4() == nil {
+		rank += 20 // IPv4 links are shorter and work with every phone
 	}
-	defer conn.Close() // Ensure we clean up the connection resource
+	switch {
+	case ip.IsLinkLocalUnicast():
+		rank += 50
+	case ip.IsPrivate():
+	default:
+		rank += 10
+	}
+	return rank
+}
 
-	// Ask the connection for its local address.
-	// We cast it to *net.UDPAddr so we can access the .IP field.
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
+// ResolveIP decides which IP goes into the share link. pref can be an IP
+// (used as is) or an interface name like "wlan0". With no preference we take
+// the best ranked interface, or 127.0.0.1 if the machine has no network at all.
+func ResolveIP(pref string) (string, error) {
+	if pref != "" {
+		if ip, ok := parseIP(pref); ok {
+			return ip, nil
+		}
+		for _, iface := range ListInterfaces() {
+			if iface.Name == pref {
+				return iface.IP, nil
+			}
+		}
+		return "", fmt.Errorf("no usable interface named %q", pref)
+	}
+	if list := ListInterfaces(); len(list) > 0 {
+		return list[0].IP, nil
+	}
+	return "127.0.0.1", nil
+}
 
-	// Return the IP as a string (e.g., "192.168.1.15")
-	return localAddr.IP.String()
+// BindAddresses turns the -bind flag into the hosts we listen on:
+//   - "all" listens on every interface (the "" host)
+//   - "localhost" keeps the share on this machine only
+//   - an interface name listens on each of its addresses
+//   - an IP address listens on just that address
+func BindAddresses(bind string) ([]string, error) {
+	switch bind {
+	case "", "all":
+		return []string{""}, nil
+	case "localhost":
+		return []string{"127.0.0.1"}, nil
+	}
+	if ip, ok := parseIP(bind); ok {
+		return []string{ip}, nil
+	}
+	var hosts []string
+	for _, iface := range ListInterfaces() {
+		if iface.Name == bind {
+			hosts = append(hosts, iface.IP)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no usable interface named %q", bind)
+	}
+	return hosts, nil
+}
+
+// ReachableAddresses lists the addresses other devices can use once we are
+// bound according to bind, so we can print a link for each of them
+func ReachableAddresses(bind string) []NetInterface {
+	switch bind {
+	case "", "all":
+		return ListInterfaces()
+	case "localhost":
+		return []NetInterface{{Name: "lo", IP: "127.0.0.1"}}
+	}
+	if ip, ok := parseIP(bind); ok {
+		return []NetInterface{{IP: ip}}
+	}
+	var list []NetInterface
+	for _, iface := range ListInterfaces() {
+		if iface.Name == bind {
+			list = append(list, iface)
+		}
+	}
+	return list
+}
+
+// ShareURL builds the link for ip. IPv6 addresses need square brackets, and
+// the "%" before a zone has to be written as "%25" inside a URL:
+// http://[fe80::1%25wlan0]:8080
+// With -tls the link is https and ends in #sha256=<certificate fingerprint>.
+// Browsers ignore that part; `godrop get` checks the certificate against it.
+// The key of an -e2e share goes into the fragment as well (#key=...).
+func ShareURL(ip string, port string, fingerprint string, key string) string {
+	url := "http://" + net.JoinHostPort(strings.Replace(ip, "%", "%25", 1), port)
+	var fragment []string
+	if fingerprint != "" {
+		url = "https" + strings.TrimPrefix(url, "http")
+		fragment = append(fragment, "sha256="+fingerprint)
+	}
+	if key != "" {
+		fragment = append(fragment, "key="+key)
+	}
+	if len(fragment) > 0 {
+		url += "/#" + strings.Join(fragment, "&")
+	}
+	return url
+}
+
+func hasAddress(list []NetInterface, ip string) bool {
+	for _, addr := range list {
+		if addr.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP checks whether s is an IP address (zones allowed) and normalises it
+func parseIP(s string) (string, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return "", false
+	}
+	return addr.String(), true
 }
@@ -20,6 +20,129 @@ document.addEventListener('DOMContentLoaded', async () => {
     // Store state from server
     let serverStats = {};
 
+    // --- END-TO-END ENCRYPTION ---
+    // Shares started with -e2e carry their key in the link's fragment (#key=...),
+    // which the browser never sends to the server. The file arrives as AES-GCM
+    // records (see godrop-common/stream) and is decrypted one record at a time.
+    const linkKey = new URLSearchParams(window.location.hash.slice(1)).get('key');
+    let fileMeta = null;    // Name and size, decrypted from the stats
+    let keyProblem = null;  // Why this page can't decrypt the share, if it can't
+    let transferring = false;
+
+    function decodeBase64(text) {
+        const b64 = text.replace(/-/g, '+').replace(/_/g, '/');
+        return Uint8Array.from(atob(b64 + '==='.slice((b64.length + 3) % 4)), c => c.charCodeAt(0));
+    }
+
+    // Same derivation as deriveKey in e2e.go: HKDF-SHA256, info "godrop <purpose>"
+    async function deriveKey(purpose) {
+        const base = await crypto.subtle.importKey('raw', decodeBase64(linkKey), 'HKDF', false, ['deriveKey']);
+        return crypto.subtle.deriveKey(
+            { name: 'HKDF', hash: 'SHA-256', salt: new Uint8Array(0), info: new TextEncoder().encode('godrop ' + purpose) },
+            base, { name: 'AES-GCM', length: 256 }, false, ['decrypt']);
+    }
+
+    // The record number as 11 big-endian bytes, then 1 on the last record only
+    function streamNonce(counter, final) {
+        const nonce = new Uint8Array(12);
+        for (let i = 10, n = counter; i >= 3 && n > 0; i--, n = Math.floor(n / 256)) {
+            nonce[i] = n % 256;
+        }
+        nonce[11] = final ? 1 : 0;
+        return nonce;
+    }
+
+    // A record that only opens as the final one ends the stream
+    async function openRecord(key, counter, record) {
+        try {
+            return { plain: await crypto.subtle.decrypt({ name: 'AES-GCM', iv: streamNonce(counter, false) }, key, record), final: false };
+        } catch (err) {
+            return { plain: await crypto.subtle.decrypt({ name: 'AES-GCM', iv: streamNonce(counter, true) }, key, record), final: true };
+        }
+    }
+
+    async function showEncryptedDetails() {
+        if (!linkKey) {
+            keyProblem = 'KEY_MISSING_FROM_LINK';
+        } else if (!window.crypto || !crypto.subtle) {
+            keyProblem = 'HTTPS_REQUIRED_TO_DECRYPT';
+        } else if (!fileMeta) {
+            try {
+                const key = await deriveKey('e2e metadata');
+                const plain = await crypto.subtle.decrypt({ name: 'AES-GCM', iv: streamNonce(0, true) }, key, decodeBase64(serverStats.Meta));
+                fileMeta = JSON.parse(new TextDecoder().decode(plain));
+            } catch (err) {
+                keyProblem = 'WRONG_KEY_IN_LINK';
+            }
+        }
+        filenameEl.textContent = fileMeta ? fileMeta.name : 'ENCRYPTED_OBJECT';
+        filesizeEl.textContent = fileMeta ? `${(fileMeta.size / 1024 / 1024).toFixed(2)} MB // END-TO-END ENCRYPTED` : keyProblem;
+    }
+
+    /**
+     * Download the encrypted file and decrypt it while it arrives. Where the
+     * browser allows it the result is written straight to disk, otherwise the
+     * parts are collected into a Blob.
+     */
+    async function decryptDownload() {
+        let sink = null;
+        const parts = [];
+        if (window.showSaveFilePicker) {
+            // Ask first: the picker needs the click, and cancelling must not use up a download
+            const handle = await window.showSaveFilePicker({ suggestedName: fileMeta.name });
+            sink = await handle.createWritable();
+        }
+
+        try {
+            const resp = await fetch('/api/download');
+            if (!resp.ok) throw new Error(await resp.text());
+            const total = Number(resp.headers.get('Content-Length')) || 0;
+            const key = await deriveKey('e2e file');
+            const reader = resp.body.getReader();
+
+            let buf = new Uint8Array(0);
+            let received = 0;
+            let counter = 0;
+            let done = false;
+            while (!done) {
+                const { value, done: eof } = await reader.read();
+                if (value) {
+                    const joined = new Uint8Array(buf.length + value.length);
+                    joined.set(buf);
+                    joined.set(value, buf.length);
+                    buf = joined;
+                    received += value.length;
+                }
+                // Open every complete record in the buffer
+                while (!done && buf.length >= 4) {
+                    const size = new DataView(buf.buffer, buf.byteOffset).getUint32(0);
+                    if (size > 65536 + 16) throw new Error('STREAM_CORRUPT');
+                    if (buf.length < 4 + size) break;
+                    const record = await openRecord(key, counter++, buf.slice(4, 4 + size));
+                    buf = buf.slice(4 + size);
+                    if (sink) await sink.write(record.plain);
+                    else parts.push(record.plain);
+                    done = record.final;
+                }
+                if (total) downloadBtn.textContent = `DECRYPTING ${Math.floor(received / total * 100)}%`;
+                if (eof && !done) throw new Error('TRANSFER_CUT_OFF');
+            }
+        } catch (err) {
+            if (sink) await sink.abort();
+            throw err;
+        }
+
+        if (sink) {
+            await sink.close();
+            return;
+        }
+        const a = document.createElement('a');
+        a.href = URL.createObjectURL(new Blob(parts));
+        a.download = fileMeta.name;
+        a.click();
+        setTimeout(() => URL.revokeObjectURL(a.href), 60000);
+    }
+
     /**
      * Converts seconds into a HH:MM:SS format
      */
@@ -42,16 +165,23 @@ document.addEventListener('DOMContentLoaded', async () => {
             serverStats = await resp.json();
 
             // Update file details
-            filenameEl.textContent = serverStats.FileName;
-            filesizeEl.textContent = `${(serverStats.FileSize / 1024 / 1024).toFixed(2)} MB`;
+            if (serverStats.Encrypted) {
+                await showEncryptedDetails();
+            } else {
+                filenameEl.textContent = serverStats.FileName;
+                filesizeEl.textContent = `${(serverStats.FileSize / 1024 / 1024).toFixed(2)} MB`;
+            }
 
             // Update download counts
             downloadsRemainingEl.textContent = serverStats.Limit - serverStats.Current;
             downloadsTotalEl.textContent = serverStats.Limit;
 
+            // Leave the button alone while it shows decryption progress
+            if (transferring) return;
+
             // Security Logic: check if the user needs to enter a code
-            // If they already unlocked it, we store it in sessionStorage so they don't have to re-enter
-            if (serverStats.HasCode && !sessionStorage.getItem('unlocked')) {
+            // The server remembers who unlocked it, and forgets them if the code changes
+            if (serverStats.HasCode && !serverStats.Unlocked) {
                 securityCheck.style.display = 'block';
                 downloadBtn.disabled = true;
             } else {
@@ -64,6 +194,18 @@ document.addEventListener('DOMContentLoaded', async () => {
                 downloadBtn.textContent = 'LINK_EXPIRED';
                 downloadBtn.disabled = true;
                 statusBadge.textContent = 'LINK_EXPIRED';
+            } else if (serverStats.Paused) {
+                // Paused by the host: keep the page, but hold the transfer
+                downloadBtn.textContent = 'TRANSFER_PAUSED';
+                downloadBtn.disabled = true;
+                statusBadge.textContent = 'PAUSED';
+            } else if (serverStats.Encrypted && keyProblem) {
+                downloadBtn.textContent = keyProblem;
+                downloadBtn.disabled = true;
+                statusBadge.textContent = 'SYSTEM ONLINE';
+            } else {
+                downloadBtn.textContent = 'INITIATE_TRANSFER';
+                statusBadge.textContent = 'SYSTEM ONLINE';
             }
 
             updateTimer(); // Update timer immediately after getting stats
@@ -109,22 +251,36 @@ document.addEventListener('DOMContentLoaded', async () => {
         const result = await resp.json();
 
         if (result.success) {
-            // Unlock locally if the server says it's correct
-            sessionStorage.setItem('unlocked', 'true');
-            securityCheck.style.display = 'none';
-            downloadBtn.disabled = false;
+            // The server has set an access cookie; refresh to pick up the new state
+            updateStats();
         } else {
             // Shake effect or error feedback would go here
             securityCodeInput.value = '';
-            securityCodeInput.placeholder = 'INVALID_CODE_TRY_AGAIN...';
+            securityCodeInput.placeholder = result.error ? 'TOO_MANY_WRONG_CODES' : 'INVALID_CODE_TRY_AGAIN...';
         }
     });
 
     /**
      * Trigger the actual file download via the API
      */
-    downloadBtn.addEventListener('click', () => {
-        window.location.href = '/api/download';
+    downloadBtn.addEventListener('click', async () => {
+        if (!serverStats.Encrypted) {
+            window.location.href = '/api/download';
+            return;
+        }
+        transferring = true;
+        downloadBtn.disabled = true;
+        let failed = false;
+        try {
+            await decryptDownload();
+        } catch (err) {
+            // Cancelling the save dialog is not an error
+            failed = err.name !== 'AbortError';
+            console.error('Encrypted download failed:', err);
+        }
+        transferring = false;
+        await updateStats();
+        if (failed) statusBadge.textContent = 'DECRYPTION_FAILED';
     });
 
     // --- INITIALIZATION ---
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Share codes look like "7-crimson-otter": a nameplate number that finds the
+// share on the network, then two words that only sender and receiver know.
+// The words are the password for the key exchange (see pake.go), so a wrong
+// guess costs an attacker an attempt instead of being checked offline.
+
+var codeAdjectives = []string{
+	"amber", "ancient", "arctic", "autumn", "azure", "bashful", "bitter", "black",
+	"blazing", "bold", "brave", "breezy", "bright", "brisk", "bronze", "bubbly",
+	"calm", "candid", "careful", "cheerful", "chilly", "clever", "cloudy", "coral",
+	"cosmic", "cozy", "crimson", "crisp", "curious", "daring", "dawn", "deep",
+	"dusty", "eager", "early", "electric", "emerald", "fancy", "fearless", "fierce",
+	"fluffy", "foggy", "frosty", "gentle", "giant", "gilded", "glad", "golden",
+	"grand", "green", "happy", "hasty", "hidden", "hollow", "humble", "icy",
+	"indigo", "ivory", "jolly", "keen", "kind", "lavender", "lazy", "lemon",
+	"little", "lively", "lone", "lucky", "lunar", "magic", "maple", "marble",
+	"mellow", "merry", "mighty", "misty", "modest", "mossy", "muddy", "narrow",
+	"neat", "nimble", "noble", "ocean", "olive", "orange", "pale", "patient",
+	"plain", "polar", "polite", "proud", "purple", "quick", "quiet", "rapid",
+	"rare", "rosy", "royal", "rusty", "sandy", "scarlet", "shiny", "silent",
+	"silver", "simple", "sleepy", "slow", "smoky", "snowy", "solar", "spicy",
+	"steady", "stormy", "sunny", "swift", "tidy", "tiny", "velvet", "violet",
+	"wandering", "warm", "wild", "windy", "wise", "witty", "young", "zesty",
+}
+
+var codeAnimals = []string{
+	"albatross", "alpaca", "antelope", "badger", "bat", "bear", "beaver", "bison",
+	"bobcat", "buffalo", "camel", "canary", "caribou", "cat", "cheetah", "chipmunk",
+	"cobra", "condor", "cougar", "coyote", "crab", "crane", "cricket", "crow",
+	"deer", "dingo", "dolphin", "donkey", "dove", "dragon", "duck", "eagle",
+	"eel", "egret", "elk", "falcon", "ferret", "finch", "flamingo", "fox",
+	"frog", "gazelle", "gecko", "gerbil", "gibbon", "giraffe", "goat", "goose",
+	"gopher", "gorilla", "grouse", "gull", "hamster", "hare", "hawk", "hedgehog",
+	"heron", "hippo", "hornet", "horse", "hyena", "ibex", "iguana", "impala",
+	"jackal", "jaguar", "jay", "kangaroo", "kestrel", "kiwi", "koala", "lemur",
+	"leopard", "lion", "lizard", "llama", "lobster", "lynx", "magpie", "mallard",
+	"manatee", "marmot", "meerkat", "mink", "mole", "moose", "moth", "mouse",
+	"mule", "newt", "ocelot", "octopus", "orca", "osprey", "ostrich", "otter",
+	"owl", "ox", "panda", "panther", "parrot", "pelican", "penguin", "pigeon",
+	"puffin", "puma", "python", "quail", "rabbit", "raccoon", "raven", "robin",
+	"salmon", "seal", "shark", "sheep", "skunk", "sloth", "snail", "sparrow",
+	"squid", "stork", "swan", "tiger", "toad", "turtle", "walrus", "wolf",
+}
+
+// generateCode picks a fresh share code
+func generateCode() string {
+	return fmt.Sprintf("%d-%s-%s", randInt(99)+1, codeAdjectives[randInt(len(codeAdjectives))], codeAnimals[randInt(len(codeAnimals))])
+}
+
+// parseCode splits a share code into its nameplate and the whole code, which
+// is the password. Case and surrounding spaces don't matter.
+func parseCode(code string) (nameplate string, password string, err error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	parts := strings.Split(code, "-")
+	if len(parts) < 3 {
+		return "", "", fmt.Errorf("invalid code %q, expected something like 7-crimson-otter", code)
+	}
+	if _, err := strconv.Atoi(parts[0]); err != nil {
+		return "", "", fmt.Errorf("invalid code %q, it should start with a number", code)
+	}
+	return parts[0], code, nil
+}
+
+func randInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(v.Int64())
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"godrop-common/workdir"
+)
+
+// cleanupCommand implements `godrop cleanup`
+func cleanupCommand(args []string) {
+	root := workdir.DefaultRoot()
+	if len(args) == 2 && (args[0] == "-workdir" || args[0] == "--workdir") {
+		root = args[1]
+	} else if len(args) != 0 {
+		fmt.Println("Usage: godrop cleanup [-workdir <dir>]")
+		os.Exit(2)
+	}
+
+	removed, err := workdir.Sweep(root)
+	for _, dir := range removed {
+		fmt.Println("Removed", dir)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if len(removed) == 0 {
+		fmt.Println("Nothing to clean up.")
+	}
+}
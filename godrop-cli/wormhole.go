@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"godrop-common/discovery"
+	"godrop-common/stream"
+)
+
+// `godrop send` shares a file under a code like 7-crimson-otter instead of a
+// link. `godrop get <code>` finds the share by its nameplate (the number) over
+// mDNS, runs a SPAKE2 exchange with the whole code as password, and downloads
+// the file encrypted with the resulting key. Someone on the same network can
+// neither read the transfer nor take it over without the code.
+
+const (
+	maxCodeFailures     = 3 // Wrong codes before the share closes
+	maxPendingExchanges = 16
+	exchangeTimeout     = time.Minute
+)
+
+// wormhole tracks the key exchanges of a share started with `godrop send`
+type wormhole struct {
+	code string
+
+	mu        sync.Mutex
+	exchanges map[string]*exchange
+	failures  int
+}
+
+// exchange is one receiver's key exchange, usable for a single download
+type exchange struct {
+	key       []byte
+	confirmA  []byte
+	confirmB  []byte
+	confirmed bool
+	started   time.Time
+}
+
+func newWormhole(code string) *wormhole {
+	return &wormhole{code: code, exchanges: make(map[string]*exchange)}
+}
+
+// prune drops exchanges that were never finished. Callers must hold wh.mu.
+func (wh *wormhole) prune() {
+	for id, ex := range wh.exchanges {
+		if time.Since(ex.started) > exchangeTimeout {
+			delete(wh.exchanges, id)
+		}
+	}
+}
+
+// take returns the exchange with the given ID
+func (wh *wormhole) take(id string) (*exchange, bool) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.prune()
+	ex, ok := wh.exchanges[id]
+	return ex, ok
+}
+
+// pickCode makes up a code whose nameplate no other sender nearby is using
+func pickCode() string {
+	taken := map[string]bool{}
+	if shares, err := discovery.Browse(context.Background(), time.Second); err == nil {
+		for _, s := range shares {
+			taken[s.Nameplate] = true
+		}
+	}
+	for {
+		code := generateCode()
+		if nameplate, _, _ := parseCode(code); !taken[nameplate] {
+			return code
+		}
+	}
+}
+
+// wormholeHandler serves the key exchange and the encrypted download. It
+// replaces the web page, so the file can't be fetched without the code.
+func (s *GodropState) wormholeHandler() http.Handler {
+	wh := s.wormhole
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /api/pake/start", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Msg []byte }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if s.Closing() {
+			http.Error(w, "Shutting Down", http.StatusServiceUnavailable)
+			return
+		}
+
+		sp, msg, err := newSpake(false, wh.code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		key, confirmA, confirmB, err := sp.finish(body.Msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b := make([]byte, 16)
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+
+		wh.mu.Lock()
+		wh.prune()
+		full := len(wh.exchanges) >= maxPendingExchanges
+		if !full {
+			wh.exchanges[id] = &exchange{key: key, confirmA: confirmA, confirmB: confirmB, started: time.Now()}
+		}
+		wh.mu.Unlock()
+		if full {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"session": id, "msg": msg})
+	})
+
+	// The receiver proves it knows the code first. Each wrong proof counts,
+	// so guessing the code online is hopeless.
+	mux.HandleFunc("POST /api/pake/confirm", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Session string
+			Confirm []byte
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		ex, ok := wh.take(body.Session)
+		if !ok {
+			http.Error(w, "Unknown Session", http.StatusNotFound)
+			return
+		}
+
+		wh.mu.Lock()
+		if !hmac.Equal(body.Confirm, ex.confirmA) {
+			delete(wh.exchanges, body.Session)
+			wh.failures++
+			failures := wh.failures
+			wh.mu.Unlock()
+			fmt.Printf("Wrong code from %s (%d/%d)\n", r.RemoteAddr, failures, maxCodeFailures)
+			if failures >= maxCodeFailures {
+				s.Stop("\nToo many wrong codes. Closing the share to be safe.")
+			}
+			http.Error(w, "Wrong Code", http.StatusForbidden)
+			return
+		}
+		ex.confirmed = true
+		wh.mu.Unlock()
+
+		s.mu.Lock()
+		meta, _ := json.Marshal(fileMeta{Name: s.FileName, Size: s.FileSize})
+		s.mu.Unlock()
+		sealed, err := stream.SealMessage(deriveKey(ex.key, "wormhole metadata"), meta)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"confirm": ex.confirmB, "meta": sealed})
+	})
+
+	mux.HandleFunc("GET /api/pake/download", func(w http.ResponseWriter, r *http.Request) {
+		if s.Closing() {
+			http.Error(w, "Shutting Down", http.StatusServiceUnavailable)
+			return
+		}
+		id := r.URL.Query().Get("session")
+		ex, ok := wh.take(id)
+		wh.mu.Lock()
+		if ok && ex.confirmed {
+			delete(wh.exchanges, id) // One download per exchange
+		}
+		wh.mu.Unlock()
+		if !ok || !ex.confirmed {
+			http.Error(w, "Unknown Session", http.StatusForbidden)
+			return
+		}
+
+		s.mu.Lock()
+		myNum, myLimit, status, err := s.reserveDownload()
+		s.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		f, err := os.Open(s.FilePath)
+		if err != nil {
+			http.Error(w, "File Unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		fmt.Printf("[%d/%d] Sending encrypted file to %s...\n", myNum, myLimit, r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		sw, err := stream.NewWriter(deriveKey(ex.key, "wormhole file"), w)
+		if err == nil {
+			if _, err = io.Copy(sw, f); err == nil {
+				err = sw.Close()
+			}
+		}
+		if err != nil {
+			fmt.Printf("Transfer to %s failed: %v\n", r.RemoteAddr, err)
+		}
+		s.finishDownload(myNum)
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "This share is only available with `godrop get <code>`.", http.StatusNotFound)
+	})
+	return mux
+}
+
+// getCommand implements `godrop get <code>`, and `godrop get <link>` for
+// shares started the usual way
+func getCommand(args []string) {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	wait := fs.Duration("wait", 10*time.Second, "How long to look for the sender")
+	outDir := fs.String("o", ".", "Directory the file is saved to")
+	code := fs.String("code", "", "Security code of a share given by its link")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: godrop get [-o dir] <code>")
+		fmt.Println("       godrop get [-o dir] [-code <code>] <link>")
+		os.Exit(2)
+	}
+
+	var path string
+	var err error
+	if strings.Contains(fs.Arg(0), "://") {
+		var share discovery.Share
+		var key []byte
+		if share, key, err = shareFromURL(fs.Arg(0)); err == nil {
+			share.Code = *code != ""
+			if key != nil {
+				path, err = downloadEncrypted(share, *code, key, *outDir)
+			} else {
+				path, err = downloadShare(share, *code, *outDir)
+			}
+		}
+	} else {
+		path, err = receiveWithCode(fs.Arg(0), *wait, *outDir)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Saved to", path)
+}
+
+// findNameplate looks for the share advertising nameplate until wait runs out
+func findNameplate(nameplate string, wait time.Duration) (discovery.Share, error) {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		shares, err := discovery.Browse(context.Background(), 2*time.Second)
+		if err != nil {
+			return discovery.Share{}, err
+		}
+		var found []discovery.Share
+		for _, s := range shares {
+			if s.Nameplate == nameplate {
+				found = append(found, s)
+			}
+		}
+		switch len(found) {
+		case 1:
+			return found[0], nil
+		case 0:
+			continue
+		default:
+			// Trying them all would count as wrong guesses at the others
+			return discovery.Share{}, fmt.Errorf("several senders use nameplate %s, ask for a new code", nameplate)
+		}
+	}
+	return discovery.Share{}, fmt.Errorf("no sender with nameplate %s found nearby", nameplate)
+}
+
+// receiveWithCode runs the receiving side of a code share and saves the file
+// into dir
+func receiveWithCode(code string, wait time.Duration, dir string) (string, error) {
+	nameplate, password, err := parseCode(code)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println("Looking for the sender...")
+	share, err := findNameplate(nameplate, wait)
+	if err != nil {
+		return "", err
+	}
+
+	sp, msg, err := newSpake(true, password)
+	if err != nil {
+		return "", err
+	}
+	var started struct {
+		Session string
+		Msg     []byte
+	}
+	client := pinnedClient(share.Fingerprint)
+	if err := postJSON(client, share.Endpoint("/api/pake/start"), map[string]interface{}{"msg": msg}, &started); err != nil {
+		return "", err
+	}
+	key, confirmA, confirmB, err := sp.finish(started.Msg)
+	if err != nil {
+		return "", err
+	}
+
+	var confirmed struct {
+		Confirm []byte
+		Meta    []byte
+	}
+	if err := postJSON(client, share.Endpoint("/api/pake/confirm"), map[string]interface{}{"session": started.Session, "confirm": confirmA}, &confirmed); err != nil {
+		return "", err
+	}
+	if !hmac.Equal(confirmed.Confirm, confirmB) {
+		return "", fmt.Errorf("the sender could not prove it knows the code")
+	}
+	metaJSON, err := stream.OpenMessage(deriveKey(key, "wormhole metadata"), confirmed.Meta)
+	if err != nil {
+		return "", err
+	}
+	var meta fileMeta
+	if err := json.Unmarshal(metaJSON, &meta); err != nil {
+		return "", err
+	}
+
+	resp, err := client.Get(share.Endpoint("/api/pake/download?session=" + started.Session))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return "", fmt.Errorf("%s", bytes.TrimSpace(msg))
+	}
+	sr, err := stream.NewReader(deriveKey(key, "wormhole file"), resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := createUnique(dir, safeName(meta.Name))
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Receiving %s (%s)...\n", meta.Name, formatSize(meta.Size))
+	_, err = io.Copy(dst, sr)
+	dst.Close()
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// postJSON posts body as JSON to url and decodes the reply into out
+func postJSON(client *http.Client, url string, body interface{}, out interface{}) error {
+	data, _ := json.Marshal(body)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusForbidden {
+		return errPakeMismatch
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s", bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,240 @@
+// Package atrest is the format of files encrypted at rest, which the GUI's
+// receive shares write and both the GUI and `godrop decrypt` open.
+package atrest
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"godrop-common/stream"
+)
+
+// Received files can be encrypted as they stream to disk, so a drop box
+// doesn't keep them in the clear. Every file gets an ephemeral X25519 key
+// whose exchange with the recipient's public key gives the file key, which
+// means the receiving machine holds no secret at all. The recipient key is
+// either generated (`godrop keygen`) or derived from a passphrase, whose
+// salt is then stored in each file.
+//
+// A file is the header below followed by an encrypted stream (package stream),
+// keyed with HKDF-SHA256(shared secret, salt = ephemeral || recipient,
+// info "godrop at rest"):
+//
+//	"GODROPENC" 0x01 | kind (1) | salt (16) | recipient key (32) | ephemeral key (32)
+
+// Ext is appended to the names of files encrypted at rest
+const Ext = ".godrop"
+
+const (
+	atRestMagic      = "GODROPENC\x01"
+	atRestHeaderSize = len(atRestMagic) + 1 + 16 + 32 + 32
+
+	kindKey        = 1
+	kindPassphrase = 2
+
+	passphraseRounds = 600000 // PBKDF2-HMAC-SHA256
+
+	publicKeyPrefix  = "godrop-pub-"
+	secretKeyPrefix  = "godrop-key-"
+	passphrasePrefix = "godrop-pass-" // A passphrase recipient as saved in the state file
+)
+
+var keyEncoding = base64.RawURLEncoding
+
+var (
+	// ErrNotEncrypted is returned for input without the at-rest header
+	ErrNotEncrypted = errors.New("not a godrop encrypted file")
+	// ErrNeedsKey is returned when a file encrypted for a key pair is given
+	// a passphrase
+	ErrNeedsKey = errors.New("encrypted for a key pair, not a passphrase")
+	// ErrWrongSecret is returned when the passphrase or key is not the
+	// file's recipient
+	ErrWrongSecret = errors.New("wrong passphrase or key")
+)
+
+// Recipient is who can open the files a receive share encrypts
+type Recipient struct {
+	public *ecdh.PublicKey
+	salt   []byte // Set when the key is derived from a passphrase
+}
+
+// NewRecipient parses a public key (godrop-pub-...) or a recipient saved with
+// String. Anything else is taken as a passphrase; the passphrase itself is
+// not kept.
+func NewRecipient(s string) (*Recipient, error) {
+	switch {
+	case strings.HasPrefix(s, publicKeyPrefix):
+		pub, err := decodeKey(strings.TrimPrefix(s, publicKeyPrefix), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key")
+		}
+		key, err := ecdh.X25519().NewPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		return &Recipient{public: key}, nil
+
+	case strings.HasPrefix(s, passphrasePrefix):
+		raw, err := decodeKey(strings.TrimPrefix(s, passphrasePrefix), 16+32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid saved recipient")
+		}
+		key, err := ecdh.X25519().NewPublicKey(raw[16:])
+		if err != nil {
+			return nil, err
+		}
+		return &Recipient{public: key, salt: raw[:16]}, nil
+
+	case strings.HasPrefix(s, secretKeyPrefix):
+		return nil, fmt.Errorf("that is a secret key; give the public key (%s...) instead", publicKeyPrefix)
+	}
+
+	if len(s) < 8 {
+		return nil, fmt.Errorf("the passphrase must be at least 8 characters")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	priv, err := passphraseKey(s, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{public: priv.PublicKey(), salt: salt}, nil
+}
+
+// String encodes the recipient so NewRecipient can restore it
+func (r *Recipient) String() string {
+	if r.salt != nil {
+		return passphrasePrefix + keyEncoding.EncodeToString(append(append([]byte(nil), r.salt...), r.public.Bytes()...))
+	}
+	return publicKeyPrefix + keyEncoding.EncodeToString(r.public.Bytes())
+}
+
+// GenerateKeyPair makes a key pair for encrypted receive shares: the public
+// key goes to the share, the secret key opens the files
+func GenerateKeyPair() (public string, secret string, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return publicKeyPrefix + keyEncoding.EncodeToString(priv.PublicKey().Bytes()),
+		secretKeyPrefix + keyEncoding.EncodeToString(priv.Bytes()), nil
+}
+
+// Encrypt writes a file header to w and returns a writer that encrypts what
+// is written to it. It must be closed to finish the file.
+func (r *Recipient) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(r.public)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, atRestHeaderSize)
+	header = append(header, atRestMagic...)
+	salt := make([]byte, 16)
+	if r.salt != nil {
+		header = append(header, kindPassphrase)
+		copy(salt, r.salt)
+	} else {
+		header = append(header, kindKey)
+	}
+	header = append(header, salt...)
+	header = append(header, r.public.Bytes()...)
+	header = append(header, eph.PublicKey().Bytes()...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	key, err := atRestKey(shared, eph.PublicKey().Bytes(), r.public.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return stream.NewWriter(key, w)
+}
+
+// Decrypt reads the header of a file encrypted at rest from r and returns a
+// reader of the plain file. secret is a passphrase or a secret key
+// (godrop-key-...). A file encrypted for a key pair asked to open with a
+// passphrase gives ErrNeedsKey.
+func Decrypt(r io.Reader, secret string) (io.Reader, error) {
+	header := make([]byte, atRestHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte(atRestMagic)) {
+		return nil, ErrNotEncrypted
+	}
+	rest := header[len(atRestMagic):]
+	kind, salt, recipient, ephemeral := rest[0], rest[1:17], rest[17:49], rest[49:81]
+
+	var priv *ecdh.PrivateKey
+	var err error
+	switch {
+	case kind == kindKey && strings.HasPrefix(secret, secretKeyPrefix):
+		raw, err := decodeKey(strings.TrimPrefix(secret, secretKeyPrefix), 32)
+		if err != nil {
+			return nil, errors.New("invalid secret key")
+		}
+		priv, err = ecdh.X25519().NewPrivateKey(raw)
+		if err != nil {
+			return nil, err
+		}
+	case kind == kindKey:
+		return nil, ErrNeedsKey
+	case kind == kindPassphrase:
+		if priv, err = passphraseKey(secret, salt); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown kind of key")
+	}
+	if !bytes.Equal(priv.PublicKey().Bytes(), recipient) {
+		return nil, ErrWrongSecret
+	}
+
+	eph, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(eph)
+	if err != nil {
+		return nil, err
+	}
+	key, err := atRestKey(shared, ephemeral, recipient)
+	if err != nil {
+		return nil, err
+	}
+	return stream.NewReader(key, r)
+}
+
+func decodeKey(s string, size int) ([]byte, error) {
+	raw, err := keyEncoding.DecodeString(strings.TrimSpace(s))
+	if err == nil && len(raw) != size {
+		err = errors.New("wrong length")
+	}
+	return raw, err
+}
+
+// passphraseKey derives the recipient's private key from a passphrase
+func passphraseKey(passphrase string, salt []byte) (*ecdh.PrivateKey, error) {
+	raw, err := pbkdf2.Key(sha256.New, passphrase, salt, passphraseRounds, 32)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+func atRestKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte(nil), ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, "godrop at rest", 32)
+}
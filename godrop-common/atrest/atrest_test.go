@@ -0,0 +1,147 @@
+package atrest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"godrop-common/stream"
+)
+
+// seal encrypts plain for r
+func seal(t *testing.T, r *Recipient, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := r.Encrypt(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func open(sealed []byte, secret string) ([]byte, error) {
+	r, err := Decrypt(bytes.NewReader(sealed), secret)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	public, secret, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey, err := NewRecipient(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPassphrase, err := NewRecipient("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A recipient restored from the state file encrypts for the same secret
+	restored, err := NewRecipient(byPassphrase.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := bytes.Repeat([]byte("quarterly numbers\n"), 10000)
+
+	cases := []struct {
+		name      string
+		recipient *Recipient
+		secret    string
+	}{
+		{"key pair", byKey, secret},
+		{"passphrase", byPassphrase, "correct horse battery"},
+		{"saved passphrase recipient", restored, "correct horse battery"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := open(seal(t, tc.recipient, plain), tc.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("got %d different bytes back", len(got))
+			}
+		})
+	}
+}
+
+func TestDecryptRefuses(t *testing.T) {
+	public, secret, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherSecret, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecipient(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := seal(t, r, bytes.Repeat([]byte("x"), 2*stream.Chunk+1))
+	withByte := func(i int) []byte {
+		b := append([]byte(nil), sealed...)
+		b[i] ^= 1
+		return b
+	}
+
+	cases := []struct {
+		name   string
+		sealed []byte
+		secret string
+		want   error
+	}{
+		{"plain file", []byte("just some text, long enough to fill a header......................................"), secret, ErrNotEncrypted},
+		{"short header", sealed[:atRestHeaderSize-1], secret, ErrNotEncrypted},
+		{"passphrase for a key pair", sealed, "correct horse battery", ErrNeedsKey},
+		{"another key", sealed, otherSecret, ErrWrongSecret},
+		{"tampered recipient", withByte(atRestHeaderSize - 40), secret, ErrWrongSecret},
+		{"tampered ephemeral key", withByte(atRestHeaderSize - 1), secret, stream.ErrCorrupt},
+		{"tampered body", withByte(len(sealed) - 1), secret, stream.ErrCorrupt},
+		{"truncated", sealed[:len(sealed)-100], secret, stream.ErrTruncated},
+		{"header only", sealed[:atRestHeaderSize], secret, stream.ErrTruncated},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := open(tc.sealed, tc.secret); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestMissingFinalRecord(t *testing.T) {
+	r, err := NewRecipient("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := seal(t, r, bytes.Repeat([]byte("x"), stream.Chunk+1))
+	// The first record holds a full chunk; the final one follows it
+	firstEnd := atRestHeaderSize + 4 + stream.Chunk + 16
+	if _, err := open(sealed[:firstEnd], "correct horse battery"); !errors.Is(err, stream.ErrTruncated) {
+		t.Errorf("got %v without the final record, want %v", err, stream.ErrTruncated)
+	}
+}
+
+func TestNewRecipientRejects(t *testing.T) {
+	_, secret, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"short", secret, publicKeyPrefix + "not-base64!", passphrasePrefix + "AAAA"} {
+		if _, err := NewRecipient(s); err == nil {
+			t.Errorf("NewRecipient(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package certs makes the self-signed certificates HTTPS shares are served
+// with, and checks them on the client side by fingerprint.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// NewCertificate creates a self-signed certificate for hosts, which may be IP
+// addresses or names, and returns it with its fingerprint. The key only lives
+// in memory, so every share gets a certificate of its own.
+func NewCertificate(hosts []string) (tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "godrop"},
+		NotBefore:             time.Now().Add(-time.Hour), // Tolerate clocks that are a little behind
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if addr, err := netip.ParseAddr(h); err == nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, net.IP(addr.WithZone("").AsSlice()))
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, Fingerprint(der), nil
+}
+
+// Fingerprint returns the SHA-256 hash of a DER certificate as lowercase hex,
+// the form used in share URLs
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatFingerprint groups a fingerprint into colon separated pairs, the way
+// browsers show it (AB:CD:...)
+func FormatFingerprint(fp string) string {
+	fp = strings.ToUpper(fp)
+	var parts []string
+	for i := 0; i+2 <= len(fp); i += 2 {
+		parts = append(parts, fp[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// PinnedTLS returns a client config that trusts exactly the certificate with
+// fingerprint fp, written in either form, instead of any certificate authority
+func PinnedTLS(fp string) *tls.Config {
+	want := strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+	return &tls.Config{
+		// The chain is checked below; a self-signed certificate has none
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || Fingerprint(rawCerts[0]) != want {
+				return fmt.Errorf("certificate fingerprint does not match the share")
+			}
+			return nil
+		},
+	}
+}
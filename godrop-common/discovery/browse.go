@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"godrop-common/dnsmsg"
+)
+
+// Share is a godrop share found on the local network
+type Share struct {
+	Instance string   `json:"instance"` // DNS-SD instance name, unique on the network
+	Host     string   `json:"host"`     // .local name of the machine sharing it
+	IP       string   `json:"ip"`       // Address the share is reached at
+	Addrs    []string `json:"addrs"`    // Every address the host announced
+	Port     int      `json:"port"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Mode     string   `json:"mode"`
+	Size     int64    `json:"size"`
+	Code     bool     `json:"code"`     // A password is needed
+	Path     string   `json:"path"`     // Landing page
+	Transfer string   `json:"transfer"` // Download or upload endpoint, empty for clipboard shares
+	URL      string   `json:"url"`      // Landing page URL
+
+	Nameplate   string `json:"nameplate"`   // Set for the CLI's `godrop send` shares
+	Fingerprint string `json:"fingerprint"` // Certificate hash of an HTTPS share, to pin
+	Encrypted   bool   `json:"encrypted"`   // Set for the CLI's -e2e shares, which need their link
+}
+
+// Endpoint returns the URL of path on the share's server
+func (s Share) Endpoint(path string) string {
+	scheme := "http://"
+	if s.Fingerprint != "" {
+		scheme = "https://"
+	}
+	return scheme + net.JoinHostPort(strings.Replace(s.IP, "%", "%25", 1), strconv.Itoa(s.Port)) + path
+}
+
+// Browse asks the local network for godrop shares and collects the answers
+// that arrive within wait. Shares that are withdrawn meanwhile are left out.
+func Browse(ctx context.Context, wait time.Duration) ([]Share, error) {
+	// Asking from an ordinary port makes responders reply to us directly
+	// (RFC 6762 section 6.7), so this works next to a running responder
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	b := newBrowser()
+	deadline := time.Now().Add(wait)
+	buf := make([]byte, 9000)
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		if _, err := conn.WriteToUDP(b.query().Pack(), mdnsGroup); err != nil {
+			return nil, err
+		}
+
+		// Listen a while before asking again, this time also for whatever
+		// the first answers left out
+		next := time.Now().Add(500 * time.Millisecond)
+		if next.After(deadline) {
+			next = deadline
+		}
+		conn.SetReadDeadline(next)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if msg, err := dnsmsg.Parse(buf[:n]); err == nil && msg.Response {
+				b.add(msg, from.IP)
+			}
+		}
+	}
+	return b.shares(), nil
+}
+
+// browser pieces shares together from records that may arrive in any order
+// and across several responses
+type browser struct {
+	instances map[string]string // Lowercased name to name as announced
+	srv       map[string]dnsmsg.Record
+	txt       map[string][]string
+	addrs     map[string][]string // By lowercased host name
+	from      map[string]net.IP   // Who announced each instance
+}
+
+func newBrowser() *browser {
+	return &browser{
+		instances: make(map[string]string),
+		srv:       make(map[string]dnsmsg.Record),
+		txt:       make(map[string][]string),
+		addrs:     make(map[string][]string),
+		from:      make(map[string]net.IP),
+	}
+}
+
+// query asks for the service list plus anything still missing about the
+// instances already seen
+func (b *browser) query() *dnsmsg.Message {
+	msg := &dnsmsg.Message{Questions: []dnsmsg.Question{{Name: serviceName, Type: dnsmsg.TypePTR}}}
+	for key, name := range b.instances {
+		if _, ok := b.srv[key]; !ok {
+			msg.Questions = append(msg.Questions, dnsmsg.Question{Name: name, Type: dnsmsg.TypeSRV})
+		}
+		if _, ok := b.txt[key]; !ok {
+			msg.Questions = append(msg.Questions, dnsmsg.Question{Name: name, Type: dnsmsg.TypeTXT})
+		}
+	}
+	for _, srv := range b.srv {
+		if _, ok := b.addrs[strings.ToLower(srv.Target)]; !ok {
+			msg.Questions = append(msg.Questions, dnsmsg.Question{Name: srv.Target, Type: dnsmsg.TypeA})
+		}
+	}
+	return msg
+}
+
+func (b *browser) add(msg *dnsmsg.Message, from net.IP) {
+	for _, rr := range append(msg.Answers, msg.Extra...) {
+		key := strings.ToLower(rr.Name)
+		switch rr.Type {
+		case dnsmsg.TypePTR:
+			if key != serviceName {
+				continue
+			}
+			target := strings.ToLower(rr.Target)
+			if rr.TTL == 0 { // Goodbye
+				delete(b.instances, target)
+				continue
+			}
+			b.instances[target] = rr.Target
+			b.from[target] = from
+		case dnsmsg.TypeSRV:
+			b.srv[key] = rr
+		case dnsmsg.TypeTXT:
+			b.txt[key] = rr.Text
+		case dnsmsg.TypeA, dnsmsg.TypeAAAA:
+			ip := rr.IP.String()
+			for _, known := range b.addrs[key] {
+				if known == ip {
+					ip = ""
+				}
+			}
+			if ip != "" {
+				b.addrs[key] = append(b.addrs[key], ip)
+			}
+		}
+	}
+}
+
+// shares returns every instance whose SRV record arrived, sorted by host and name
+func (b *browser) shares() []Share {
+	list := []Share{}
+	for key, name := range b.instances {
+		srv, ok := b.srv[key]
+		if !ok {
+			continue
+		}
+		host := strings.ToLower(srv.Target)
+		share := Share{
+			Instance: strings.TrimSuffix(name, "."+serviceName),
+			Host:     strings.TrimSuffix(srv.Target, "."),
+			Addrs:    b.addrs[host],
+			Port:     int(srv.Port),
+		}
+		for _, kv := range b.txt[key] {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "id":
+				share.ID = v
+			case "name":
+				share.Name = v
+			case "mode":
+				share.Mode = v
+			case "size":
+				share.Size, _ = strconv.ParseInt(v, 10, 64)
+			case "code":
+				share.Code = v == "1"
+			case "path":
+				share.Path = v
+			case "nameplate":
+				share.Nameplate = v
+			case "transfer":
+				share.Transfer = v
+			case "fp":
+				share.Fingerprint = v
+			case "e2e":
+				share.Encrypted = v == "1"
+			}
+		}
+
+		// The address the announcement came from is known to work; the
+		// announced ones are a fallback
+		if ip := b.from[key]; ip != nil {
+			share.IP = ip.String()
+		} else if len(share.Addrs) > 0 {
+			share.IP = share.Addrs[0]
+		} else {
+			continue
+		}
+		share.URL = share.Endpoint(share.Path)
+		list = append(list, share)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Host != list[j].Host {
+			return list[i].Host < list[j].Host
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,51 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+)
+
+// TestAnnouncementBrowsed checks that a share announced by a Responder comes
+// out of the browser with every field, including the CLI's
+func TestAnnouncementBrowsed(t *testing.T) {
+	r := &Responder{label: "desk-godrop", host: "desk-godrop.local.", addrs: func() []string { return []string{"192.0.2.10"} }}
+	services := []Service{
+		{ID: "1", Name: "report.pdf", Mode: "send", Size: 1234, Code: true, Port: 8080, Path: "/", Transfer: "/download", Fingerprint: "ab12"},
+		{ID: "2", Mode: "send", Code: true, Port: 8081, Path: "/", Nameplate: "7"},
+		{ID: "3", Mode: "send", Port: 8082, Path: "/", Encrypted: true},
+	}
+	b := newBrowser()
+	for _, svc := range services {
+		b.add(r.announcement(svc, 1), net.ParseIP("192.0.2.10"))
+	}
+
+	shares := b.shares()
+	if len(shares) != len(services) {
+		t.Fatalf("browsed %d shares, want %d", len(shares), len(services))
+	}
+	byID := make(map[string]Share)
+	for _, s := range shares {
+		byID[s.ID] = s
+	}
+	for _, svc := range services {
+		s, ok := byID[svc.ID]
+		if !ok {
+			t.Errorf("share %s was not browsed", svc.ID)
+			continue
+		}
+		if s.Name != svc.Name || s.Size != svc.Size || s.Code != svc.Code || s.Port != svc.Port ||
+			s.Transfer != svc.Transfer || s.Fingerprint != svc.Fingerprint ||
+			s.Nameplate != svc.Nameplate || s.Encrypted != svc.Encrypted {
+			t.Errorf("share %s browsed as %+v, announced as %+v", svc.ID, s, svc)
+		}
+		if s.Host != "desk-godrop.local" || s.IP != "192.0.2.10" {
+			t.Errorf("share %s is at %s (%s)", svc.ID, s.Host, s.IP)
+		}
+	}
+
+	// A goodbye withdraws the share
+	b.add(r.announcement(services[0], 0), net.ParseIP("192.0.2.10"))
+	if got := len(b.shares()); got != len(services)-1 {
+		t.Errorf("%d shares left after a goodbye, want %d", got, len(services)-1)
+	}
+}
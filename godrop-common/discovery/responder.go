@@ -0,0 +1,340 @@
+// Package discovery advertises godrop shares on the local network over
+// multicast DNS (RFC 6762) and DNS-SD (RFC 6763), and finds the shares of
+// other devices. The CLI and the desktop app use it alike.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"godrop-common/dnsmsg"
+)
+
+// ServiceType is the DNS-SD type every share is advertised under
+const ServiceType = "_godrop._tcp"
+
+const (
+	domain      = "local."
+	serviceName = ServiceType + "." + domain
+	metaQuery   = "_services._dns-sd._udp." + domain
+
+	hostTTL    = 120  // Seconds, for records that change with the network
+	serviceTTL = 4500 // Seconds, for PTR and TXT records
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is one share as seen by other devices
+type Service struct {
+	ID   string // Unique on this host, part of the instance name
+	Name string // File or folder being shared
+	Mode string // "send", "receive" or "clipboard"
+	Size int64  // Bytes, 0 when not known up front
+	Code bool   // A password is needed
+	Port int
+	Path string // Landing page, e.g. "/clipboard"
+
+	// Nameplate is the number that starts the code of a CLI `godrop send`
+	// share, so `godrop get` can find it
+	Nameplate string
+
+	// Transfer is the download endpoint of a send share or the upload
+	// endpoint of a receive share
+	Transfer string
+
+	// Fingerprint is the SHA-256 hash of the share's certificate, set when
+	// it is served over HTTPS
+	Fingerprint string
+
+	// Encrypted shares can only be opened with their link, which holds the key
+	Encrypted bool
+}
+
+// txt encodes the service details as DNS-SD key=value pairs
+func (s Service) txt() []string {
+	code, e2e := "0", "0"
+	if s.Code {
+		code = "1"
+	}
+	if s.Encrypted {
+		e2e = "1"
+	}
+	return []string{
+		"v=1",
+		"id=" + s.ID,
+		"name=" + s.Name,
+		"mode=" + s.Mode,
+		"size=" + strconv.FormatInt(s.Size, 10),
+		"code=" + code,
+		"path=" + s.Path,
+		"nameplate=" + s.Nameplate,
+		"transfer=" + s.Transfer,
+		"fp=" + s.Fingerprint,
+		"e2e=" + e2e,
+	}
+}
+
+// Hostname returns the name this machine claims under .local: its own host
+// name followed by "-godrop", so mypc is reachable as mypc-godrop.local
+func Hostname() string {
+	name, _ := os.Hostname()
+	name, _, _ = strings.Cut(strings.ToLower(name), ".")
+
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	label := strings.Trim(b.String(), "-")
+	if label == "" {
+		return "godrop"
+	}
+	if len(label) > 50 {
+		label = label[:50]
+	}
+	return label + "-godrop"
+}
+
+// Responder advertises services and answers queries for them and for the
+// host name
+type Responder struct {
+	label string // Host label, e.g. "mypc-godrop"
+	host  string // Fully qualified host name, e.g. "mypc-godrop.local."
+	addrs func() []string
+	conn  *net.UDPConn
+
+	mu       sync.Mutex
+	services map[string]Service
+	closed   bool
+}
+
+// NewResponder joins the mDNS group on the system's default multicast
+// interface. addrs is called whenever the host's addresses are needed, so
+// they follow interface changes.
+func NewResponder(addrs func() []string) (*Responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, fmt.Errorf("mDNS unavailable: %w", err)
+	}
+	label := Hostname()
+	r := &Responder{
+		label:    label,
+		host:     label + "." + domain,
+		addrs:    addrs,
+		conn:     conn,
+		services: make(map[string]Service),
+	}
+	go r.serve()
+	return r, nil
+}
+
+// Host returns the .local name that resolves to this machine
+func (r *Responder) Host() string {
+	return strings.TrimSuffix(r.host, ".")
+}
+
+// Set advertises svc, replacing any earlier version with the same ID
+func (r *Responder) Set(svc Service) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.services[svc.ID] = svc
+	r.mu.Unlock()
+
+	// Announce twice, a second apart, in case the first packet is lost
+	announce := r.announcement(svc, 1)
+	r.send(announce, mdnsGroup)
+	time.AfterFunc(time.Second, func() {
+		r.mu.Lock()
+		current, ok := r.services[svc.ID]
+		r.mu.Unlock()
+		if ok && current == svc {
+			r.send(announce, mdnsGroup)
+		}
+	})
+}
+
+// Remove withdraws the service with the given ID
+func (r *Responder) Remove(id string) {
+	r.mu.Lock()
+	svc, ok := r.services[id]
+	delete(r.services, id)
+	r.mu.Unlock()
+	if ok {
+		r.send(r.announcement(svc, 0), mdnsGroup)
+	}
+}
+
+// Close withdraws every service and stops answering queries
+func (r *Responder) Close() {
+	r.mu.Lock()
+	services := r.services
+	r.services = make(map[string]Service)
+	r.closed = true
+	r.mu.Unlock()
+
+	for _, svc := range services {
+		r.send(r.announcement(svc, 0), mdnsGroup)
+	}
+	r.conn.Close()
+}
+
+// announcement builds an unsolicited response for svc. A TTL scale of 0
+// turns it into a goodbye that removes the service from caches.
+func (r *Responder) announcement(svc Service, scale uint32) *dnsmsg.Message {
+	ptr, srv, txt := r.serviceRecords(svc, scale)
+	msg := &dnsmsg.Message{Response: true, Answers: []dnsmsg.Record{ptr, srv, txt}}
+	if scale > 0 {
+		msg.Answers = append(msg.Answers, r.hostRecords(dnsmsg.TypeANY, hostTTL)...)
+	}
+	return msg
+}
+
+func (r *Responder) instance(svc Service) string {
+	return r.label + " " + strings.ReplaceAll(svc.ID, ".", "-") + "." + serviceName
+}
+
+func (r *Responder) serviceRecords(svc Service, scale uint32) (ptr, srv, txt dnsmsg.Record) {
+	name := r.instance(svc)
+	ptr = dnsmsg.Record{Name: serviceName, Type: dnsmsg.TypePTR, TTL: serviceTTL * scale, Target: name}
+	srv = dnsmsg.Record{Name: name, Type: dnsmsg.TypeSRV, Flush: true, TTL: hostTTL * scale, Port: uint16(svc.Port), Target: r.host}
+	txt = dnsmsg.Record{Name: name, Type: dnsmsg.TypeTXT, Flush: true, TTL: serviceTTL * scale, Text: svc.txt()}
+	return ptr, srv, txt
+}
+
+// hostRecords returns the A and AAAA records for the host name, limited to
+// qtype unless it is typeANY
+func (r *Responder) hostRecords(qtype uint16, ttl uint32) []dnsmsg.Record {
+	var records []dnsmsg.Record
+	for _, addr := range r.addrs() {
+		addr, _, _ = strings.Cut(addr, "%") // Zones have no place in DNS
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.IsLoopback() {
+			continue
+		}
+		rtype := uint16(dnsmsg.TypeAAAA)
+		if ip.To4() != nil {
+			rtype = dnsmsg.TypeA
+		}
+		if qtype == dnsmsg.TypeANY || qtype == rtype {
+			records = append(records, dnsmsg.Record{Name: r.host, Type: rtype, Flush: true, TTL: ttl, IP: ip})
+		}
+	}
+	return records
+}
+
+func (r *Responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg, err := dnsmsg.Parse(buf[:n])
+		if err != nil || msg.Response {
+			continue
+		}
+
+		reply := r.answer(msg)
+		if reply == nil {
+			continue
+		}
+		switch {
+		case from.Port != mdnsGroup.Port:
+			// A querier on an ordinary port, such as a plain DNS resolver or
+			// Browse, is answered directly (RFC 6762 section 6.7). It expects
+			// its ID and questions back, and short TTLs.
+			reply.ID = msg.ID
+			reply.Questions = msg.Questions
+			for _, records := range [][]dnsmsg.Record{reply.Answers, reply.Extra} {
+				for i := range records {
+					records[i].Flush = false
+					records[i].TTL = min(records[i].TTL, 10)
+				}
+			}
+			r.send(reply, from)
+		case unicastRequested(msg):
+			r.send(reply, from)
+		default:
+			r.send(reply, mdnsGroup)
+		}
+	}
+}
+
+func unicastRequested(msg *dnsmsg.Message) bool {
+	for _, q := range msg.Questions {
+		if !q.Unicast {
+			return false
+		}
+	}
+	return true
+}
+
+// answer builds the response to a query, or nil when it asks about nothing
+// we know
+func (r *Responder) answer(query *dnsmsg.Message) *dnsmsg.Message {
+	r.mu.Lock()
+	services := make([]Service, 0, len(r.services))
+	for _, svc := range r.services {
+		services = append(services, svc)
+	}
+	r.mu.Unlock()
+
+	reply := &dnsmsg.Message{Response: true}
+	needHost := false
+	for _, q := range query.Questions {
+		name := strings.ToLower(q.Name)
+		switch {
+		case name == metaQuery && (q.Type == dnsmsg.TypePTR || q.Type == dnsmsg.TypeANY) && len(services) > 0:
+			reply.Answers = append(reply.Answers, dnsmsg.Record{Name: metaQuery, Type: dnsmsg.TypePTR, TTL: serviceTTL, Target: serviceName})
+
+		case name == serviceName && (q.Type == dnsmsg.TypePTR || q.Type == dnsmsg.TypeANY):
+			for _, svc := range services {
+				ptr, srv, txt := r.serviceRecords(svc, 1)
+				reply.Answers = append(reply.Answers, ptr)
+				reply.Extra = append(reply.Extra, srv, txt)
+				needHost = true
+			}
+
+		case name == strings.ToLower(r.host):
+			reply.Answers = append(reply.Answers, r.hostRecords(q.Type, hostTTL)...)
+
+		default:
+			for _, svc := range services {
+				if name != strings.ToLower(r.instance(svc)) {
+					continue
+				}
+				_, srv, txt := r.serviceRecords(svc, 1)
+				if q.Type == dnsmsg.TypeSRV || q.Type == dnsmsg.TypeANY {
+					reply.Answers = append(reply.Answers, srv)
+					needHost = true
+				}
+				if q.Type == dnsmsg.TypeTXT || q.Type == dnsmsg.TypeANY {
+					reply.Answers = append(reply.Answers, txt)
+				}
+			}
+		}
+	}
+	if len(reply.Answers) == 0 {
+		return nil
+	}
+	if needHost {
+		reply.Extra = append(reply.Extra, r.hostRecords(dnsmsg.TypeANY, hostTTL)...)
+	}
+	return reply
+}
+
+func (r *Responder) send(msg *dnsmsg.Message, to *net.UDPAddr) {
+	r.conn.WriteToUDP(msg.Pack(), to)
+}
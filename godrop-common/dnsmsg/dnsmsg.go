@@ -0,0 +1,280 @@
+// Package dnsmsg reads and writes just enough of the DNS wire format
+// (RFC 1035) for multicast DNS. Names are written uncompressed; compressed
+// names are understood when reading.
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Record types
+const (
+	TypeA    = 1
+	TypePTR  = 12
+	TypeTXT  = 16
+	TypeAAAA = 28
+	TypeSRV  = 33
+	TypeANY  = 255
+)
+
+const (
+	classIN = 1
+
+	// The top bit of the class is the unicast-response bit in questions and
+	// the cache-flush bit in answers (RFC 6762 sections 5.4 and 10.2)
+	classTopBit = 0x8000
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+)
+
+// ErrMalformed is returned for messages that can't be parsed
+var ErrMalformed = errors.New("malformed DNS message")
+
+// Question asks for the records of a name and type
+type Question struct {
+	Name    string
+	Type    uint16
+	Unicast bool // The querier asked for a unicast reply
+}
+
+// Record is a resource record; only the fields of its type are set
+type Record struct {
+	Name  string
+	Type  uint16
+	Flush bool // Replaces other cached records of this name and type
+	TTL   uint32
+
+	Target string   // PTR and SRV
+	Port   uint16   // SRV
+	Text   []string // TXT
+	IP     net.IP   // A and AAAA
+}
+
+// Message is a DNS query or response
+type Message struct {
+	ID        uint16
+	Response  bool
+	Questions []Question
+	Answers   []Record
+	Extra     []Record // Additional records; authority records are skipped
+}
+
+// Pack encodes m for the wire
+func (m *Message) Pack() []byte {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	if m.Response {
+		binary.BigEndian.PutUint16(b[2:], flagResponse|flagAuthoritative)
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Extra)))
+
+	for _, q := range m.Questions {
+		b = appendName(b, q.Name)
+		class := uint16(classIN)
+		if q.Unicast {
+			class |= classTopBit
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, class)
+	}
+	for _, rr := range m.Answers {
+		b = appendRecord(b, rr)
+	}
+	for _, rr := range m.Extra {
+		b = appendRecord(b, rr)
+	}
+	return b
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendRecord(b []byte, rr Record) []byte {
+	b = appendName(b, rr.Name)
+	class := uint16(classIN)
+	if rr.Flush {
+		class |= classTopBit
+	}
+	b = binary.BigEndian.AppendUint16(b, rr.Type)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, rr.TTL)
+
+	lenAt := len(b)
+	b = append(b, 0, 0)
+	switch rr.Type {
+	case TypePTR:
+		b = appendName(b, rr.Target)
+	case TypeSRV:
+		b = append(b, 0, 0, 0, 0) // Priority and weight
+		b = binary.BigEndian.AppendUint16(b, rr.Port)
+		b = appendName(b, rr.Target)
+	case TypeTXT:
+		if len(rr.Text) == 0 {
+			b = append(b, 0)
+		}
+		for _, s := range rr.Text {
+			if len(s) > 255 {
+				s = s[:255]
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case TypeA:
+		b = append(b, rr.IP.To4()...)
+	case TypeAAAA:
+		b = append(b, rr.IP.To16()...)
+	}
+	binary.BigEndian.PutUint16(b[lenAt:], uint16(len(b)-lenAt-2))
+	return b
+}
+
+// Parse decodes a message read off the wire
+func Parse(b []byte) (*Message, error) {
+	if len(b) < 12 {
+		return nil, ErrMalformed
+	}
+	m := &Message{
+		ID:       binary.BigEndian.Uint16(b[0:]),
+		Response: binary.BigEndian.Uint16(b[2:])&flagResponse != 0,
+	}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	an := int(binary.BigEndian.Uint16(b[6:]))
+	ns := int(binary.BigEndian.Uint16(b[8:]))
+	ar := int(binary.BigEndian.Uint16(b[10:]))
+
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(b) {
+			return nil, ErrMalformed
+		}
+		class := binary.BigEndian.Uint16(b[off+2:])
+		m.Questions = append(m.Questions, Question{
+			Name:    name,
+			Type:    binary.BigEndian.Uint16(b[off:]),
+			Unicast: class&classTopBit != 0,
+		})
+		off += 4
+	}
+
+	for i := 0; i < an+ns+ar; i++ {
+		rr, n, err := readRecord(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		switch {
+		case i < an:
+			m.Answers = append(m.Answers, rr)
+		case i >= an+ns:
+			m.Extra = append(m.Extra, rr)
+		}
+	}
+	return m, nil
+}
+
+func readRecord(b []byte, off int) (Record, int, error) {
+	var rr Record
+	name, off, err := readName(b, off)
+	if err != nil {
+		return rr, 0, err
+	}
+	if off+10 > len(b) {
+		return rr, 0, ErrMalformed
+	}
+	rr.Name = name
+	rr.Type = binary.BigEndian.Uint16(b[off:])
+	rr.Flush = binary.BigEndian.Uint16(b[off+2:])&classTopBit != 0
+	rr.TTL = binary.BigEndian.Uint32(b[off+4:])
+	size := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	end := off + size
+	if end > len(b) {
+		return rr, 0, ErrMalformed
+	}
+
+	data := b[off:end]
+	switch rr.Type {
+	case TypePTR:
+		rr.Target, _, err = readName(b, off)
+	case TypeSRV:
+		if size < 7 {
+			return rr, 0, ErrMalformed
+		}
+		rr.Port = binary.BigEndian.Uint16(data[4:])
+		rr.Target, _, err = readName(b, off+6)
+	case TypeTXT:
+		for len(data) > 0 {
+			n := int(data[0])
+			if 1+n > len(data) {
+				return rr, 0, ErrMalformed
+			}
+			if n > 0 {
+				rr.Text = append(rr.Text, string(data[1:1+n]))
+			}
+			data = data[1+n:]
+		}
+	case TypeA, TypeAAAA:
+		rr.IP = net.IP(append([]byte(nil), data...))
+	}
+	if err != nil {
+		return rr, 0, err
+	}
+	return rr, end, nil
+}
+
+// readName reads the name at off, following compression pointers, and
+// returns it along with the offset just past it
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, ErrMalformed
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(b) || jumps > 16 {
+				return "", 0, ErrMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(b) {
+				return "", 0, ErrMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
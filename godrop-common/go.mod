@@ -0,0 +1,3 @@
+module godrop-common
+
+go 1.24
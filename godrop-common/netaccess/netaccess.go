@@ -0,0 +1,140 @@
+// Package netaccess decides which client addresses a share admits, from
+// allow and deny rules.
+package netaccess
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Access rules say which clients may use a share. A rule is an IP address, a
+// CIDR block, a network interface name (clients on that interface's
+// networks), or one of the words below.
+const (
+	Any       = "any"       // Every client
+	Subnet    = "subnet"    // Clients on any network this machine is on, itself included
+	Localhost = "localhost" // This machine only
+)
+
+// DefaultAllow is the allow list shares start with
+var DefaultAllow = []string{Subnet}
+
+// List decides which client addresses a share admits. Deny rules win
+// over allow rules, and an empty allow list admits everyone not denied.
+type List struct {
+	allow []func(net.IP) bool
+	deny  []func(net.IP) bool
+}
+
+// New parses allow and deny rules
+func New(allow []string, deny []string) (*List, error) {
+	a := &List{}
+	for _, list := range []struct {
+		rules []string
+		into  *[]func(net.IP) bool
+	}{{allow, &a.allow}, {deny, &a.deny}} {
+		for _, rule := range list.rules {
+			match, err := parseRule(rule)
+			if err != nil {
+				return nil, err
+			}
+			*list.into = append(*list.into, match)
+		}
+	}
+	return a, nil
+}
+
+// Clean trims rules and drops empty ones, e.g. from a comma
+// separated list that was split
+func Clean(rules []string) []string {
+	clean := []string{}
+	for _, rule := range rules {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			clean = append(clean, rule)
+		}
+	}
+	return clean
+}
+
+// Admits reports whether a client at ip may use the share
+func (a *List) Admits(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, match := range a.deny {
+		if match(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, match := range a.allow {
+		if match(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP extracts the address from a request's RemoteAddr (host:port),
+// dropping any IPv6 zone
+func ClientIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(strings.SplitN(host, "%", 2)[0])
+}
+
+func parseRule(rule string) (func(net.IP) bool, error) {
+	rule = strings.TrimSpace(rule)
+	switch strings.ToLower(rule) {
+	case Any, "all", "*":
+		return func(net.IP) bool { return true }, nil
+	case Subnet:
+		return onLocalNetwork, nil
+	case Localhost:
+		return net.IP.IsLoopback, nil
+	}
+
+	if strings.Contains(rule, "/") {
+		_, block, err := net.ParseCIDR(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR block %q", rule)
+		}
+		return block.Contains, nil
+	}
+	if addr := net.ParseIP(strings.SplitN(rule, "%", 2)[0]); addr != nil {
+		return addr.Equal, nil
+	}
+	if _, err := net.InterfaceByName(rule); err != nil {
+		return nil, fmt.Errorf("%q is not an address, CIDR block or network interface", rule)
+	}
+	// Looked up on every check, since the interface's addresses can change
+	return func(ip net.IP) bool {
+		iface, err := net.InterfaceByName(rule)
+		if err != nil {
+			return false
+		}
+		addrs, _ := iface.Addrs()
+		return networksContain(addrs, ip)
+	}, nil
+}
+
+// onLocalNetwork reports whether ip is on one of the networks this machine
+// has an address on, loopback included
+func onLocalNetwork(ip net.IP) bool {
+	addrs, _ := net.InterfaceAddrs()
+	return networksContain(addrs, ip)
+}
+
+func networksContain(addrs []net.Addr, ip net.IP) bool {
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package netaccess
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewRejects(t *testing.T) {
+	for _, rule := range []string{"10.0.0.0/33", "10.0.0/8", "no-such-interface0", "300.1.1.1"} {
+		if _, err := New([]string{rule}, nil); err == nil {
+			t.Errorf("allow rule %q was accepted", rule)
+		}
+		if _, err := New(nil, []string{rule}); err == nil {
+			t.Errorf("deny rule %q was accepted", rule)
+		}
+	}
+}
+
+func TestAdmits(t *testing.T) {
+	cases := []struct {
+		name  string
+		allow []string
+		deny  []string
+		ip    string
+		want  bool
+	}{
+		{"no rules", nil, nil, "203.0.113.5", true},
+		{"any", []string{"any"}, nil, "203.0.113.5", true},
+		{"star", []string{"*"}, nil, "2001:db8::1", true},
+		{"address", []string{"192.0.2.7"}, nil, "192.0.2.7", true},
+		{"other address", []string{"192.0.2.7"}, nil, "192.0.2.8", false},
+		{"address with zone", []string{"fe80::1%eth0"}, nil, "fe80::1", true},
+		{"inside block", []string{"10.0.0.0/8"}, nil, "10.20.30.40", true},
+		{"outside block", []string{"10.0.0.0/8"}, nil, "11.0.0.1", false},
+		{"IPv6 block", []string{"2001:db8::/32"}, nil, "2001:db8:1::1", true},
+		{"several allow rules", []string{"192.0.2.7", "10.0.0.0/8"}, nil, "10.1.1.1", true},
+		{"localhost", []string{"LocalHost"}, nil, "127.0.0.1", true},
+		{"localhost over IPv6", []string{"localhost"}, nil, "::1", true},
+		{"localhost only", []string{"localhost"}, nil, "192.0.2.7", false},
+		{"subnet has loopback", []string{"subnet"}, nil, "127.0.0.1", true},
+		{"subnet leaves out the internet", []string{"subnet"}, nil, "203.0.113.5", false},
+		{"deny wins", []string{"10.0.0.0/8"}, []string{"10.0.0.5"}, "10.0.0.5", false},
+		{"deny only", nil, []string{"10.0.0.0/8"}, "10.0.0.5", false},
+		{"deny only lets others in", nil, []string{"10.0.0.0/8"}, "192.0.2.7", true},
+		{"deny any", []string{"any"}, []string{"any"}, "192.0.2.7", false},
+		{"padded rule", []string{" 192.0.2.7 "}, nil, "192.0.2.7", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := New(tc.allow, tc.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := list.Admits(net.ParseIP(tc.ip)); got != tc.want {
+				t.Errorf("Admits(%s) = %v, want %v", tc.ip, got, tc.want)
+			}
+		})
+	}
+
+	list, _ := New(nil, nil)
+	if list.Admits(nil) {
+		t.Error("a client without an address was admitted")
+	}
+}
+
+func TestInterfaceRule(t *testing.T) {
+	ifaces, _ := net.Interfaces()
+	var lo string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			lo = iface.Name
+		}
+	}
+	if lo == "" {
+		t.Skip("no loopback interface")
+	}
+	list, err := New([]string{lo}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Admits(net.ParseIP("127.0.0.1")) {
+		t.Errorf("%s does not admit 127.0.0.1", lo)
+	}
+	if list.Admits(net.ParseIP("203.0.113.5")) {
+		t.Errorf("%s admits 203.0.113.5", lo)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		remote string
+		want   string
+	}{
+		{"192.0.2.7:51000", "192.0.2.7"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"[fe80::1%eth0]:443", "fe80::1"},
+		{"192.0.2.7", "192.0.2.7"},
+		{"garbage", "<nil>"},
+	}
+	for _, tc := range cases {
+		if got := ClientIP(tc.remote).String(); got != tc.want {
+			t.Errorf("ClientIP(%q) = %s, want %s", tc.remote, got, tc.want)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	got := Clean([]string{" subnet", "", "  ", "10.0.0.0/8 "})
+	if len(got) != 2 || got[0] != "subnet" || got[1] != "10.0.0.0/8" {
+		t.Errorf("Clean gave %q", got)
+	}
+}
@@ -0,0 +1,85 @@
+// Package ratelimit counts events per client address over a sliding window,
+// for the GUI's and the CLI's servers alike.
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maxClients bounds the addresses a Limiter tracks. Once that many
+// are busy, new ones are turned away rather than forgetting the others.
+const maxClients = 4096
+
+// Limiter counts events, like requests or wrong passwords, per client
+// address over a sliding window. IPv6 clients are counted by their /64, since
+// a single host can pick any address in it.
+type Limiter struct {
+	max    int
+	window time.Duration
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+// New allows each client max events per window
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{max: max, window: window, events: make(map[string][]time.Time)}
+}
+
+// Exceeded reports whether ip has used up its events for now
+func (l *Limiter) Exceeded(ip net.IP) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.recent(rateKey(ip), time.Now())) >= l.max
+}
+
+// Add records an event for ip, or reports false without recording it when
+// ip has used up its events
+func (l *Limiter) Add(ip net.IP) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key, now := rateKey(ip), time.Now()
+	events := l.recent(key, now)
+	if len(events) >= l.max {
+		return false
+	}
+	if len(events) == 0 && len(l.events) >= maxClients {
+		for k := range l.events {
+			l.recent(k, now)
+		}
+		if len(l.events) >= maxClients {
+			return false
+		}
+	}
+	l.events[key] = append(events, now)
+	return true
+}
+
+// recent drops key's events that left the window and returns the rest.
+// Callers must hold l.mu.
+func (l *Limiter) recent(key string, now time.Time) []time.Time {
+	events := l.events[key]
+	i := 0
+	for i < len(events) && now.Sub(events[i]) >= l.window {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(l.events, key)
+		return nil
+	}
+	l.events[key] = events
+	return events
+}
+
+func rateKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	if ip == nil {
+		return ""
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(2, 50*time.Millisecond)
+	a, b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+
+	if !l.Add(a) || !l.Add(a) {
+		t.Fatal("the first two events were refused")
+	}
+	if l.Add(a) || !l.Exceeded(a) {
+		t.Error("a third event within the window was allowed")
+	}
+	if l.Exceeded(b) {
+		t.Error("another address shares the count")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if l.Exceeded(a) || !l.Add(a) {
+		t.Error("the events did not leave the window")
+	}
+}
+
+func TestLimiterGroupsIPv6By64(t *testing.T) {
+	l := New(1, time.Minute)
+	l.Add(net.ParseIP("2001:db8::1"))
+	if !l.Exceeded(net.ParseIP("2001:db8::ffff")) {
+		t.Error("an address in the same /64 got its own count")
+	}
+	if l.Exceeded(net.ParseIP("2001:db8:0:1::1")) {
+		t.Error("an address in another /64 shares the count")
+	}
+}
+
+func TestLimiterCapsClients(t *testing.T) {
+	l := New(1, time.Minute)
+	for i := 0; i < maxClients; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		if !l.Add(ip) {
+			t.Fatalf("client %d was refused below the cap", i)
+		}
+	}
+	if l.Add(net.ParseIP("192.0.2.1")) {
+		t.Error("a client past the cap was tracked")
+	}
+	if len(l.events) > maxClients {
+		t.Errorf("%d clients are tracked, want at most %d", len(l.events), maxClients)
+	}
+}
@@ -0,0 +1,179 @@
+// Package stream is godrop's encrypted stream format, used for files
+// encrypted at rest and for the CLI's end-to-end encrypted transfers.
+//
+// A stream is a sequence of AES-256-GCM records, each a 4-byte big-endian
+// length followed by that many bytes of ciphertext. Every record holds up to
+// Chunk bytes of plaintext. The nonce is the record number as 11 big-endian
+// bytes plus a byte that is 1 on the last record only, so records can't be
+// reordered, dropped or cut off without detection.
+package stream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Chunk is the most plaintext a record holds
+const Chunk = 64 << 10
+
+// ErrTruncated is returned when a stream stops before its final record
+var ErrTruncated = errors.New("encrypted stream ended early")
+
+// ErrCorrupt is returned when a record doesn't open with the key
+var ErrCorrupt = errors.New("encrypted stream is corrupt or the key is wrong")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func streamNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// writer encrypts everything written to it. Close writes the final record
+// and must be called.
+type writer struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	buf     []byte
+	counter uint64
+}
+
+// NewWriter returns a writer that encrypts to w with a 32-byte key. The
+// stream is only complete once the writer is closed.
+func NewWriter(key []byte, w io.Writer) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{aead: aead, w: w, buf: make([]byte, 0, Chunk)}, nil
+}
+
+func (s *writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, since the
+		// last record has to be marked as such
+		if len(s.buf) == Chunk {
+			if err := s.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		m := copy(s.buf[len(s.buf):Chunk], p)
+		s.buf = s.buf[:len(s.buf)+m]
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (s *writer) Close() error {
+	return s.seal(true)
+}
+
+func (s *writer) seal(final bool) error {
+	sealed := s.aead.Seal(nil, streamNonce(s.counter, final), s.buf, nil)
+	s.counter++
+	s.buf = s.buf[:0]
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := s.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+// reader decrypts a stream, failing if any record was tampered with or the
+// stream stops before the final record
+type reader struct {
+	aead    cipher.AEAD
+	r       io.Reader
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+// NewReader returns a reader that decrypts the stream in r with a 32-byte
+// key. Reading fails with ErrTruncated or ErrCorrupt unless the whole stream
+// is intact.
+func NewReader(key []byte, r io.Reader) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{aead: aead, r: r}, nil
+}
+
+func (s *reader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *reader) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(s.r, size[:]); err != nil {
+		return ErrTruncated
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > Chunk+uint32(s.aead.Overhead()) {
+		return ErrCorrupt
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(s.r, sealed); err != nil {
+		return ErrTruncated
+	}
+
+	// A record that only opens as the final one ends the stream
+	plain, err := s.aead.Open(nil, streamNonce(s.counter, false), sealed, nil)
+	if err != nil {
+		plain, err = s.aead.Open(nil, streamNonce(s.counter, true), sealed, nil)
+		if err != nil {
+			return ErrCorrupt
+		}
+		s.done = true
+	}
+	s.counter++
+	s.buf = plain
+	return nil
+}
+
+// SealMessage encrypts a short message as a single-record stream, without
+// the length prefix
+func SealMessage(key []byte, msg []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, streamNonce(0, true), msg, nil), nil
+}
+
+// OpenMessage decrypts a message sealed by SealMessage
+func OpenMessage(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, streamNonce(0, true), sealed, nil)
+}
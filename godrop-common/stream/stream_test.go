@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+// encrypt returns plain as a stream under testKey
+func encrypt(t *testing.T, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(testKey, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key []byte, sealed []byte) ([]byte, error) {
+	r, err := NewReader(key, bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// records splits a stream into its records, length prefixes included
+func records(sealed []byte) [][]byte {
+	var out [][]byte
+	for len(sealed) >= 4 {
+		n := 4 + int(binary.BigEndian.Uint32(sealed))
+		out = append(out, sealed[:n])
+		sealed = sealed[n:]
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, Chunk - 1, Chunk, Chunk + 1, 3*Chunk + 17} {
+		plain := bytes.Repeat([]byte("godrop"), size/6+1)[:size]
+		got, err := decrypt(testKey, encrypt(t, plain))
+		if err != nil {
+			t.Errorf("%d bytes: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes came back as %d different bytes", size, len(got))
+		}
+	}
+}
+
+func TestTamper(t *testing.T) {
+	plain := bytes.Repeat([]byte("x"), 2*Chunk+100)
+	sealed := encrypt(t, plain)
+	recs := records(sealed)
+	if len(recs) != 3 {
+		t.Fatalf("got %d records, want 3", len(recs))
+	}
+	join := func(recs ...[]byte) []byte { return bytes.Join(recs, nil) }
+	flipped := append([]byte(nil), sealed...)
+	flipped[len(flipped)/2] ^= 1
+
+	cases := []struct {
+		name   string
+		sealed []byte
+		key    []byte
+		want   error
+	}{
+		{"flipped bit", flipped, testKey, ErrCorrupt},
+		{"wrong key", sealed, bytes.Repeat([]byte{8}, 32), ErrCorrupt},
+		{"cut mid-record", sealed[:len(sealed)-10], testKey, ErrTruncated},
+		{"cut in a length", sealed[:len(recs[0])+2], testKey, ErrTruncated},
+		{"missing final record", join(recs[0], recs[1]), testKey, ErrTruncated},
+		{"reordered", join(recs[1], recs[0], recs[2]), testKey, ErrCorrupt},
+		{"dropped record", join(recs[0], recs[2]), testKey, ErrCorrupt},
+		{"empty", nil, testKey, ErrTruncated},
+		{"oversized length", []byte{0xff, 0xff, 0xff, 0xff}, testKey, ErrCorrupt},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decrypt(tc.key, tc.sealed); !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	sealed, err := SealMessage(testKey, []byte("report.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := OpenMessage(testKey, sealed); err != nil || string(msg) != "report.pdf" {
+		t.Errorf("OpenMessage = %q, %v", msg, err)
+	}
+	sealed[0] ^= 1
+	if _, err := OpenMessage(testKey, sealed); err == nil {
+		t.Error("a tampered message opened")
+	}
+}
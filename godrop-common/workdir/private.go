@@ -0,0 +1,33 @@
+package workdir
+
+import (
+	"fmt"
+	"os"
+)
+
+// MkdirPrivate creates dir, if needed, as a directory only the current user
+// can use, and checks that it is one. A directory at a predictable path may
+// have been planted by someone else, so a symlink, a directory owned by
+// another user or one with a mode other than 0700 is refused.
+func MkdirPrivate(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return CheckPrivate(dir)
+}
+
+// CheckPrivate reports why dir is not a directory private to the current
+// user, or nil when it is
+func CheckPrivate(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symlink", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return checkOwner(dir, info)
+}
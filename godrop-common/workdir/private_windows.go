@@ -0,0 +1,11 @@
+//go:build windows
+
+package workdir
+
+import "os"
+
+// checkOwner accepts any directory. Windows has no Unix owner or mode to
+// check and the temporary directory is already per user.
+func checkOwner(dir string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,132 @@
+// Package workdir gives each godrop process a private directory for its
+// temporary archives, inside a root shared by every godrop process of the
+// user, and sweeps the directories that crashed processes left behind.
+package workdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	ownerFile = "owner.pid" // Records which process a directory belongs to
+	dirPrefix = "godrop-"   // Directories are named godrop-<pid>
+)
+
+// Dir is this process's directory for temporary archives
+type Dir struct {
+	Root string
+	Path string
+}
+
+// UserTempDir is the current user's godrop directory in the system's
+// temporary directory. Its path is predictable, so it is only used once
+// MkdirPrivate or CheckPrivate accepted it.
+func UserTempDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("godrop-%d", os.Getuid()))
+}
+
+// DefaultRoot is used when no working directory is configured. The
+// GODROP_WORKDIR environment variable overrides it.
+func DefaultRoot() string {
+	if dir := os.Getenv("GODROP_WORKDIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(UserTempDir(), "work")
+}
+
+// isTempRoot reports whether root is the default root in the temporary
+// directory, as opposed to one the user picked
+func isTempRoot(root string) bool {
+	return root == filepath.Join(UserTempDir(), "work")
+}
+
+// Open creates this process's directory under root, the default root when
+// empty, and records the PID that owns it
+func Open(root string) (*Dir, error) {
+	if root == "" {
+		root = DefaultRoot()
+	}
+	if isTempRoot(root) {
+		if err := MkdirPrivate(UserTempDir()); err != nil {
+			return nil, err
+		}
+		if err := MkdirPrivate(root); err != nil {
+			return nil, err
+		}
+	}
+	pid := os.Getpid()
+	dir := filepath.Join(root, dirPrefix+strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(strconv.Itoa(pid)), 0600); err != nil {
+		return nil, err
+	}
+	return &Dir{Root: root, Path: dir}, nil
+}
+
+// Close removes the directory and everything left in it
+func (d *Dir) Close() error {
+	return os.RemoveAll(d.Path)
+}
+
+// Sweep removes the directories under root, the default root when empty,
+// whose owning process is no longer running, and returns their paths. Since
+// root may be any folder the user picked, only a directory named like ours
+// whose owner file names that same, dead, process is removed; anything else
+// is left alone.
+func Sweep(root string) ([]string, error) {
+	if root == "" {
+		root = DefaultRoot()
+	}
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isTempRoot(root) {
+		if err := CheckPrivate(UserTempDir()); err != nil {
+			return nil, err
+		}
+		if err := CheckPrivate(root); err != nil {
+			return nil, err
+		}
+	}
+
+	var removed []string
+	for _, e := range entries {
+		dir := filepath.Join(root, e.Name())
+		if !e.IsDir() || !stale(dir, e.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
+
+// stale reports whether dir, called name, was made by a godrop process that
+// has exited
+func stale(dir string, name string) bool {
+	pid, err := strconv.Atoi(strings.TrimPrefix(name, dirPrefix))
+	if !strings.HasPrefix(name, dirPrefix) || err != nil || pid <= 0 {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil {
+		return false
+	}
+	owner, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || owner != pid {
+		return false
+	}
+	return pid != os.Getpid() && !processAlive(pid)
+}
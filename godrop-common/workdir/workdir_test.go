@@ -0,0 +1,135 @@
+package workdir
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// deadPID returns the PID of a process that has already exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestSweep(t *testing.T) {
+	root := t.TempDir()
+	dead := strconv.Itoa(deadPID(t))
+	self := strconv.Itoa(os.Getpid())
+
+	cases := []struct {
+		name  string
+		owner string // Contents of owner.pid; no file when empty
+		swept bool
+	}{
+		{"godrop-" + dead, dead, true},
+		{"godrop-" + self, self, false},     // Still running
+		{"Photos", "", false},               // A folder of the user's
+		{"godrop-" + dead + "x", "", false}, // Not named like ours
+		{"godrop-1" + dead, "", false},      // No owner file
+		{dead, dead, false},                 // Named like older versions did
+		{"godrop-2" + dead, "garbage", false},
+		{"godrop-3" + dead, dead, false}, // Owner file names another process
+	}
+	for _, tc := range cases {
+		dir := filepath.Join(root, tc.name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if tc.owner != "" {
+			if err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(tc.owner), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A loose file in the root is never touched either
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := Sweep(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 {
+		t.Errorf("Sweep removed %v, want only godrop-%s", removed, dead)
+	}
+	for _, tc := range cases {
+		_, err := os.Stat(filepath.Join(root, tc.name))
+		if tc.swept && !os.IsNotExist(err) {
+			t.Errorf("%s was not swept", tc.name)
+		}
+		if !tc.swept && err != nil {
+			t.Errorf("%s did not survive the sweep: %v", tc.name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "notes.txt")); err != nil {
+		t.Errorf("notes.txt did not survive the sweep: %v", err)
+	}
+}
+
+func TestOpenAndClose(t *testing.T) {
+	root := t.TempDir()
+	d, err := Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := Sweep(root); err != nil || len(removed) != 0 {
+		t.Errorf("Sweep removed %v (%v) while its owner runs", removed, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.Path); !os.IsNotExist(err) {
+		t.Errorf("%s is still there after Close", d.Path)
+	}
+}
+
+func TestMkdirPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no Unix modes to check")
+	}
+	base := t.TempDir()
+	open := filepath.Join(base, "open")
+	if err := os.Mkdir(open, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(open, 0755); err != nil { // Past the umask
+		t.Fatal(err)
+	}
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Skip("symlinks are not available:", err)
+	}
+
+	cases := []struct {
+		name string
+		dir  string
+		ok   bool
+	}{
+		{"new", filepath.Join(base, "new", "nested"), true},
+		{"symlink", link, false},
+		{"readable by others", open, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := MkdirPrivate(tc.dir)
+			if tc.ok && err != nil {
+				t.Errorf("MkdirPrivate(%s) = %v", tc.dir, err)
+			}
+			if !tc.ok && err == nil {
+				t.Errorf("MkdirPrivate(%s) accepted the directory", tc.dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// listenFdsStart is the first file descriptor systemd passes to an activated service
+const listenFdsStart = 3
+
+// activationListener returns the control API socket handed over by systemd
+// socket activation, or nil when the process was started normally
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Keep the variables from leaking into anything we start
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("invalid activation socket: %w", err)
+	}
+	return ln, nil
+}
+
+// defaultStatePath is where the daemon keeps its shares when no state file is given
+func defaultStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "godrop", "shares.json")
+}
+
+// defaultDevicesPath is where paired devices are remembered
+func defaultDevicesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "godrop", "devices.json")
+}
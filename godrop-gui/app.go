@@ -2,26 +2,71 @@ package main
 
 import (
 	"context"
+	"godrop-common/discovery"
 	"godrop-gui/backend"
+	"godrop-gui/backend/control"
 	"godrop-gui/backend/server"
+	"log"
+	"sync"
+	"time"
+
+	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App struct acts as a facade between Wails and the backend logic
 type App struct {
-	core *backend.Core
+	ctx      context.Context
+	core     *backend.Core
+	shares   *server.Manager
+	hub      *backend.Hub
+	workRoot string
+	mdns     bool
+
+	consoleMu sync.Mutex
+	console   *control.Console
 }
 
-// NewApp creates a new App application struct
-func NewApp() *App {
+// NewApp creates a new App application struct that keeps temporary archives
+// under workRoot, or the default location when it is empty. With mdns set,
+// shares are advertised on the local network.
+func NewApp(workRoot string, mdns bool) *App {
+	core := backend.NewCore(nil)
 	return &App{
-		core: backend.NewCore(),
+		core:     core,
+		shares:   server.NewManager(core),
+		hub:      backend.NewHub(),
+		workRoot: workRoot,
+		mdns:     mdns,
 	}
 }
 
 // startup is called when the app starts.
 func (a *App) startup(ctx context.Context) {
-	a.core.Ctx = ctx
-	go a.core.MonitorClipboard()
+	a.ctx = ctx
+	a.core.Events = backend.MultiSink{backend.WailsSink{Ctx: ctx}, a.hub}
+	if removed, err := a.core.OpenWorkDir(a.workRoot); err != nil {
+		log.Printf("work directory unavailable, using the system temp dir: %v", err)
+	} else if len(removed) > 0 {
+		log.Printf("removed %d stale archive folder(s)", len(removed))
+	}
+	if devices, err := backend.LoadDevices(defaultDevicesPath()); err != nil {
+		log.Printf("pairing is off: %v", err)
+	} else {
+		a.shares.SetDevices(devices)
+	}
+	if a.mdns {
+		if err := a.shares.EnableDiscovery(); err != nil {
+			log.Printf("shares won't be advertised on the network: %v", err)
+		}
+	}
+	go a.core.MonitorClipboard(ctx)
+}
+
+// shutdown is called when the app is closing.
+func (a *App) shutdown(ctx context.Context) {
+	a.StopAdminConsole()
+	a.shares.Close()
+	a.core.Shutdown()
 }
 
 // --- FILE SYSTEM ---
@@ -38,8 +83,18 @@ func (a *App) GetDefaultSaveDir() string {
 	return a.core.GetDefaultSaveDir()
 }
 
+// SelectDirectory opens a dialog to select a directory
 func (a *App) SelectDirectory() (string, error) {
-	return a.core.SelectDirectory()
+	return wailsRuntime.OpenDirectoryDialog(a.ctx, wailsRuntime.OpenDialogOptions{
+		Title: "Select Save Location",
+	})
+}
+
+// SelectFiles opens a dialog to pick files, e.g. to upload to a nearby share
+func (a *App) SelectFiles() ([]string, error) {
+	return wailsRuntime.OpenMultipleFilesDialog(a.ctx, wailsRuntime.OpenDialogOptions{
+		Title: "Select Files to Send",
+	})
 }
 
 // --- CLIPBOARD ---
@@ -58,41 +113,205 @@ func (a *App) GetHistory() []string {
 
 // --- SERVERS ---
 
-func (a *App) StartServer(port string, password string, files []string, limit int, timeout int) (server.ServerResponse, error) {
-	a.core.ServerMutex.Lock()
-	defer a.core.ServerMutex.Unlock()
+// StartServer starts a new send share and returns its session
+func (a *App) StartServer(port string, password string, files []string, limit int, timeout int) (server.SessionInfo, error) {
+	return a.shares.Send(port, password, files, limit, timeout)
+}
+
+// CancelArchive aborts a send that is still packaging its files, by the ID
+// its archive-progress events carry
+func (a *App) CancelArchive(id string) error {
+	return a.core.CancelArchive(id)
+}
+
+// StartReceiveServer starts a dropzone share. encryptTo, a passphrase or a
+// public key, encrypts every received file; empty leaves them as they are.
+func (a *App) StartReceiveServer(port string, saveDir string, extract bool, encryptTo string) (server.SessionInfo, error) {
+	return a.shares.StartReceive(port, saveDir, extract, encryptTo)
+}
 
-	if a.core.Server != nil {
-		server.Stop(a.core)
+// DecryptFiles opens files a receive share encrypted, writing each one next
+// to it, and returns where they went. It stops at the first file that fails.
+func (a *App) DecryptFiles(paths []string, secret string) ([]string, error) {
+	var out []string
+	for _, path := range paths {
+		dst, err := backend.DecryptFile(path, secret)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, dst)
 	}
+	return out, nil
+}
 
-	return server.StartSend(a.core, port, password, files, limit, timeout)
+// StartClipboardServer shares the clipboard with clients that enter password
+// or are paired; access is "read" or "readwrite"
+func (a *App) StartClipboardServer(port string, password string, access string) (server.SessionInfo, error) {
+	return a.shares.StartClipboard(port, password, access)
 }
 
-func (a *App) StartReceiveServer(port string, saveDir string) (server.ServerResponse, error) {
-	a.core.ServerMutex.Lock()
-	defer a.core.ServerMutex.Unlock()
+// ListSessions returns every share that is currently running
+func (a *App) ListSessions() []server.SessionInfo {
+	return a.shares.List()
+}
 
-	if a.core.Server != nil {
-		server.Stop(a.core)
-	}
+// StopServer stops the share with the given session ID
+func (a *App) StopServer(id string) error {
+	return a.shares.Stop(id)
+}
 
-	return server.StartReceive(a.core, port, saveDir)
+// ExtendSession moves a share's expiry by the given number of minutes
+func (a *App) ExtendSession(id string, minutes int) (server.SessionInfo, error) {
+	return a.shares.Extend(id, minutes)
 }
 
-func (a *App) StartClipboardServer(port string) (server.ServerResponse, error) {
-	a.core.ServerMutex.Lock()
-	defer a.core.ServerMutex.Unlock()
+// SetSessionLimit changes how many downloads a share allows
+func (a *App) SetSessionLimit(id string, limit int) (server.SessionInfo, error) {
+	return a.shares.SetLimit(id, limit)
+}
 
-	if a.core.Server != nil {
-		server.Stop(a.core)
-	}
+// SetSessionPassword changes a share's password; an empty string removes it
+func (a *App) SetSessionPassword(id string, password string) (server.SessionInfo, error) {
+	return a.shares.SetPassword(id, password)
+}
+
+// PauseSession makes a share temporarily unavailable
+func (a *App) PauseSession(id string) (server.SessionInfo, error) {
+	return a.shares.Pause(id)
+}
+
+// ResumeSession makes a paused share available again
+func (a *App) ResumeSession(id string) (server.SessionInfo, error) {
+	return a.shares.Resume(id)
+}
+
+// StopAllServers stops every running share
+func (a *App) StopAllServers() {
+	a.shares.StopAll()
+}
+
+// ListInterfaces returns the network addresses shares can be advertised on
+func (a *App) ListInterfaces() []backend.NetInterface {
+	return backend.ListInterfaces()
+}
+
+// GetInterface returns the selected interface, empty when automatic
+func (a *App) GetInterface() string {
+	return a.shares.Interface()
+}
+
+// GetBind returns where new shares listen
+func (a *App) GetBind() string {
+	return a.shares.Bind()
+}
+
+// SetBind chooses where new shares listen: "all", "localhost", an interface
+// name or an IP address
+func (a *App) SetBind(bind string) error {
+	return a.shares.SetBind(bind)
+}
+
+// GetTLS reports whether new shares are served over HTTPS
+func (a *App) GetTLS() bool {
+	return a.shares.TLS()
+}
+
+// SetTLS chooses whether new shares are served over HTTPS with a self-signed
+// certificate
+func (a *App) SetTLS(on bool) {
+	a.shares.SetTLS(on)
+}
+
+// GetAccess returns the allow and deny rules new shares start with
+func (a *App) GetAccess() server.AccessRules {
+	return a.shares.Access()
+}
+
+// SetAccess sets the allow and deny rules new shares start with
+func (a *App) SetAccess(allow []string, deny []string) error {
+	return a.shares.SetAccess(allow, deny)
+}
+
+// SetSessionAccess replaces the allow and deny rules of a running share
+func (a *App) SetSessionAccess(id string, allow []string, deny []string) (server.SessionInfo, error) {
+	return a.shares.SetSessionAccess(id, allow, deny)
+}
+
+// SetSessionFairUse changes the per-client, concurrency and bandwidth limits
+// of a running send share
+func (a *App) SetSessionFairUse(id string, limits server.FairUse) (server.SessionInfo, error) {
+	return a.shares.SetSessionFairUse(id, limits)
+}
+
+// SetSessionClipboard turns a share's clipboard access off, "read" or
+// "readwrite"
+func (a *App) SetSessionClipboard(id string, access string) (server.SessionInfo, error) {
+	return a.shares.SetSessionClipboard(id, access)
+}
+
+// SetInterface advertises shares on another interface, regenerating the URL
+// and QR code of those already running
+func (a *App) SetInterface(name string) ([]server.SessionInfo, error) {
+	return a.shares.SetInterface(name)
+}
 
-	return server.StartClipboard(a.core, port)
+// --- PAIRED DEVICES ---
+
+// PairDevice returns a one-time pairing link and QR code served by the share
+// with the given ID, which must use HTTPS
+func (a *App) PairDevice(id string) (server.ShareAddress, error) {
+	return a.shares.PairingLink(id)
+}
+
+// ListDevices returns the devices paired with this computer
+func (a *App) ListDevices() []backend.Device {
+	return a.shares.ListDevices()
+}
+
+// RevokeDevice unpairs a device, so it has to enter share passwords again
+func (a *App) RevokeDevice(id string) error {
+	return a.shares.RevokeDevice(id)
 }
 
-func (a *App) StopServer() {
-	a.core.ServerMutex.Lock()
-	defer a.core.ServerMutex.Unlock()
-	server.Stop(a.core)
+// --- NEARBY SHARES ---
+
+// DiscoverShares lists the godrop shares other devices advertise on the local network
+func (a *App) DiscoverShares() ([]discovery.Share, error) {
+	return discovery.Browse(a.ctx, 2*time.Second)
+}
+
+// DownloadNearby saves the file of a nearby send share into dir
+func (a *App) DownloadNearby(share discovery.Share, code string, dir string) (string, error) {
+	return a.core.DownloadNearby(a.ctx, share, code, dir)
+}
+
+// UploadNearby sends files to a nearby receive share
+func (a *App) UploadNearby(share discovery.Share, files []string) error {
+	return a.core.UploadNearby(a.ctx, share, files)
+}
+
+// --- ADMIN CONSOLE ---
+
+// StartAdminConsole serves the browser-based admin console on localhost
+func (a *App) StartAdminConsole() (control.Console, error) {
+	a.consoleMu.Lock()
+	defer a.consoleMu.Unlock()
+	if a.console == nil {
+		console, err := control.StartConsole(control.DefaultConsoleAddr, a.shares, a.hub, "")
+		if err != nil {
+			return control.Console{}, err
+		}
+		a.console = console
+	}
+	return *a.console, nil
+}
+
+// StopAdminConsole shuts the admin console down if it is running
+func (a *App) StopAdminConsole() {
+	a.consoleMu.Lock()
+	defer a.consoleMu.Unlock()
+	if a.console != nil {
+		a.console.Stop()
+		a.console = nil
+	}
 }
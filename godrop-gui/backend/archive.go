@@ -0,0 +1,286 @@
+package backend
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ArchiveProgress is emitted while a zip archive is being built
+type ArchiveProgress struct {
+	ID         string `json:"id"` // The job, for CancelArchive
+	FilesDone  int    `json:"filesDone"`
+	FilesTotal int    `json:"filesTotal"`
+	BytesDone  int64  `json:"bytesDone"`
+	BytesTotal int64  `json:"bytesTotal"`
+	Current    string `json:"current"`
+}
+
+// ArchiveFailure describes a file that could not be added to an archive
+type ArchiveFailure struct {
+	ID    string `json:"id"` // The job the file was skipped by
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// archiveJob streams a file selection into a zip writer and reports progress
+type archiveJob struct {
+	id       string
+	core     *Core
+	ctx      context.Context
+	cancel   context.CancelFunc
+	zw       *zip.Writer
+	progress ArchiveProgress
+	failures []ArchiveFailure
+	lastEmit time.Time
+}
+
+// CreateZipArchive zips multiple files or a directory into a temporary archive.
+// Jobs run side by side, each under its own ID. A job emits "archive-progress"
+// and "archive-error" events carrying its ID and can be aborted with
+// CancelArchive, in which case the partial archive is removed.
+func (c *Core) CreateZipArchive(files []string) (string, string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &archiveJob{core: c, ctx: ctx, cancel: cancel}
+
+	c.ArchiveMutex.Lock()
+	c.nextArchive++
+	job.id = fmt.Sprintf("archive-%d", c.nextArchive)
+	job.progress.ID = job.id
+	if c.archives == nil {
+		c.archives = make(map[string]*archiveJob)
+	}
+	c.archives[job.id] = job
+	c.ArchiveMutex.Unlock()
+
+	defer func() {
+		c.ArchiveMutex.Lock()
+		delete(c.archives, job.id)
+		c.ArchiveMutex.Unlock()
+		cancel()
+	}()
+
+	job.progress.FilesTotal, job.progress.BytesTotal = measureSelection(files)
+
+	tmpZip, err := os.CreateTemp(c.tempDir(), "godrop-*.zip")
+	if err != nil {
+		return "", "", err
+	}
+	job.zw = zip.NewWriter(tmpZip)
+	for _, f := range files {
+		if err = job.add(f, ""); err != nil {
+			break
+		}
+	}
+	if closeErr := job.zw.Close(); err == nil {
+		err = closeErr
+	}
+	tmpZip.Close()
+
+	if err == nil && job.progress.FilesDone == 0 && len(job.failures) > 0 {
+		err = fmt.Errorf("none of the selected files could be archived")
+	}
+	if err != nil {
+		os.Remove(tmpZip.Name())
+		if ctx.Err() != nil {
+			c.emit("archive-cancelled", job.id)
+			return "", "", fmt.Errorf("archive cancelled")
+		}
+		return "", "", err
+	}
+	job.progress.Current = ""
+	job.emit(true)
+
+	name := "godrop-archive.zip"
+	if len(files) == 1 {
+		name = filepath.Base(files[0]) + ".zip"
+	}
+
+	return tmpZip.Name(), name, nil
+}
+
+// ArchiveSelection returns an archive of files, reusing a cached archive when
+// the selection is unchanged since it was last built. cached reports that the
+// archive belongs to the cache and must be handed back with ReleaseArchive.
+func (c *Core) ArchiveSelection(files []string) (path string, name string, cached bool, err error) {
+	name = "godrop-archive.zip"
+	if len(files) == 1 {
+		name = filepath.Base(files[0]) + ".zip"
+	}
+
+	key, keyErr := SelectionKey(files)
+	if keyErr == nil {
+		if path, ok := c.Archives.Acquire(key); ok {
+			if info, err := os.Stat(path); err == nil {
+				c.emit("archive-progress", ArchiveProgress{BytesDone: info.Size(), BytesTotal: info.Size()})
+			}
+			return path, name, true, nil
+		}
+	}
+
+	path, name, err = c.CreateZipArchive(files)
+	if err != nil {
+		return "", "", false, err
+	}
+	// A file that changed while it was being zipped leaves an archive that
+	// matches neither its old nor its new state, so it is served but not kept
+	if keyErr == nil {
+		if after, err := SelectionKey(files); err == nil && after == key {
+			cached = c.Archives.Store(key, path)
+		}
+	}
+	return path, name, cached, nil
+}
+
+// ReleaseArchive hands a cached archive back once it is no longer served
+func (c *Core) ReleaseArchive(path string) {
+	c.Archives.Release(path)
+}
+
+// CancelArchive aborts the running archive job with the given ID
+func (c *Core) CancelArchive(id string) error {
+	c.ArchiveMutex.Lock()
+	defer c.ArchiveMutex.Unlock()
+	job, ok := c.archives[id]
+	if !ok {
+		return fmt.Errorf("no such archive job: %s", id)
+	}
+	job.cancel()
+	return nil
+}
+
+// cancelArchives aborts every running archive job
+func (c *Core) cancelArchives() {
+	c.ArchiveMutex.Lock()
+	defer c.ArchiveMutex.Unlock()
+	for _, job := range c.archives {
+		job.cancel()
+	}
+}
+
+// measureSelection counts the regular files and bytes under the given paths
+func measureSelection(files []string) (int, int64) {
+	var count int
+	var total int64
+	for _, f := range files {
+		filepath.WalkDir(f, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				count++
+				total += info.Size()
+			}
+			return nil
+		})
+	}
+	return count, total
+}
+
+// add is a recursive helper to add files or directories to the job's zip writer.
+// Per-file errors are recorded and skipped; only cancellation stops the walk.
+func (j *archiveJob) add(fullPath string, baseInZip string) error {
+	if err := j.ctx.Err(); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		j.fail(fullPath, err)
+		return nil
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		j.fail(fullPath, err)
+		return nil
+	}
+
+	if baseInZip == "" {
+		header.Name = filepath.Base(fullPath)
+	} else {
+		header.Name = filepath.ToSlash(filepath.Join(baseInZip, filepath.Base(fullPath)))
+	}
+
+	if info.IsDir() {
+		header.Name += "/"
+		if _, err := j.zw.CreateHeader(header); err != nil {
+			j.fail(fullPath, err)
+			return nil
+		}
+		entries, err := os.ReadDir(fullPath)
+		if err != nil {
+			j.fail(fullPath, err)
+			return nil
+		}
+		for _, e := range entries {
+			if err := j.add(filepath.Join(fullPath, e.Name()), header.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		j.fail(fullPath, err)
+		return nil
+	}
+	defer file.Close()
+
+	header.Method = zip.Deflate
+	writer, err := j.zw.CreateHeader(header)
+	if err != nil {
+		j.fail(fullPath, err)
+		return nil
+	}
+
+	j.progress.Current = header.Name
+	j.emit(true)
+
+	if _, err := io.Copy(writer, &archiveReader{job: j, r: file}); err != nil {
+		if j.ctx.Err() != nil {
+			return j.ctx.Err()
+		}
+		j.fail(fullPath, err)
+		return nil
+	}
+	j.progress.FilesDone++
+	return nil
+}
+
+func (j *archiveJob) fail(path string, err error) {
+	log.Printf("Failed to add %s: %v", path, err)
+	failure := ArchiveFailure{ID: j.id, Path: path, Error: err.Error()}
+	j.failures = append(j.failures, failure)
+	j.core.emit("archive-error", failure)
+}
+
+func (j *archiveJob) emit(force bool) {
+	if force || time.Since(j.lastEmit) > 100*time.Millisecond {
+		j.core.emit("archive-progress", j.progress)
+		j.lastEmit = time.Now()
+	}
+}
+
+// archiveReader counts bytes read into the archive and stops on cancellation
+type archiveReader struct {
+	job *archiveJob
+	r   io.Reader
+}
+
+func (ar *archiveReader) Read(p []byte) (int, error) {
+	if err := ar.job.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := ar.r.Read(p)
+	ar.job.progress.BytesDone += int64(n)
+	ar.job.emit(false)
+	return n, err
+}
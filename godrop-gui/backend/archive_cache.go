@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultArchiveCacheSize bounds the disk space used by cached archives
+const DefaultArchiveCacheSize int64 = 2 << 30
+
+// ArchiveCache keeps finished archives around so that re-sharing an unchanged
+// selection can skip the zip step. Entries are keyed on the selected paths and
+// the size and mtime of everything beneath them.
+type ArchiveCache struct {
+	MaxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	total   int64
+}
+
+type cacheEntry struct {
+	key      string
+	path     string
+	size     int64
+	lastUsed time.Time
+	refs     int
+}
+
+// NewArchiveCache creates an empty cache bounded to maxSize bytes
+func NewArchiveCache(maxSize int64) *ArchiveCache {
+	return &ArchiveCache{MaxSize: maxSize, entries: make(map[string]*cacheEntry)}
+}
+
+// SelectionKey fingerprints a selection from its paths, sizes and mtimes
+func SelectionKey(files []string) (string, error) {
+	h := sha256.New()
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		var lines []string
+		err = filepath.WalkDir(abs, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("%s\x00%d\x00%d\x00%v", path, info.Size(), info.ModTime().UnixNano(), d.IsDir()))
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(lines)
+		fmt.Fprintf(h, "%s\n", abs)
+		for _, l := range lines {
+			fmt.Fprintf(h, "%s\n", l)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Acquire returns the cached archive for key and pins it until Release
+func (ac *ArchiveCache) Acquire(key string) (string, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	e, ok := ac.entries[key]
+	if !ok {
+		return "", false
+	}
+	if _, err := os.Stat(e.path); err != nil {
+		ac.drop(e)
+		return "", false
+	}
+	e.refs++
+	e.lastUsed = time.Now()
+	return e.path, true
+}
+
+// Store adds a freshly built archive to the cache and pins it. It reports
+// false when the archive is too large to cache, leaving ownership with the caller.
+func (ac *ArchiveCache) Store(key, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > ac.MaxSize {
+		return false
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if old, ok := ac.entries[key]; ok && old.refs == 0 {
+		ac.drop(old)
+	} else if ok {
+		return false
+	}
+	e := &cacheEntry{key: key, path: path, size: info.Size(), lastUsed: time.Now(), refs: 1}
+	ac.entries[key] = e
+	ac.total += e.size
+	ac.evict()
+	return true
+}
+
+// Release unpins an archive handed out by Acquire or Store
+func (ac *ArchiveCache) Release(path string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	for _, e := range ac.entries {
+		if e.path == path && e.refs > 0 {
+			e.refs--
+		}
+	}
+	ac.evict()
+}
+
+// Clear removes every archive that is not currently being served
+func (ac *ArchiveCache) Clear() {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	for _, e := range ac.entries {
+		if e.refs == 0 {
+			ac.drop(e)
+		}
+	}
+}
+
+// evict removes least recently used, unpinned archives until under MaxSize
+func (ac *ArchiveCache) evict() {
+	for ac.total > ac.MaxSize {
+		var oldest *cacheEntry
+		for _, e := range ac.entries {
+			if e.refs == 0 && (oldest == nil || e.lastUsed.Before(oldest.lastUsed)) {
+				oldest = e
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		ac.drop(oldest)
+	}
+}
+
+func (ac *ArchiveCache) drop(e *cacheEntry) {
+	os.Remove(e.path)
+	delete(ac.entries, e.key)
+	ac.total -= e.size
+}
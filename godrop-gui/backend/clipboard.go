@@ -1,10 +1,10 @@
 package backend
 
 import (
+	"context"
 	"time"
 
 	"github.com/atotto/clipboard"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // GetSystemClipboard returns the current system clipboard text
@@ -48,24 +48,27 @@ func (c *Core) AddToHistory(text string) {
 func (c *Core) GetHistory() []string {
 	c.ClipboardMutex.Lock()
 	defer c.ClipboardMutex.Unlock()
-	return c.ClipboardHistory
+	return append([]string(nil), c.ClipboardHistory...)
 }
 
-// MonitorClipboard watches the system clipboard for changes
-func (c *Core) MonitorClipboard() {
+// MonitorClipboard watches the system clipboard for changes until ctx is done
+func (c *Core) MonitorClipboard(ctx context.Context) {
 	lastText := c.GetSystemClipboard()
 	c.AddToHistory(lastText)
 
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 	for {
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		currentText := c.GetSystemClipboard()
 		if currentText != "" && currentText != lastText {
 			lastText = currentText
 			c.AddToHistory(currentText)
-			// Emit event to frontend if Ctx is available
-			if c.Ctx != nil {
-				runtime.EventsEmit(c.Ctx, "clipboard-changed", currentText)
-			}
+			c.emit("clipboard-changed", currentText)
 		}
 	}
 }
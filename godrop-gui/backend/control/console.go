@@ -0,0 +1,256 @@
+package control
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"godrop-gui/backend"
+	"godrop-gui/backend/server"
+)
+
+// DefaultConsoleAddr keeps the admin console reachable from this machine only
+const DefaultConsoleAddr = "127.0.0.1:7070"
+
+// Console serves the control API and admin console on its own listener
+type Console struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+
+	server *http.Server
+}
+
+// StartConsole listens on addr and serves the console for shares. An empty
+// token is replaced with a random one, since the console always requires login.
+func StartConsole(addr string, shares *server.Manager, hub *backend.Hub, token string) (*Console, error) {
+	if addr == "" {
+		addr = DefaultConsoleAddr
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return ServeConsole(ln, shares, hub, token), nil
+}
+
+// ServeConsole serves the console on a listener that is already open, such
+// as one handed over by systemd socket activation
+func ServeConsole(ln net.Listener, shares *server.Manager, hub *backend.Hub, token string) *Console {
+	if token == "" {
+		token = GenerateToken()
+	}
+
+	c := &Console{
+		URL:    "http://" + ln.Addr().String(),
+		Token:  token,
+		server: &http.Server{Handler: NewHandler(shares, hub, token)},
+	}
+	go c.server.Serve(ln)
+	return c
+}
+
+// Stop closes the console, ending any open event streams
+func (c *Console) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.server.Shutdown(ctx); err != nil {
+		c.server.Close()
+	}
+}
+
+const consoleHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>Godrop - Admin Console</title>
+<style>
+:root { --bg: #F7F4F3; --card: #FFFFFF; --accent: #52a1d8; --accent-bright: #FF5A5F; --text: #191610; --muted: #6B6B6B; }
+* { box-sizing: border-box; margin: 0; padding: 0; }
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; background: var(--bg); color: var(--text); padding: 30px; }
+h1 { font-size: 1.5rem; font-weight: 800; letter-spacing: 2px; margin-bottom: 20px; }
+.card { background: var(--card); border: 3px solid var(--text); border-radius: 20px; box-shadow: 8px 8px 0 var(--text); padding: 20px; margin-bottom: 25px; }
+.row { display: flex; justify-content: space-between; align-items: center; gap: 10px; flex-wrap: wrap; }
+.badge { font-size: 0.7rem; font-weight: 800; background: var(--accent); color: white; padding: 3px 8px; border-radius: 6px; }
+.muted { color: var(--muted); font-size: 0.8rem; }
+code { font-family: monospace; font-weight: 700; word-break: break-all; }
+button { padding: 8px 12px; background: var(--text); color: white; border: none; border-radius: 8px; font-weight: 700; cursor: pointer; }
+button:hover { background: var(--accent); }
+button.stop { background: var(--accent-bright); }
+input { padding: 8px; border: 2px solid var(--text); border-radius: 8px; font-family: monospace; width: 80px; }
+#login input { width: 260px; }
+table { width: 100%; border-collapse: collapse; margin-top: 12px; font-size: 0.8rem; }
+th, td { text-align: left; padding: 4px 6px; border-bottom: 1px solid #ddd; }
+.bar { height: 10px; border: 2px solid var(--text); border-radius: 5px; overflow: hidden; margin-top: 10px; }
+.bar div { height: 100%; background: var(--accent); width: 0; transition: width 0.3s; }
+</style>
+</head>
+<body>
+<h1>GODROP // ADMIN</h1>
+<div id="login" class="card" style="display:none">
+	<p class="muted" style="margin-bottom:10px">Enter the console token shown by godrop.</p>
+	<input type="password" id="token" placeholder="TOKEN">
+	<button onclick="login()">LOGIN</button>
+	<span id="login-msg" class="muted"></span>
+</div>
+<div id="sessions"></div>
+<div id="devices"></div>
+<div id="blocked"></div>
+<script>
+const progress = {};
+const blocked = []; // Latest access_denied events, newest first
+
+async function api(method, path, body) {
+	const r = await fetch(path, {method, headers: {'Content-Type': 'application/json'}, body: body ? JSON.stringify(body) : undefined});
+	if (r.status === 401) { showLogin(); throw new Error('unauthorized'); }
+	if (!r.ok) { const j = await r.json(); throw new Error(j.error); }
+	return r.status === 204 ? null : r.json();
+}
+
+function showLogin() { document.getElementById('login').style.display = 'block'; }
+
+async function login() {
+	const token = document.getElementById('token').value;
+	const r = await fetch('/api/login', {method: 'POST', body: JSON.stringify({Token: token})});
+	if (!r.ok) { document.getElementById('login-msg').innerText = 'ACCESS DENIED'; return; }
+	document.getElementById('login').style.display = 'none';
+	start();
+}
+
+function esc(s) { const d = document.createElement('div'); d.innerText = s; return d.innerHTML; }
+
+function fmtTime(unix) { return unix ? new Date(unix * 1000).toLocaleTimeString() : '∞'; }
+
+function render(list) {
+	const root = document.getElementById('sessions');
+	if (list.length === 0) { root.innerHTML = '<p class="muted">No active shares.</p>'; return; }
+	root.innerHTML = list.map(s => {
+		const p = progress[s.id];
+		const clients = (s.clients || []).map(c =>
+			'<tr><td>' + (c.device ? '📱 ' + esc(c.device) + ' ' : '') + '<code>' + esc(c.addr) + '</code></td><td>' + (c.active > 0 ? 'ACTIVE' : 'IDLE') + '</td><td>' + c.transfers + '</td><td>' + (c.transferred / 1048576).toFixed(1) + ' MB</td><td>' + fmtTime(c.lastSeen) + '</td></tr>').join('');
+		return '<div class="card">' +
+			'<div class="row"><div><span class="badge">' + esc(s.mode.toUpperCase()) + '</span> <b>' + esc(s.label) + '</b> <span class="muted">' + esc(s.id) + '</span></div>' +
+			'<button class="stop" onclick="stopShare(\'' + s.id + '\')">STOP</button></div>' +
+			'<p style="margin-top:8px"><code>' + esc(s.fullUrl) + '</code></p>' +
+			'<div class="row muted" style="margin-top:8px">' +
+			'<span>Downloads: ' + s.downloads + ' / ' + (s.downloadLimit > 0 ? s.downloadLimit : '∞') + '</span>' +
+			'<span>Expires: ' + fmtTime(s.expiryTime) + '</span>' +
+			(s.mode === 'send' ? '<span>Per client: ' + (s.perClient || '∞') + ' by ' + esc(s.perClientBy) + ' • At once: ' + (s.maxConcurrent || '∞') + (s.queued ? ' (' + s.queued + ' queued)' : '') + ' • KiB/s: ' + (s.rateLimit || '∞') + ' <button onclick="setFairUse(\'' + s.id + '\')">FAIR USE</button></span>' : '') +
+			'<span>' + (s.paused ? 'PAUSED' : 'LIVE') + (s.hasPassword ? ' • LOCKED' : '') + '</span>' +
+			'<span><button onclick="extend(\'' + s.id + '\', -10)">-10 MIN</button> <button onclick="extend(\'' + s.id + '\', 10)">+10 MIN</button> ' +
+			'<button onclick="togglePause(\'' + s.id + '\', ' + s.paused + ')">' + (s.paused ? 'RESUME' : 'PAUSE') + '</button> ' +
+			'<button onclick="setPassword(\'' + s.id + '\')">PASSWORD</button> ' +
+			(s.fingerprint ? '<button onclick="pair(\'' + s.id + '\')">PAIR DEVICE</button> ' : '') +
+			'<input type="number" min="0" id="limit-' + s.id + '" value="' + s.downloadLimit + '"> <button onclick="setLimit(\'' + s.id + '\')">SET LIMIT</button></span>' +
+			'</div>' +
+			'<div class="row muted" style="margin-top:8px"><span>Allow: <code>' + esc((s.allow || []).join(', ') || 'anyone') + '</code> • Deny: <code>' + esc((s.deny || []).join(', ') || 'nobody') + '</code> • Clipboard: <code>' + esc(s.clipboard || 'off') + '</code></span>' +
+			'<span><button onclick="setClipboard(\'' + s.id + '\', \'' + esc(s.clipboard) + '\')">CLIPBOARD</button> <button onclick="setAccess(\'' + s.id + '\')">ACCESS</button></span></div>' +
+			'<div class="bar" id="bar-' + s.id + '"' + (p ? '' : ' style="display:none"') + '><div style="width:' + (p ? p.percent : 0) + '%"></div></div>' +
+			(clients ? '<table><tr><th>CLIENT</th><th>STATE</th><th>TRANSFERS</th><th>BYTES</th><th>LAST SEEN</th></tr>' + clients + '</table>' : '') +
+			'</div>';
+	}).join('');
+}
+
+function renderDevices(list) {
+	document.getElementById('devices').innerHTML = list.length === 0 ? '' : '<div class="card"><b>PAIRED DEVICES</b><table><tr><th>DEVICE</th><th>PAIRED</th><th>LAST SEEN</th><th></th></tr>' +
+		list.map(d => '<tr><td>📱 ' + esc(d.name) + '</td><td>' + new Date(d.pairedAt * 1000).toLocaleDateString() + '</td><td>' + fmtTime(d.lastSeen) +
+			'</td><td><button class="stop" onclick="revoke(\'' + d.id + '\')">REVOKE</button></td></tr>').join('') + '</table></div>';
+}
+
+function renderBlocked() {
+	document.getElementById('blocked').innerHTML = blocked.length === 0 ? '' : '<div class="card"><b>BLOCKED CLIENTS</b><table><tr><th>CLIENT</th><th>SHARE</th><th>PATH</th><th>TIME</th></tr>' +
+		blocked.map(b => '<tr><td><code>' + esc(b.ip) + '</code></td><td>' + esc(b.session) + '</td><td><code>' + esc(b.path) + '</code></td><td>' + b.time.toLocaleTimeString() + '</td></tr>').join('') + '</table></div>';
+}
+
+// showProgress moves a share's progress bar in place; progress events come
+// too often to reload the whole list for each
+function showProgress(d) {
+	progress[d.session] = d;
+	const bar = document.getElementById('bar-' + d.session);
+	if (!bar) return;
+	bar.style.display = '';
+	bar.firstChild.style.width = d.percent + '%';
+}
+
+async function refresh() {
+	try {
+		render(await api('GET', '/api/sessions'));
+		renderDevices(await api('GET', '/api/devices'));
+	} catch (e) {}
+}
+
+async function stopShare(id) { await api('DELETE', '/api/sessions/' + id); refresh(); }
+async function extend(id, minutes) { await api('POST', '/api/sessions/' + id + '/extend', {minutes}); refresh(); }
+async function togglePause(id, paused) { await api('POST', '/api/sessions/' + id + (paused ? '/resume' : '/pause')); refresh(); }
+async function setPassword(id) {
+	const password = prompt('New password (leave empty to remove):');
+	if (password === null) return;
+	await api('POST', '/api/sessions/' + id + '/password', {password});
+	refresh();
+}
+async function pair(id) {
+	try {
+		const link = await api('POST', '/api/sessions/' + id + '/pair');
+		prompt('Open this link on the device within 5 minutes:', link.url);
+	} catch (e) { alert(e.message); }
+}
+async function revoke(id) {
+	if (!confirm('Revoke this device? It will have to pair again.')) return;
+	await api('DELETE', '/api/devices/' + id);
+	refresh();
+}
+async function setAccess(id) {
+	const s = (await api('GET', '/api/sessions')).find(s => s.id === id);
+	if (!s) return;
+	const allow = prompt('Allow (comma separated IPs, CIDR blocks, interfaces, subnet, localhost or any; empty allows anyone):', (s.allow || []).join(', '));
+	if (allow === null) return;
+	const deny = prompt('Deny (comma separated):', (s.deny || []).join(', '));
+	if (deny === null) return;
+	const split = text => text.split(',').map(r => r.trim()).filter(Boolean);
+	try { await api('POST', '/api/sessions/' + id + '/access', {allow: split(allow), deny: split(deny)}); } catch (e) { alert(e.message); }
+	refresh();
+}
+async function setFairUse(id) {
+	const s = (await api('GET', '/api/sessions')).find(s => s.id === id);
+	if (!s) return;
+	const ask = (label, value) => { const a = prompt(label + ' (0 = no limit):', value); return a === null ? null : parseInt(a) || 0; };
+	const perClient = ask('Downloads per client', s.perClient);
+	if (perClient === null) return;
+	const perClientBy = prompt('Tell clients apart by ip or token (paired devices always by device):', s.perClientBy);
+	if (perClientBy === null) return;
+	const maxConcurrent = ask('Downloads at once', s.maxConcurrent);
+	if (maxConcurrent === null) return;
+	const rateLimit = ask('KiB/s per download', s.rateLimit);
+	if (rateLimit === null) return;
+	try { await api('POST', '/api/sessions/' + id + '/fairuse', {perClient, perClientBy, maxConcurrent, rateLimit}); } catch (e) { alert(e.message); }
+	refresh();
+}
+async function setClipboard(id, current) {
+	const clipboard = prompt('Clipboard access: off, read or readwrite (needs a password or paired devices):', current || 'off');
+	if (clipboard === null) return;
+	try { await api('POST', '/api/sessions/' + id + '/clipboard', {clipboard}); } catch (e) { alert(e.message); }
+	refresh();
+}
+async function setLimit(id) {
+	const limit = parseInt(document.getElementById('limit-' + id).value) || 0;
+	try { await api('POST', '/api/sessions/' + id + '/limit', {limit}); } catch (e) { alert(e.message); }
+	refresh();
+}
+
+let started = false;
+function start() {
+	if (started) return;
+	started = true;
+	refresh();
+	setInterval(refresh, 3000);
+	const es = new EventSource('/api/events');
+	es.addEventListener('transfer-progress', e => showProgress(JSON.parse(e.data)));
+	es.addEventListener('access_denied', e => { blocked.unshift({...JSON.parse(e.data), time: new Date()}); blocked.splice(20); renderBlocked(); });
+	['download_started', 'file-received', 'server_stopped', 'session_updated', 'device_paired', 'device_revoked'].forEach(name => es.addEventListener(name, refresh));
+}
+
+fetch('/api/sessions').then(r => r.status === 401 ? showLogin() : start());
+</script>
+</body>
+</html>`
@@ -0,0 +1,469 @@
+package control
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"godrop-common/discovery"
+	"godrop-common/netaccess"
+	"godrop-gui/backend"
+	"godrop-gui/backend/server"
+)
+
+// ShareRequest describes a share to start, as sent to the control API or
+// listed in a headless config file
+type ShareRequest struct {
+	Mode     string   `json:"mode"` // "send", "receive" or "clipboard"
+	Port     string   `json:"port"`
+	Password string   `json:"password"`
+	Files    []string `json:"files"`
+	Limit    int      `json:"limit"`
+	Timeout  int      `json:"timeout"` // Minutes, 0 for no expiry
+	SaveDir  string   `json:"saveDir"`
+	Extract  bool     `json:"extract"`
+	// Encrypt received files for a passphrase or a public key (godrop-pub-...)
+	EncryptTo string `json:"encryptTo"`
+	// Access rules replacing the defaults when given, see netaccess.List
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// Clipboard access: "read" or "readwrite" (the default for clipboard
+	// shares), off when empty
+	Clipboard string `json:"clipboard"`
+	// Per-client, concurrency and bandwidth limits of a send share
+	server.FairUse
+}
+
+// Start launches the share described by req on shares
+func Start(shares *server.Manager, req ShareRequest) (server.SessionInfo, error) {
+	if req.Allow != nil || req.Deny != nil {
+		// Checked up front so a bad rule doesn't leave a share running
+		if _, err := netaccess.New(req.Allow, req.Deny); err != nil {
+			return server.SessionInfo{}, err
+		}
+	}
+	if _, err := server.ParseClipboardAccess(req.Clipboard); err != nil {
+		return server.SessionInfo{}, err
+	}
+	limited := req.FairUse != server.FairUse{}
+	if limited && req.Mode != "send" {
+		return server.SessionInfo{}, fmt.Errorf("only send shares have download limits")
+	}
+
+	var info server.SessionInfo
+	var err error
+	switch req.Mode {
+	case "send":
+		info, err = shares.Send(req.Port, req.Password, req.Files, req.Limit, req.Timeout)
+	case "receive":
+		info, err = shares.StartReceive(req.Port, req.SaveDir, req.Extract, req.EncryptTo)
+	case "clipboard":
+		info, err = shares.StartClipboard(req.Port, req.Password, req.Clipboard)
+	default:
+		err = fmt.Errorf("unknown share mode: %q", req.Mode)
+	}
+	if err != nil {
+		return info, err
+	}
+
+	if limited {
+		updated, err := shares.SetSessionFairUse(info.ID, req.FairUse)
+		if err != nil {
+			shares.Stop(info.ID)
+			return updated, err
+		}
+		info = updated
+	}
+	if req.Clipboard != "" && req.Mode != "clipboard" {
+		updated, err := shares.SetSessionClipboard(info.ID, req.Clipboard)
+		if err != nil {
+			shares.Stop(info.ID)
+			return updated, err
+		}
+		info = updated
+	}
+	if req.Allow == nil && req.Deny == nil {
+		return info, nil
+	}
+	allow := req.Allow
+	if allow == nil {
+		allow = shares.Access().Allow
+	}
+	return shares.SetSessionAccess(info.ID, allow, req.Deny)
+}
+
+// NewHandler exposes shares over a JSON API and serves the admin console.
+// Live events are streamed from hub when it is not nil. When token is set,
+// every API request must carry it as a bearer token or session cookie.
+func NewHandler(shares *server.Manager, hub *backend.Hub, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(consoleHTML))
+	})
+
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Token string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if token != "" && subtle.ConstantTimeCompare([]byte(body.Token), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    body.Token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, shares.List())
+	})
+
+	mux.HandleFunc("POST /api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		var req ShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		info, err := Start(shares, req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, info)
+	})
+
+	mux.HandleFunc("GET /api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := shares.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "No such session")
+			return
+		}
+		writeJSON(w, http.StatusOK, sess.Info())
+	})
+
+	mux.HandleFunc("DELETE /api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := shares.Stop(r.PathValue("id")); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/extend", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Minutes int `json:"minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		info, err := shares.Extend(r.PathValue("id"), body.Minutes)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/limit", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Limit int `json:"limit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		info, err := shares.SetLimit(r.PathValue("id"), body.Limit)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/password", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		info, err := shares.SetPassword(r.PathValue("id"), body.Password)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/pause", func(w http.ResponseWriter, r *http.Request) {
+		info, err := shares.Pause(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		info, err := shares.Resume(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/fairuse", func(w http.ResponseWriter, r *http.Request) {
+		var body server.FairUse
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		info, err := shares.SetSessionFairUse(r.PathValue("id"), body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/clipboard", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Clipboard string `json:"clipboard"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		info, err := shares.SetSessionClipboard(r.PathValue("id"), body.Clipboard)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/access", func(w http.ResponseWriter, r *http.Request) {
+		var body server.AccessRules
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		info, err := shares.SetSessionAccess(r.PathValue("id"), body.Allow, body.Deny)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("POST /api/sessions/{id}/pair", func(w http.ResponseWriter, r *http.Request) {
+		link, err := shares.PairingLink(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, link)
+	})
+
+	mux.HandleFunc("GET /api/devices", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, shares.ListDevices())
+	})
+
+	mux.HandleFunc("DELETE /api/devices/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := shares.RevokeDevice(r.PathValue("id")); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/interfaces", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"selected":   shares.Interface(),
+			"bind":       shares.Bind(),
+			"tls":        shares.TLS(),
+			"access":     shares.Access(),
+			"interfaces": backend.ListInterfaces(),
+		})
+	})
+
+	mux.HandleFunc("POST /api/bind", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Bind string `json:"bind"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		if err := shares.SetBind(body.Bind); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /api/tls", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			TLS bool `json:"tls"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		shares.SetTLS(body.TLS)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /api/access", func(w http.ResponseWriter, r *http.Request) {
+		var body server.AccessRules
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		if err := shares.SetAccess(body.Allow, body.Deny); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /api/interface", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Interface string `json:"interface"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		list, err := shares.SetInterface(body.Interface)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	mux.HandleFunc("GET /api/nearby", func(w http.ResponseWriter, r *http.Request) {
+		found, err := discovery.Browse(r.Context(), 2*time.Second)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, found)
+	})
+
+	mux.HandleFunc("GET /api/events", func(w http.ResponseWriter, r *http.Request) {
+		if hub == nil {
+			writeError(w, http.StatusNotFound, "Events are not available")
+			return
+		}
+		streamEvents(w, r, hub)
+	})
+
+	mux.HandleFunc("POST /api/archive/cancel", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID == "" {
+			writeError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		if err := shares.CancelArchive(body.ID); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if token == "" {
+		return mux
+	}
+	return requireToken(token, mux)
+}
+
+// sessionCookie carries the token for the browser-based admin console
+const sessionCookie = "godrop_admin"
+
+// requireToken rejects API requests that present neither the bearer token nor
+// the console cookie. The console page and login route stay public.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || r.URL.Path == "/api/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if c, err := r.Cookie(sessionCookie); err == nil && got == "" {
+			got = c.Value
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// streamEvents relays hub events to the client as server-sent events
+func streamEvents(w http.ResponseWriter, r *http.Request, hub *backend.Hub) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+	events, unsubscribe := hub.Subscribe(64)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			var payload interface{} = ev.Data
+			if len(ev.Data) == 1 {
+				payload = ev.Data[0]
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// GenerateToken returns a random token for protecting the control API
+func GenerateToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
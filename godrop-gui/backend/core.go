@@ -1,10 +1,9 @@
 package backend
 
 import (
-	"context"
-	"net/http"
 	"sync"
-	"time"
+
+	"godrop-common/workdir"
 )
 
 // FileEntry represents a file in the explorer
@@ -18,18 +17,60 @@ type FileEntry struct {
 
 // Core holds the application state and logic
 type Core struct {
-	Ctx              context.Context
-	Server           *http.Server
-	ServerMutex      sync.Mutex
-	IsTempArchive    bool
-	ArchivePath      string
-	DownloadLimit    int
-	CurrentDownloads int
-	ExpiryTime       time.Time
+	Events           EventSink
 	ClipboardHistory []string
 	ClipboardMutex   sync.Mutex
+	ArchiveMutex     sync.Mutex
+	Archives         *ArchiveCache
+	Work             *workdir.Dir           // Where temporary archives go; the system temp dir when nil
+	archives         map[string]*archiveJob // Running archive jobs by ID, guarded by ArchiveMutex
+	nextArchive      int
+}
+
+// NewCore creates a Core that reports to events, or discards them if nil
+func NewCore(events EventSink) *Core {
+	if events == nil {
+		events = NopSink{}
+	}
+	return &Core{
+		Events:   events,
+		Archives: NewArchiveCache(DefaultArchiveCacheSize),
+	}
+}
+
+// OpenWorkDir sweeps archives left under root by processes that died, then
+// claims a directory there for this process. It returns the swept paths.
+func (c *Core) OpenWorkDir(root string) ([]string, error) {
+	removed, err := workdir.Sweep(root)
+	if err != nil {
+		return removed, err
+	}
+	work, err := workdir.Open(root)
+	if err != nil {
+		return removed, err
+	}
+	c.Work = work
+	return removed, nil
+}
+
+// Shutdown releases resources held for the lifetime of the application
+func (c *Core) Shutdown() {
+	c.cancelArchives()
+	c.Archives.Clear()
+	if c.Work != nil {
+		c.Work.Close()
+	}
+}
+
+// tempDir is where temporary archives are created
+func (c *Core) tempDir() string {
+	if c.Work == nil {
+		return ""
+	}
+	return c.Work.Path
 }
 
-func NewCore() *Core {
-	return &Core{}
+// emit sends an event to the configured sink
+func (c *Core) emit(name string, data ...interface{}) {
+	c.Events.Emit(name, data...)
 }
@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"godrop-common/atrest"
+)
+
+// DecryptFile opens a file encrypted at rest with secret, a passphrase or a
+// secret key (godrop-key-...), and writes the plain file next to it. It
+// returns the path of the decrypted file.
+func DecryptFile(path string, secret string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	base := filepath.Base(path)
+	plain, err := atrest.Decrypt(in, secret)
+	if errors.Is(err, atrest.ErrNeedsKey) {
+		return "", fmt.Errorf("%s was encrypted for a key pair; give the secret key (godrop-key-...)", base)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", base, err)
+	}
+
+	out, err := createUnique(filepath.Dir(path), strings.TrimSuffix(base, atrest.Ext))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, plain)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
@@ -0,0 +1,296 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"godrop-common/ratelimit"
+)
+
+// Pairing gives a phone or computer a lasting identity. The device makes an
+// ECDSA P-256 key pair when it opens a pairing link, keeps the private key in
+// the browser and hands over the public key, which is saved here. Later it
+// proves who it is by signing a challenge, and gets a login token as a cookie
+// that names it on every share of this host until the app exits or the
+// device stays away for loginTTL.
+
+const (
+	pairingTTL          = 5 * time.Minute // How long a pairing link can be used
+	challengeTTL        = time.Minute
+	challengesPerMinute = 10             // Challenges a single address may ask for
+	loginTTL            = 24 * time.Hour // Logins unused for this long end
+	maxPending          = 256            // Unused pairing tokens and challenges kept at most
+)
+
+// errBusy is returned when too many pairing tokens or challenges are pending
+var errBusy = errors.New("too many requests, try again in a minute")
+
+// Device is a paired device as saved on disk
+type Device struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	PublicKey []byte `json:"publicKey"` // PKIX form, as WebCrypto exports it
+	PairedAt  int64  `json:"pairedAt"`
+	LastSeen  int64  `json:"lastSeen"` // Last pairing or login, Unix seconds
+}
+
+// Devices is the list of paired devices, kept in a JSON file
+type Devices struct {
+	path string
+
+	mu            sync.Mutex
+	devices       map[string]*Device
+	pairings      map[string]time.Time // One-time pairing tokens and when they expire
+	challenges    map[string]time.Time
+	challengeRate *ratelimit.Limiter
+	logins        map[string]*deviceLogin // By login token
+}
+
+// deviceLogin is a device's login, kept alive by use
+type deviceLogin struct {
+	device   string
+	lastUsed time.Time
+}
+
+// LoadDevices reads the paired devices from path. A missing file is an empty
+// list.
+func LoadDevices(path string) (*Devices, error) {
+	d := &Devices{
+		path:       path,
+		devices:    make(map[string]*Device),
+		pairings:   make(map[string]time.Time),
+		challenges: make(map[string]time.Time),
+		logins:     make(map[string]*deviceLogin),
+
+		challengeRate: ratelimit.New(challengesPerMinute, time.Minute),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Device
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid device list %s: %w", path, err)
+	}
+	for _, dev := range list {
+		d.devices[dev.ID] = dev
+	}
+	return d, nil
+}
+
+// List returns the paired devices, oldest first
+func (d *Devices) List() []Device {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]Device, 0, len(d.devices))
+	for _, dev := range d.devices {
+		list = append(list, *dev)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PairedAt < list[j].PairedAt })
+	return list
+}
+
+// NewPairingToken returns a token for one pairing link
+func (d *Devices) NewPairingToken() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return issue(d.pairings, pairingTTL)
+}
+
+// Pair adds the device that opened the pairing link for token and logs it
+// in, returning the login token
+func (d *Devices) Pair(token string, name string, publicKey []byte) (Device, string, error) {
+	if _, err := parseDeviceKey(publicKey); err != nil {
+		return Device{}, "", err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return Device{}, "", fmt.Errorf("the device name must be 1 to 64 characters")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !redeem(d.pairings, token) {
+		return Device{}, "", fmt.Errorf("the pairing link has expired or was already used")
+	}
+	now := time.Now().Unix()
+	dev := &Device{ID: randomHex(8), Name: name, PublicKey: publicKey, PairedAt: now, LastSeen: now}
+	d.devices[dev.ID] = dev
+	if err := d.save(); err != nil {
+		delete(d.devices, dev.ID)
+		return Device{}, "", err
+	}
+	return *dev, d.newLogin(dev.ID), nil
+}
+
+// Challenge returns a one-time challenge for the device at ip to sign. The
+// endpoint asking for them is open to anyone, so each address gets only a
+// few a minute.
+func (d *Devices) Challenge(ip net.IP) (string, error) {
+	if !d.challengeRate.Add(ip) {
+		return "", errBusy
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return issue(d.challenges, challengeTTL)
+}
+
+// Login checks the device's signature over a challenge and returns a login
+// token for it. The signature is WebCrypto's: r and s, 32 bytes each, over
+// SHA-256 of "godrop login " followed by the challenge.
+func (d *Devices) Login(id string, challenge string, signature []byte) (Device, string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !redeem(d.challenges, challenge) {
+		return Device{}, "", fmt.Errorf("the challenge has expired")
+	}
+	dev, ok := d.devices[id]
+	if !ok {
+		return Device{}, "", fmt.Errorf("unknown device")
+	}
+	key, err := parseDeviceKey(dev.PublicKey)
+	if err != nil {
+		return Device{}, "", err
+	}
+	hash := sha256.Sum256([]byte("godrop login " + challenge))
+	if len(signature) != 64 || !ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return Device{}, "", fmt.Errorf("invalid signature")
+	}
+
+	dev.LastSeen = time.Now().Unix()
+	d.save() // Only LastSeen changed; not worth failing the login over
+	return *dev, d.newLogin(dev.ID), nil
+}
+
+// newLogin returns a new login token for the device, dropping logins that
+// went unused for too long. Callers must hold d.mu.
+func (d *Devices) newLogin(id string) string {
+	now := time.Now()
+	for token, l := range d.logins {
+		if now.Sub(l.lastUsed) > loginTTL {
+			delete(d.logins, token)
+		}
+	}
+	token := randomHex(16)
+	d.logins[token] = &deviceLogin{device: id, lastUsed: now}
+	return token
+}
+
+// Lookup returns the device a login token belongs to
+func (d *Devices) Lookup(login string) (Device, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.logins[login]
+	if !ok {
+		return Device{}, false
+	}
+	if time.Since(l.lastUsed) > loginTTL {
+		delete(d.logins, login)
+		return Device{}, false
+	}
+	dev, ok := d.devices[l.device]
+	if !ok {
+		return Device{}, false
+	}
+	l.lastUsed = time.Now()
+	return *dev, true
+}
+
+// Revoke forgets a device and ends its logins. It has to pair again to be
+// recognised.
+func (d *Devices) Revoke(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dev, ok := d.devices[id]
+	if !ok {
+		return fmt.Errorf("no such device: %s", id)
+	}
+	delete(d.devices, id)
+	if err := d.save(); err != nil {
+		d.devices[id] = dev
+		return err
+	}
+	for token, l := range d.logins {
+		if l.device == id {
+			delete(d.logins, token)
+		}
+	}
+	return nil
+}
+
+// save writes the device list atomically. Callers must hold d.mu.
+func (d *Devices) save() error {
+	list := make([]*Device, 0, len(d.devices))
+	for _, dev := range d.devices {
+		list = append(list, dev)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PairedAt < list[j].PairedAt })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+// issue adds a random token to pending that expires after ttl, dropping
+// expired ones first. Tokens still valid are never dropped to make room.
+func issue(pending map[string]time.Time, ttl time.Duration) (string, error) {
+	now := time.Now()
+	for token, expiry := range pending {
+		if now.After(expiry) {
+			delete(pending, token)
+		}
+	}
+	if len(pending) >= maxPending {
+		return "", errBusy
+	}
+	token := randomHex(16)
+	pending[token] = now.Add(ttl)
+	return token, nil
+}
+
+// redeem removes token from pending, reporting whether it was still valid
+func redeem(pending map[string]time.Time, token string) bool {
+	expiry, ok := pending[token]
+	delete(pending, token)
+	return ok && time.Now().Before(expiry)
+}
+
+func parseDeviceKey(der []byte) (*ecdsa.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if key, ok := pub.(*ecdsa.PublicKey); err == nil && ok && key.Curve == elliptic.P256() {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the device key must be an ECDSA P-256 public key")
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// EventSink receives the events the backend raises for its frontend, so the
+// same code can drive the Wails window, a headless daemon or a test
+type EventSink interface {
+	Emit(name string, data ...interface{})
+}
+
+// Event is a single emitted event as delivered by ChannelSink
+type Event struct {
+	Name string
+	Data []interface{}
+}
+
+// WailsSink forwards events to the Wails frontend
+type WailsSink struct {
+	Ctx context.Context
+}
+
+func (s WailsSink) Emit(name string, data ...interface{}) {
+	wailsRuntime.EventsEmit(s.Ctx, name, data...)
+}
+
+// ChannelSink delivers events on C. Events are dropped rather than blocking
+// the backend when nobody is reading fast enough.
+type ChannelSink struct {
+	C chan Event
+}
+
+// NewChannelSink creates a ChannelSink buffering up to size events
+func NewChannelSink(size int) *ChannelSink {
+	return &ChannelSink{C: make(chan Event, size)}
+}
+
+func (s *ChannelSink) Emit(name string, data ...interface{}) {
+	select {
+	case s.C <- Event{Name: name, Data: data}:
+	default:
+	}
+}
+
+// LogSink writes events to a logger, one JSON line per event
+type LogSink struct {
+	Logger *log.Logger
+}
+
+func (s LogSink) Emit(name string, data ...interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		payload = []byte(err.Error())
+	}
+	if s.Logger != nil {
+		s.Logger.Printf("%s %s", name, payload)
+	} else {
+		log.Printf("%s %s", name, payload)
+	}
+}
+
+// NopSink discards every event
+type NopSink struct{}
+
+func (NopSink) Emit(string, ...interface{}) {}
+
+// MultiSink emits every event to each of its sinks in order
+type MultiSink []EventSink
+
+func (s MultiSink) Emit(name string, data ...interface{}) {
+	for _, sink := range s {
+		sink.Emit(name, data...)
+	}
+}
+
+// Hub fans events out to any number of subscribers, such as admin console
+// clients. Slow subscribers miss events instead of stalling the backend.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewHub creates a hub with no subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{})}
+}
+
+func (h *Hub) Emit(name string, data ...interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- Event{Name: name, Data: data}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of future events and a func that ends the subscription
+func (h *Hub) Subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-
-	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // GetHomeDir returns the user's home directory
@@ -78,14 +76,3 @@ func (c *Core) GetDefaultSaveDir() string {
 	}
 	return filepath.Join(home, "Downloads")
 }
-
-// SelectDirectory opens a dialog to select a directory
-func (c *Core) SelectDirectory() (string, error) {
-	selection, err := wailsRuntime.OpenDirectoryDialog(c.Ctx, wailsRuntime.OpenDialogOptions{
-		Title: "Select Save Location",
-	})
-	if err != nil {
-		return "", err
-	}
-	return selection, nil
-}
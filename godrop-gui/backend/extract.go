@@ -0,0 +1,214 @@
+package backend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxExtractRatio caps how much larger extracted content may be than the
+// archive itself, which stops zip bombs from filling the disk
+const MaxExtractRatio = 100
+
+// minExtractBudget lets tiny archives of highly compressible text through
+const minExtractBudget = 64 << 20
+
+// ExtractResult lists what was unpacked from a received archive
+type ExtractResult struct {
+	Archive string   `json:"archive"`
+	Dir     string   `json:"dir"`
+	Files   []string `json:"files"`
+}
+
+// IsExtractable reports whether name looks like an archive we can unpack
+func IsExtractable(name string) bool {
+	return archiveBase(name) != name
+}
+
+// archiveBase strips a known archive extension from name
+func archiveBase(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// ExtractArchive unpacks a zip or tar archive into a new folder named after
+// it. Entries that would land outside that folder are rejected, as are
+// archives whose content exceeds MaxExtractRatio times their own size.
+func ExtractArchive(archivePath string) (ExtractResult, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+
+	budget := info.Size() * MaxExtractRatio
+	if budget < minExtractBudget {
+		budget = minExtractBudget
+	}
+
+	dir := uniqueDir(filepath.Join(filepath.Dir(archivePath), archiveBase(filepath.Base(archivePath))))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ExtractResult{}, err
+	}
+
+	x := &extractor{root: dir, budget: budget}
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		err = x.zip(archivePath)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		err = x.tar(archivePath, true)
+	case strings.HasSuffix(lower, ".tar"):
+		err = x.tar(archivePath, false)
+	default:
+		err = fmt.Errorf("unsupported archive format")
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return ExtractResult{}, err
+	}
+
+	return ExtractResult{Archive: archivePath, Dir: dir, Files: x.files}, nil
+}
+
+// uniqueDir appends a counter to path until it does not exist yet
+func uniqueDir(path string) string {
+	candidate := path
+	for i := 1; ; i++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)", path, i)
+	}
+}
+
+type extractor struct {
+	root    string
+	budget  int64
+	written int64
+	files   []string
+}
+
+// target resolves an entry name inside root, rejecting zip-slip paths
+func (x *extractor) target(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("illegal absolute path in archive: %s", name)
+	}
+	dst := filepath.Join(x.root, filepath.FromSlash(name))
+	rel, err := filepath.Rel(x.root, dst)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	return dst, nil
+}
+
+func (x *extractor) zip(path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		dst, err := x.target(f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue // Symlinks and devices are never recreated
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = x.write(dst, rc, f.Name)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tar(path string, gzipped bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dst, err := x.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := x.write(dst, tr, hdr.Name); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// write copies one entry to disk while enforcing the decompression budget
+func (x *extractor) write(dst string, r io.Reader, name string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	remaining := x.budget - x.written
+	n, err := io.Copy(out, io.LimitReader(r, remaining+1))
+	x.written += n
+	if err != nil {
+		return err
+	}
+	if n > remaining {
+		return fmt.Errorf("archive exceeds the %dx decompression limit", MaxExtractRatio)
+	}
+	x.files = append(x.files, filepath.ToSlash(name))
+	return nil
+}
@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// entry is one member of a crafted archive
+type entry struct {
+	name string
+	body string
+	kind byte // A tar type flag; zip archives know TypeReg, TypeDir and TypeSymlink
+	link string
+}
+
+func file(name, body string) entry { return entry{name: name, body: body, kind: tar.TypeReg} }
+
+func writeZip(t *testing.T, path string, entries []entry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		switch e.kind {
+		case tar.TypeDir:
+			hdr.SetMode(os.ModeDir | 0755)
+		case tar.TypeSymlink:
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTar(t *testing.T, path string, entries []entry) {
+	t.Helper()
+	var buf bytes.Buffer
+	var tw *tar.Writer
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.kind, Linkname: e.link, Mode: 0644}
+		if e.kind == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []entry
+		tarOnly bool     // Zip has no such entries
+		budget  int64    // Decompression budget, 1 MiB when 0
+		files   []string // Extracted files, when it succeeds
+		err     string   // Part of the error, when it fails
+	}{
+		{
+			name:    "plain",
+			entries: []entry{{name: "docs/", kind: tar.TypeDir}, file("docs/a.txt", "alpha"), file("b.txt", "beta")},
+			files:   []string{"docs/a.txt", "b.txt"},
+		},
+		{name: "parent directory", entries: []entry{file("../evil.txt", "x")}, err: "illegal path"},
+		{name: "nested parent directory", entries: []entry{file("docs/../../evil.txt", "x")}, err: "illegal path"},
+		{name: "backslash parent directory", entries: []entry{file(`..\evil.txt`, "x")}, err: "illegal path"},
+		{name: "absolute path", entries: []entry{file("/etc/evil.txt", "x")}, err: "illegal absolute path"},
+		{name: "absolute backslash path", entries: []entry{file(`\evil.txt`, "x")}, err: "illegal absolute path"},
+		{
+			name:    "symlink",
+			entries: []entry{{name: "passwd", kind: tar.TypeSymlink, link: "/etc/passwd"}, file("ok.txt", "fine")},
+			files:   []string{"ok.txt"},
+		},
+		{
+			name:    "symlink then file through it",
+			entries: []entry{{name: "out", kind: tar.TypeSymlink, link: ".."}, file("out/evil.txt", "x")},
+			files:   []string{"out/evil.txt"}, // A plain folder, since the link is never made
+		},
+		{
+			name:    "hardlink",
+			entries: []entry{{name: "hosts", kind: tar.TypeLink, link: "/etc/hosts"}, file("ok.txt", "fine")},
+			tarOnly: true,
+			files:   []string{"ok.txt"},
+		},
+		{
+			name:    "hardlink out of the folder",
+			entries: []entry{{name: "evil", kind: tar.TypeLink, link: "../../evil.txt"}},
+			tarOnly: true,
+		},
+		{name: "duplicate names", entries: []entry{file("a.txt", "one"), file("a.txt", "two")}, err: "exists"},
+		{
+			name:    "oversized",
+			entries: []entry{file("big.bin", strings.Repeat("0", 2048))},
+			budget:  1024,
+			err:     "decompression limit",
+		},
+		{
+			name:    "oversized across entries",
+			entries: []entry{file("a.bin", strings.Repeat("0", 600)), file("b.bin", strings.Repeat("0", 600))},
+			budget:  1024,
+			err:     "decompression limit",
+		},
+	}
+
+	for _, format := range []string{".zip", ".tar", ".tar.gz"} {
+		for _, tc := range cases {
+			if tc.tarOnly && format == ".zip" {
+				continue
+			}
+			t.Run(format+"/"+tc.name, func(t *testing.T) {
+				dir := t.TempDir()
+				archive := filepath.Join(dir, "drop"+format)
+				if format == ".zip" {
+					writeZip(t, archive, tc.entries)
+				} else {
+					writeTar(t, archive, tc.entries)
+				}
+
+				root := filepath.Join(dir, "out")
+				x := &extractor{root: root, budget: tc.budget}
+				if x.budget == 0 {
+					x.budget = 1 << 20
+				}
+				var err error
+				if format == ".zip" {
+					err = x.zip(archive)
+				} else {
+					err = x.tar(archive, format == ".tar.gz")
+				}
+
+				if tc.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.err) {
+						t.Fatalf("got %v, want an error about %q", err, tc.err)
+					}
+				} else {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(x.files, tc.files) {
+						t.Errorf("extracted %v, want %v", x.files, tc.files)
+					}
+				}
+				// Whatever happens, nothing lands next to the folder and no
+				// links or link targets are made
+				if _, err := os.Lstat(filepath.Join(dir, "evil.txt")); err == nil {
+					t.Error("evil.txt was written outside the folder")
+				}
+				filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+					if err == nil && (info.Mode()&os.ModeSymlink != 0 || info.Name() == "hosts" || info.Name() == "passwd") {
+						t.Errorf("%s was created", path)
+					}
+					return nil
+				})
+			})
+		}
+	}
+}
+
+func TestExtractArchiveCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "photos.zip")
+	writeZip(t, good, []entry{file("a.jpg", "jpeg")})
+	bad := filepath.Join(dir, "bad.tar.gz")
+	writeTar(t, bad, []entry{file("a.txt", "fine"), file("../evil.txt", "x")})
+
+	first, err := ExtractArchive(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ExtractArchive(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Dir != filepath.Join(dir, "photos") || second.Dir != filepath.Join(dir, "photos (1)") {
+		t.Errorf("extracted into %s and %s", first.Dir, second.Dir)
+	}
+
+	if _, err := ExtractArchive(bad); err == nil {
+		t.Fatal("an archive with ../ extracted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad")); !os.IsNotExist(err) {
+		t.Error("the rejected archive's folder was left behind")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+		t.Error("the ../ entry was written")
+	}
+}
@@ -0,0 +1,181 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"godrop-common/certs"
+	"godrop-common/discovery"
+)
+
+// nearbyClient returns an HTTP client for a share found with discovery.Browse.
+// When the share needs a code, it is entered first and the access cookie kept
+// for the requests that follow. HTTPS shares must present the certificate
+// they advertised.
+func nearbyClient(ctx context.Context, share discovery.Share, code string) (*http.Client, error) {
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	if share.Fingerprint != "" {
+		client.Transport = &http.Transport{TLSClientConfig: certs.PinnedTLS(share.Fingerprint), ForceAttemptHTTP2: true}
+	}
+	if !share.Code {
+		return client, nil
+	}
+
+	body, _ := json.Marshal(map[string]string{"code": code})
+	req, err := http.NewRequestWithContext(ctx, "POST", share.Endpoint("/api/verify"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct{ Success bool }
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Success {
+		return nil, fmt.Errorf("wrong password")
+	}
+	return client, nil
+}
+
+// DownloadNearby saves the file offered by a nearby send share into dir and
+// returns its path. An existing file is never overwritten.
+func (c *Core) DownloadNearby(ctx context.Context, share discovery.Share, code string, dir string) (string, error) {
+	if share.Mode != "send" || share.Transfer == "" {
+		return "", fmt.Errorf("%s is not offering a file", share.Instance)
+	}
+	client, err := nearbyClient(ctx, share, code)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", share.Endpoint(share.Transfer), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return "", fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+
+	name := share.Name
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	name = filepath.Base(name)
+	if name == "." || name == string(filepath.Separator) {
+		name = "godrop-download"
+	}
+	dst, err := createUnique(dir, name)
+	if err != nil {
+		return "", err
+	}
+
+	pt := &ProgressTracker{Total: resp.ContentLength, EventName: "transfer-progress", Events: c.Events, Writer: dst, Session: "nearby:" + share.Instance}
+	if pt.Total <= 0 {
+		pt.Total = share.Size
+	}
+	_, err = io.Copy(pt, resp.Body)
+	dst.Close()
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// UploadNearby sends files, one request each, to a nearby receive share
+func (c *Core) UploadNearby(ctx context.Context, share discovery.Share, files []string) error {
+	if share.Mode != "receive" || share.Transfer == "" {
+		return fmt.Errorf("%s is not accepting files", share.Instance)
+	}
+	client, err := nearbyClient(ctx, share, "")
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := c.uploadFile(ctx, client, share, path); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+func (c *Core) uploadFile(ctx context.Context, client *http.Client, share discovery.Share, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Stream the multipart body instead of building it in memory
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			pt := &ProgressTracker{Total: info.Size(), EventName: "transfer-progress", Events: c.Events, Reader: f, Session: "nearby:" + share.Instance}
+			_, err = io.Copy(part, pt)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", share.Endpoint(share.Transfer), pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// createUnique creates name in dir, adding " (1)", " (2)"... before the
+// extension when a file of that name already exists
+func createUnique(dir string, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+		}
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// NetInterface is a local address that shares can be advertised on
+type NetInterface struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+	Rank int    `json:"rank"` // Lower is preferred
+}
+
+// virtualPrefixes name interfaces that are rarely reachable from other devices
+// on the LAN: container bridges, VM host adapters and VPN tunnels
+var virtualPrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vmnet", "vboxnet", "vethernet",
+	"tun", "tap", "utun", "wg", "zt", "tailscale", "ppp", "ipsec", "llw", "awdl",
+}
+
+// ListInterfaces returns the addresses of every interface that is up and not a
+// loopback, best candidates first. IPv6 link-local addresses carry their zone
+// (fe80::1%eth0) since they are ambiguous without it.
+func ListInterfaces() []NetInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var list []NetInterface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipnet.IP.String()
+			if ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+				ip += "%" + iface.Name
+			}
+			list = append(list, NetInterface{Name: iface.Name, IP: ip, Rank: rankAddress(iface.Name, ipnet.IP)})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Rank < list[j].Rank })
+	return list
+}
+
+// rankAddress scores how likely an address is to be reachable by a phone on
+// the same network: private LAN ranges on physical interfaces come first
+func rankAddress(name string, ip net.IP) int {
+	rank := 0
+	lower := strings.ToLower(name)
+	for _, p := range virtualPrefixes {
+		if strings.HasPrefix(lower, p) {
+			rank += 100
+			break
+		}
+	}
+	if ip.To4() == nil {
+		rank += 20 // IPv4 URLs are shorter and work with every client
+	}
+	switch {
+	case ip.IsLinkLocalUnicast():
+		rank += 50
+	case ip.IsPrivate():
+	default:
+		rank += 10
+	}
+	return rank
+}
+
+// BindAll, BindLocalhost and an interface name or IP address are the values
+// accepted by BindAddresses
+const (
+	BindAll       = "all"
+	BindLocalhost = "localhost"
+)
+
+// BindAddresses returns the hosts to listen on for bind, "" standing for every
+// interface
+func BindAddresses(bind string) ([]string, error) {
+	switch bind {
+	case "", BindAll:
+		return []string{""}, nil
+	case BindLocalhost:
+		return []string{"127.0.0.1"}, nil
+	}
+	if ip, ok := parseIP(bind); ok {
+		return []string{ip}, nil
+	}
+	var hosts []string
+	for _, iface := range ListInterfaces() {
+		if iface.Name == bind {
+			hosts = append(hosts, iface.IP)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no usable interface named %q", bind)
+	}
+	return hosts, nil
+}
+
+// ReachableAddresses lists the addresses other devices can use to reach a
+// server bound according to bind, best candidates first
+func ReachableAddresses(bind string) []NetInterface {
+	switch bind {
+	case "", BindAll:
+		list := ListInterfaces()
+		if len(list) == 0 {
+			return []NetInterface{{Name: "lo", IP: "127.0.0.1"}}
+		}
+		return list
+	case BindLocalhost:
+		return []NetInterface{{Name: "lo", IP: "127.0.0.1"}}
+	}
+	if ip, ok := parseIP(bind); ok {
+		for _, iface := range ListInterfaces() {
+			if iface.IP == ip {
+				return []NetInterface{iface}
+			}
+		}
+		return []NetInterface{{IP: ip}}
+	}
+	var list []NetInterface
+	for _, iface := range ListInterfaces() {
+		if iface.Name == bind {
+			list = append(list, iface)
+		}
+	}
+	return list
+}
+
+// ShareURL formats a URL for ip, bracketing IPv6 addresses and escaping the
+// zone of link-local ones as URLs require (http://[fe80::1%25eth0]:8080).
+// Given a certificate fingerprint the URL is https and carries it in the
+// fragment, which browsers ignore and `godrop get` pins.
+func ShareURL(ip string, port string, path string, fingerprint string) string {
+	url := "http://" + net.JoinHostPort(strings.Replace(ip, "%", "%25", 1), port) + path
+	if fingerprint != "" {
+		url = "https" + strings.TrimPrefix(url, "http") + "#sha256=" + fingerprint
+	}
+	return url
+}
+
+// ResolveIP picks the address to advertise. pref may be an IP address, which
+// is used as is, or an interface name. An empty pref selects the best ranked
+// interface, falling back to 127.0.0.1 when the machine has no network.
+func ResolveIP(pref string) (string, error) {
+	if pref != "" {
+		if ip, ok := parseIP(pref); ok {
+			return ip, nil
+		}
+		for _, iface := range ListInterfaces() {
+			if iface.Name == pref {
+				return iface.IP, nil
+			}
+		}
+		return "", fmt.Errorf("no usable interface named %q", pref)
+	}
+	if list := ListInterfaces(); len(list) > 0 {
+		return list[0].IP, nil
+	}
+	return "127.0.0.1", nil
+}
+
+// parseIP normalises an IP address, keeping the zone of IPv6 link-local ones
+func parseIP(s string) (string, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return "", false
+	}
+	return addr.String(), true
+}
@@ -1,21 +1,19 @@
 package backend
 
 import (
-	"context"
 	"io"
 	"net/http"
 	"time"
-
-	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
-// ProgressTracker tracks io progress and emits Wails events
+// ProgressTracker tracks io progress and emits events to a sink
 type ProgressTracker struct {
 	Total      int64
 	Current    int64
 	LastEmit   time.Time
 	EventName  string
-	Ctx        context.Context
+	Session    string
+	Events     EventSink
 	Writer     io.Writer
 	Reader     io.Reader
 	IsFinished bool
@@ -36,20 +34,24 @@ func (pt *ProgressTracker) Read(p []byte) (int, error) {
 }
 
 func (pt *ProgressTracker) EmitProgress() {
-	if pt.IsFinished {
+	if pt.IsFinished || pt.Events == nil {
 		return
 	}
 
-	percent := int(float64(pt.Current) / float64(pt.Total) * 100)
+	percent := 0
+	if pt.Total > 0 {
+		percent = int(float64(pt.Current) / float64(pt.Total) * 100)
+	}
 	if percent > 100 {
 		percent = 100
 	}
 
 	if percent == 100 || time.Since(pt.LastEmit) > 100*time.Millisecond {
-		wailsRuntime.EventsEmit(pt.Ctx, pt.EventName, map[string]interface{}{
+		pt.Events.Emit(pt.EventName, map[string]interface{}{
 			"percent":     percent,
 			"transferred": pt.Current,
 			"total":       pt.Total,
+			"session":     pt.Session,
 		})
 		pt.LastEmit = time.Now()
 	}
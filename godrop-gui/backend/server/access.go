@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"godrop-common/netaccess"
+)
+
+// rejectionQuiet is how long a rejected address goes without another
+// access_denied event, so a client retrying or a scan doesn't flood the log
+const rejectionQuiet = time.Minute
+
+// maxRejected bounds the rejected addresses a share remembers
+const maxRejected = 1024
+
+// SetAccess sets the allow and deny rules new shares start with (see
+// netaccess.List). Running shares keep theirs.
+func (m *Manager) SetAccess(allow []string, deny []string) error {
+	allow, deny = netaccess.Clean(allow), netaccess.Clean(deny)
+	if _, err := netaccess.New(allow, deny); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.allow, m.deny = allow, deny
+	m.mu.Unlock()
+	return nil
+}
+
+// Access returns the allow and deny rules new shares start with
+func (m *Manager) Access() AccessRules {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return AccessRules{Allow: m.allow, Deny: m.deny}
+}
+
+// SetSessionAccess replaces the allow and deny rules of a running share
+func (m *Manager) SetSessionAccess(id string, allow []string, deny []string) (SessionInfo, error) {
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+	if err := sess.setAccess(netaccess.Clean(allow), netaccess.Clean(deny)); err != nil {
+		return SessionInfo{}, err
+	}
+	m.persist()
+	m.emit("session_updated", sess.ID)
+	return sess.Info(), nil
+}
+
+// setAccess parses and installs the session's access rules
+func (s *Session) setAccess(allow []string, deny []string) error {
+	access, err := netaccess.New(allow, deny)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allow, s.deny, s.access = allow, deny, access
+	return nil
+}
+
+// admits reports whether the client at ip may use the share, and for a
+// rejected one whether it should be reported
+func (s *Session) admits(ip net.IP) (ok bool, report bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.access == nil || s.access.Admits(ip) {
+		return true, false
+	}
+	key := ip.String()
+	now := time.Now()
+	if last, seen := s.rejected[key]; seen && now.Sub(last) < rejectionQuiet {
+		return false, false
+	}
+	if len(s.rejected) >= maxRejected {
+		for addr, last := range s.rejected {
+			if now.Sub(last) >= rejectionQuiet {
+				delete(s.rejected, addr)
+			}
+		}
+	}
+	s.rejected[key] = now
+	return false, true
+}
+
+// accessGuard turns away clients the share's rules don't admit, before any
+// other handler sees the request
+func (m *Manager) accessGuard(sess *Session, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, report := sess.admits(netaccess.ClientIP(r.RemoteAddr))
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if report {
+			m.emit("access_denied", map[string]string{"ip": r.RemoteAddr, "path": r.URL.Path, "session": sess.ID})
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
@@ -1,48 +1,160 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
-
-	"godrop-gui/backend"
+	"strings"
+)
 
-	"github.com/skip2/go-qrcode"
-	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
+// Clipboard access a share can grant. Send and receive shares start with it
+// off; clipboard shares can't turn it off.
+const (
+	ClipboardOff       = ""
+	ClipboardRead      = "read"      // See the clipboard and its history
+	ClipboardReadWrite = "readwrite" // Also put text on this machine's clipboard
 )
 
-func StartClipboard(core *backend.Core, port string) (ServerResponse, error) {
+// ParseClipboardAccess accepts a clipboard access level or one of its
+// short forms
+func ParseClipboardAccess(level string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "", "off", "none":
+		return ClipboardOff, nil
+	case ClipboardRead, "ro":
+		return ClipboardRead, nil
+	case ClipboardReadWrite, "rw", "write":
+		return ClipboardReadWrite, nil
+	}
+	return "", fmt.Errorf("unknown clipboard access: %q (want off, read or readwrite)", level)
+}
+
+// StartClipboard starts a new share that exposes the clipboard bridge.
+// Clients need the password or a paired device, so without a password the
+// share must use HTTPS with pairing on. access defaults to read-write.
+func (m *Manager) StartClipboard(port string, password string, access string) (SessionInfo, error) {
+	return m.startClipboard(port, password, access, nil)
+}
+
+func (m *Manager) startClipboard(port string, password string, access string, saved *SavedShare) (SessionInfo, error) {
+	access, err := ParseClipboardAccess(access)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	if access == ClipboardOff {
+		access = ClipboardReadWrite
+	}
+	if password == "" && !(m.TLS() && m.pairedDevices() != nil) {
+		return SessionInfo{}, fmt.Errorf("a clipboard share needs a password, or HTTPS for paired devices")
+	}
+	sess, err := m.newSession("clipboard", "Clipboard", saved)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	sess.password = password
+	sess.clipboard = access
+
 	mux := http.NewServeMux()
-	RegisterCommonHandlers(mux, core.GetSystemClipboard, core.SetSystemClipboard, core.GetHistory)
+	m.clipboardRoutes(sess, mux)
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/clipboard", http.StatusSeeOther)
 	})
 
-	ip := backend.GetOutboundIP()
-	prefPort, _ := strconv.Atoi(port)
-	if prefPort == 0 {
-		prefPort = 8080
-	}
-	actualPort, err := backend.FindAvailablePort(prefPort)
-	if err != nil {
-		return ServerResponse{}, err
-	}
-	portStr := strconv.Itoa(actualPort)
-	fullURL := fmt.Sprintf("http://%s:%s/clipboard", ip, portStr)
+	return m.serve(sess, port, "/clipboard", mux)
+}
 
-	png, err := qrcode.Encode(fullURL, qrcode.Medium, 256)
+// SetSessionClipboard changes the clipboard access of a running share. Turning
+// it on needs a password on the share or pairing over HTTPS.
+func (m *Manager) SetSessionClipboard(id string, access string) (SessionInfo, error) {
+	access, err := ParseClipboardAccess(access)
 	if err != nil {
-		return ServerResponse{}, err
+		return SessionInfo{}, err
 	}
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+	info := sess.Info()
+	if access == ClipboardOff && sess.Mode == "clipboard" {
+		return SessionInfo{}, fmt.Errorf("a clipboard share can't turn its clipboard off, stop it instead")
+	}
+	if access != ClipboardOff && !info.HasPassword && !(sess.cert != nil && m.pairedDevices() != nil) {
+		return SessionInfo{}, fmt.Errorf("set a password first, or use HTTPS with pairing, to share the clipboard")
+	}
+	sess.mu.Lock()
+	sess.clipboard = access
+	sess.mu.Unlock()
+	m.persist()
+	m.emit("session_updated", sess.ID)
+	return sess.Info(), nil
+}
+
+// clipboardRoutes mounts the clipboard page and API on mux. They answer 404
+// while the share's clipboard access is off, and only serve clients that
+// entered the share's password or are paired devices.
+func (m *Manager) clipboardRoutes(sess *Session, mux *http.ServeMux) {
+	core := m.core
+
+	mux.HandleFunc("GET /clipboard", func(w http.ResponseWriter, r *http.Request) {
+		access := sess.clipboardAccess()
+		if access == ClipboardOff {
+			http.NotFound(w, r)
+			return
+		}
+		dev, paired := m.deviceOf(r)
+		if !paired && !sess.unlocked(r) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(GetClipboardLockedTemplate(sess.Info().HasPassword)))
+			return
+		}
+		w.Write([]byte(GetClipboardTemplate(core.GetHistory(), access == ClipboardReadWrite, dev.Name)))
+	})
 
-	core.Server = &http.Server{Addr: ":" + portStr, Handler: mux}
-	go func() {
-		if err := core.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			wailsRuntime.EventsEmit(core.Ctx, "server_error", err.Error())
+	mux.HandleFunc("POST /clipboard/unlock", sess.unlockHandler(func() bool {
+		return sess.clipboardAccess() != ClipboardOff && sess.Info().HasPassword
+	}))
+
+	// API: Get Clipboard Data (Polling)
+	mux.HandleFunc("GET /clipboard-data", m.clipboardGuard(sess, ClipboardRead, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(core.GetSystemClipboard()))
+	}))
+
+	// API: Get Full History
+	mux.HandleFunc("GET /clipboard-history", m.clipboardGuard(sess, ClipboardRead, func(w http.ResponseWriter, r *http.Request) {
+		history := core.GetHistory()
+		if history == nil {
+			history = []string{}
 		}
-		wailsRuntime.EventsEmit(core.Ctx, "server_stopped", true)
-	}()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}))
+
+	// API: Set Clipboard Data
+	mux.HandleFunc("POST /clipboard", m.clipboardGuard(sess, ClipboardReadWrite, func(w http.ResponseWriter, r *http.Request) {
+		core.SetSystemClipboard(r.FormValue("text"))
+		http.Redirect(w, r, "/clipboard", http.StatusSeeOther)
+	}))
+}
 
-	return ServerResponse{IP: ip, Port: portStr, FullURL: fullURL, QRCode: "data:image/png;base64," + backend.ToBase64(png)}, nil
+// clipboardGuard lets a request through to next when the share grants at
+// least need and the client is allowed to use the clipboard
+func (m *Manager) clipboardGuard(sess *Session, need string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access := sess.clipboardAccess()
+		if access == ClipboardOff {
+			http.NotFound(w, r)
+			return
+		}
+		if _, paired := m.deviceOf(r); !paired && !sess.unlocked(r) {
+			http.Error(w, "Password Required", http.StatusForbidden)
+			return
+		}
+		if need == ClipboardReadWrite && access != ClipboardReadWrite {
+			http.Error(w, "The clipboard is read-only", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
 }
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"strconv"
+
+	"godrop-common/discovery"
+	"godrop-gui/backend"
+)
+
+// EnableDiscovery starts advertising shares on the local network over mDNS,
+// so nearby devices can find them without a QR code. Shares also get a URL
+// with the machine's .local name.
+func (m *Manager) EnableDiscovery() error {
+	m.mu.Lock()
+	if m.mdns != nil {
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+
+	mdns, err := discovery.NewResponder(m.hostAddresses)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.mdns = mdns
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, sess := range m.sessions {
+		sessions = append(sessions, sess)
+	}
+	m.mu.Unlock()
+
+	for _, sess := range sessions {
+		m.advertise(sess)
+	}
+	return nil
+}
+
+// DiscoveryHost returns the .local name shares are advertised under, or ""
+// when discovery is off
+func (m *Manager) DiscoveryHost() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mdns == nil {
+		return ""
+	}
+	return m.mdns.Host()
+}
+
+// advertise publishes sess, or refreshes it after a change. Shares bound to
+// localhost can't be reached by anyone else and are left out.
+func (m *Manager) advertise(sess *Session) {
+	m.mu.Lock()
+	mdns := m.mdns
+	m.mu.Unlock()
+	if mdns == nil || sess.bind == backend.BindLocalhost {
+		return
+	}
+
+	sess.mu.Lock()
+	port, _ := strconv.Atoi(sess.Response.Port)
+	svc := discovery.Service{
+		ID:   sess.ID,
+		Name: sess.Label,
+		Mode: sess.Mode,
+		Size: sess.size,
+		Code: sess.password != "",
+		Port: port,
+		Path: sess.path,
+
+		Fingerprint: sess.fingerprint,
+	}
+	sess.mu.Unlock()
+	switch sess.Mode {
+	case "send":
+		svc.Transfer = "/download"
+	case "receive":
+		svc.Transfer = "/upload"
+	}
+	mdns.Set(svc)
+}
+
+// withdraw stops advertising the share with the given ID
+func (m *Manager) withdraw(id string) {
+	m.mu.Lock()
+	mdns := m.mdns
+	m.mu.Unlock()
+	if mdns != nil {
+		mdns.Remove(id)
+	}
+}
+
+// hostAddresses lists the addresses the .local name resolves to: the
+// advertised one first, then the rest that new shares listen on
+func (m *Manager) hostAddresses() []string {
+	ip := m.advertisedIP()
+	addrs := []string{ip}
+	for _, addr := range backend.ReachableAddresses(m.Bind()) {
+		if addr.IP != ip {
+			addrs = append(addrs, addr.IP)
+		}
+	}
+	return addrs
+}
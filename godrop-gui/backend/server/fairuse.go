@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// FairUse limits how a send share's downloads are spread over its clients.
+// Every limit is off when zero.
+type FairUse struct {
+	PerClient     int    `json:"perClient"`     // Downloads each client may make
+	PerClientBy   string `json:"perClientBy"`   // How clients are told apart, see ClientByIP
+	MaxConcurrent int    `json:"maxConcurrent"` // Downloads running at once; later ones queue
+	RateLimit     int    `json:"rateLimit"`     // KiB/s per download
+}
+
+// Ways of telling clients apart for FairUse.PerClient. Paired devices are
+// always counted by device.
+const (
+	ClientByIP    = "ip"
+	ClientByToken = "token" // The cookie issued for the password; the IP on shares without one
+)
+
+const (
+	queueIdleTTL       = 15 * time.Second // A landing page that stops polling loses its place
+	queueGrantTTL      = 30 * time.Second // A slot given to a landing page that doesn't use it is passed on
+	maxQueuedPerClient = 4                // Places a single client may hold in the queue
+)
+
+var (
+	// errClientLimit is returned once a client has used up its own downloads
+	errClientLimit = errors.New("Download Limit Reached For This Client")
+	// errQueueFull is returned to a client that already holds enough places
+	errQueueFull = errors.New("Too Many Downloads Queued For This Client")
+)
+
+// queueEntry is a download waiting for a slot. Entries from landing pages
+// are polled and expire; those of downloads blocked in /download don't.
+type queueEntry struct {
+	ticket   string
+	client   string        // See clientKey
+	ready    chan struct{} // Closed once the entry holds a slot
+	granted  bool
+	blocking bool
+	seen     time.Time // Last poll, or when the slot was granted
+}
+
+// SetSessionFairUse changes the fair use limits of a running send share.
+// Clients keep the downloads they already made.
+func (m *Manager) SetSessionFairUse(id string, f FairUse) (SessionInfo, error) {
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+	if sess.Mode != "send" {
+		return SessionInfo{}, fmt.Errorf("only send shares have download limits")
+	}
+	if err := sess.setFairUse(f); err != nil {
+		return SessionInfo{}, err
+	}
+	m.persist()
+	m.emit("session_updated", sess.ID)
+	return sess.Info(), nil
+}
+
+// setFairUse validates and installs f, letting queued downloads in if it
+// made room
+func (s *Session) setFairUse(f FairUse) error {
+	if f.PerClient < 0 || f.MaxConcurrent < 0 || f.RateLimit < 0 {
+		return fmt.Errorf("download limits can't be negative")
+	}
+	switch f.PerClientBy {
+	case "":
+		f.PerClientBy = ClientByIP
+	case ClientByIP, ClientByToken:
+	default:
+		return fmt.Errorf("unknown way of telling clients apart: %q (want ip or token)", f.PerClientBy)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fairUse = f
+	s.promote()
+	return nil
+}
+
+// clientKey names the client behind r for per-client limits; device is the
+// ID of its paired device, if any
+func (s *Session) clientKey(r *http.Request, device string) string {
+	if device != "" {
+		return "device:" + device
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fairUse.PerClientBy == ClientByToken {
+		if c, err := r.Cookie(accessCookie); err == nil && s.grants[c.Value] {
+			return "token:" + c.Value
+		}
+	}
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	return "ip:" + addr
+}
+
+// clientAllowed reports errClientLimit when client has no downloads left
+func (s *Session) clientAllowed(client string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fairUse.PerClient > 0 && s.clientDownloads[client] >= s.fairUse.PerClient {
+		return errClientLimit
+	}
+	return nil
+}
+
+// joinQueue adds a download by client to the end of the queue, unless the
+// client already holds maxQueuedPerClient places
+func (s *Session) joinQueue(client string, blocking bool) (*queueEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.promote()
+	held := 0
+	for _, e := range s.queue {
+		if e.client == client {
+			held++
+		}
+	}
+	if held >= maxQueuedPerClient {
+		return nil, errQueueFull
+	}
+	e := &queueEntry{ticket: randomToken(), client: client, ready: make(chan struct{}), blocking: blocking, seen: time.Now()}
+	s.queue = append(s.queue, e)
+	s.promote()
+	return e, nil
+}
+
+// queuePosition returns how many downloads are ahead of ticket, counting
+// itself, or 0 once it holds a slot. ok is false for unknown tickets.
+func (s *Session) queuePosition(ticket string) (position int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.promote()
+	for _, e := range s.queue {
+		if !e.granted {
+			position++
+		}
+		if e.ticket == ticket {
+			if e.granted {
+				return 0, true
+			}
+			e.seen = time.Now()
+			return position, true
+		}
+	}
+	return 0, false
+}
+
+// acquireSlot waits for a download slot for client, using ticket's place in
+// the queue when the landing page got one. The returned func frees the slot.
+func (s *Session) acquireSlot(ctx context.Context, client string, ticket string) (func(), error) {
+	s.mu.Lock()
+	var entry *queueEntry
+	for _, e := range s.queue {
+		if e.ticket == ticket && ticket != "" {
+			entry = e
+			entry.blocking = true
+		}
+	}
+	s.mu.Unlock()
+	if entry == nil {
+		var err error
+		if entry, err = s.joinQueue(client, true); err != nil {
+			return nil, err
+		}
+	}
+
+	// Waits for a slot, promoting now and then in case a landing page gave
+	// up its place without anyone polling
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-entry.ready:
+			s.mu.Lock()
+			s.dequeue(entry)
+			s.running++
+			s.mu.Unlock()
+			return func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.running--
+				s.promote()
+			}, nil
+		case <-tick.C:
+			s.mu.Lock()
+			s.promote()
+			s.mu.Unlock()
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.dequeue(entry)
+			s.promote()
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		case <-s.ctx.Done():
+			return nil, fmt.Errorf("Share Closing")
+		}
+	}
+}
+
+// promote drops expired queue entries and hands free slots to the first
+// entries waiting. Callers must hold s.mu.
+func (s *Session) promote() {
+	now := time.Now()
+	kept := s.queue[:0]
+	for _, e := range s.queue {
+		idle := now.Sub(e.seen)
+		if !e.blocking && ((!e.granted && idle > queueIdleTTL) || (e.granted && idle > queueGrantTTL)) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	s.queue = kept
+
+	used := s.running
+	for _, e := range s.queue {
+		if e.granted {
+			used++
+		}
+	}
+	for _, e := range s.queue {
+		if s.fairUse.MaxConcurrent > 0 && used >= s.fairUse.MaxConcurrent {
+			break
+		}
+		if !e.granted {
+			e.granted = true
+			e.seen = now
+			close(e.ready)
+			used++
+		}
+	}
+}
+
+// dequeue removes e from the queue. Callers must hold s.mu.
+func (s *Session) dequeue(e *queueEntry) {
+	for i, q := range s.queue {
+		if q == e {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// queued returns how many downloads wait for a slot. Callers must hold s.mu.
+func (s *Session) queued() int {
+	n := 0
+	for _, e := range s.queue {
+		if !e.granted {
+			n++
+		}
+	}
+	return n
+}
+
+// rateLimit returns the KiB/s each download may use, 0 meaning no limit
+func (s *Session) rateLimit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fairUse.RateLimit
+}
+
+// throttledWriter holds writes back to rate bytes per second
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+// throttle caps the bytes per second written to w, or returns w as is when
+// kibps is zero
+func throttle(w http.ResponseWriter, ctx context.Context, kibps int) http.ResponseWriter {
+	if kibps <= 0 {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, rate: int64(kibps) * 1024, start: time.Now()}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	// Writes at most a tenth of a second's worth at a time, so the pace stays
+	// even with large buffers
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if max := int(t.rate / 10); max > 0 && len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		n, err := t.ResponseWriter.Write(chunk)
+		written += n
+		t.written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		// Whole seconds and the rest apart, so multiplying can't overflow
+		// however much is sent
+		due := t.start.Add(time.Duration(t.written/t.rate)*time.Second + time.Duration(t.written%t.rate*int64(time.Second)/t.rate))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				timer.Stop()
+				return written, t.ctx.Err()
+			}
+		}
+	}
+	return written, nil
+}
+
+func (t *throttledWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
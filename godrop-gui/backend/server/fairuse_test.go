@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newQueueSession returns a running send share with the given limits
+func newQueueSession(t *testing.T, port string, f FairUse) *Session {
+	t.Helper()
+	m, _ := newTestManager(t)
+	payload, _ := testPayload(t, 1024)
+	info, err := m.StartSend(port, "", payload, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, _ := m.Get(info.ID)
+	if err := sess.setFairUse(f); err != nil {
+		t.Fatal(err)
+	}
+	return sess
+}
+
+func TestSetFairUse(t *testing.T) {
+	sess := newQueueSession(t, "18660", FairUse{})
+	cases := []struct {
+		name string
+		f    FairUse
+		by   string // PerClientBy once installed
+		ok   bool
+	}{
+		{"all off", FairUse{}, ClientByIP, true},
+		{"by token", FairUse{PerClient: 2, PerClientBy: ClientByToken}, ClientByToken, true},
+		{"negative per client", FairUse{PerClient: -1}, "", false},
+		{"negative concurrency", FairUse{MaxConcurrent: -1}, "", false},
+		{"negative rate", FairUse{RateLimit: -1}, "", false},
+		{"unknown way", FairUse{PerClientBy: "cookie"}, "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := sess.setFairUse(tc.f)
+			if tc.ok != (err == nil) {
+				t.Fatalf("setFairUse(%+v) = %v", tc.f, err)
+			}
+			if tc.ok && sess.fairUse.PerClientBy != tc.by {
+				t.Errorf("clients are told apart by %q, want %q", sess.fairUse.PerClientBy, tc.by)
+			}
+		})
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	sess := newQueueSession(t, "18670", FairUse{MaxConcurrent: 1})
+	var entries []*queueEntry
+	for _, client := range []string{"ip:a", "ip:b", "ip:c", "ip:d"} {
+		e, err := sess.joinQueue(client, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	// positions returns where each entry stands: 0 once it holds the slot,
+	// -1 once it left the queue
+	positions := func() []int {
+		var got []int
+		for _, e := range entries {
+			pos, ok := sess.queuePosition(e.ticket)
+			if !ok {
+				pos = -1
+			}
+			got = append(got, pos)
+		}
+		return got
+	}
+	steps := []struct {
+		name string
+		do   func()
+		want []int
+	}{
+		{"first in first served", func() {}, []int{0, 1, 2, 3}},
+		{"slot passed on in order", func() {
+			sess.mu.Lock()
+			sess.dequeue(entries[0])
+			sess.promote()
+			sess.mu.Unlock()
+		}, []int{-1, 0, 1, 2}},
+		{"idle page loses its place", func() {
+			sess.mu.Lock()
+			entries[2].seen = time.Now().Add(-2 * queueIdleTTL)
+			sess.promote()
+			sess.mu.Unlock()
+		}, []int{-1, 0, -1, 1}},
+		{"unused slot is passed on", func() {
+			sess.mu.Lock()
+			entries[1].seen = time.Now().Add(-2 * queueGrantTTL)
+			sess.promote()
+			sess.mu.Unlock()
+		}, []int{-1, -1, -1, 0}},
+	}
+	for _, step := range steps {
+		step.do()
+		if got := positions(); !slices.Equal(got, step.want) {
+			t.Fatalf("%s: positions %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestQueuePlacesPerClient(t *testing.T) {
+	sess := newQueueSession(t, "18680", FairUse{MaxConcurrent: 1})
+	for i := 0; i < maxQueuedPerClient; i++ {
+		if _, err := sess.joinQueue("ip:a", false); err != nil {
+			t.Fatalf("place %d: %v", i+1, err)
+		}
+	}
+	if _, err := sess.joinQueue("ip:a", false); err != errQueueFull {
+		t.Errorf("one place too many gave %v, want %v", err, errQueueFull)
+	}
+	if _, err := sess.joinQueue("ip:b", false); err != nil {
+		t.Errorf("another client was turned away: %v", err)
+	}
+}
+
+func TestAcquireSlotWaitsForRelease(t *testing.T) {
+	sess := newQueueSession(t, "18690", FairUse{MaxConcurrent: 1})
+	release, err := sess.acquireSlot(context.Background(), "ip:a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := sess.acquireSlot(ctx, "ip:b", ""); err != context.DeadlineExceeded {
+		t.Fatalf("a second download got %v while the slot was taken", err)
+	}
+	sess.mu.Lock()
+	left := len(sess.queue)
+	sess.mu.Unlock()
+	if left != 0 {
+		t.Errorf("%d entries are queued after the wait was given up", left)
+	}
+
+	release()
+	second, err := sess.acquireSlot(context.Background(), "ip:b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second()
+}
+
+func TestThrottle(t *testing.T) {
+	cases := []struct {
+		kibps int
+		bytes int
+		min   time.Duration // Shortest the write may take
+	}{
+		{0, 64 << 10, 0},
+		{64, 16 << 10, 200 * time.Millisecond},
+		{128, 64 << 10, 450 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		w := throttle(rec, context.Background(), tc.kibps)
+		if tc.kibps == 0 && w != rec {
+			t.Error("a zero rate still throttles")
+		}
+		start := time.Now()
+		n, err := w.Write(make([]byte, tc.bytes))
+		took := time.Since(start)
+		if err != nil || n != tc.bytes || rec.Body.Len() != tc.bytes {
+			t.Errorf("%d KiB/s: wrote %d of %d bytes: %v", tc.kibps, n, tc.bytes, err)
+		}
+		if took < tc.min || (tc.min > 0 && took > 4*tc.min) {
+			t.Errorf("%d KiB/s: %d bytes took %s, want about %s", tc.kibps, tc.bytes, took, tc.min)
+		}
+	}
+}
+
+func TestThrottleStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := throttle(httptest.NewRecorder(), ctx, 1)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := w.Write(make([]byte, 10<<10)); err != context.Canceled {
+		t.Errorf("a cancelled write gave %v", err)
+	}
+}
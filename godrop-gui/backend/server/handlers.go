@@ -1,35 +1,47 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 )
 
-// RegisterCommonHandlers sets up clipboard handlers shared across modes
-func RegisterCommonHandlers(mux *http.ServeMux, getClipboard func() string, setClipboard func(string), getHistory func() []string) {
-	// API: Get Clipboard Data (Polling)
-	mux.HandleFunc("/clipboard-data", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte(getClipboard()))
-	})
-
-	// API: Get Full History
-	mux.HandleFunc("/clipboard-history", func(w http.ResponseWriter, r *http.Request) {
-		history := getHistory()
-		w.Header().Set("Content-Type", "application/json")
-		// Simple JSON join for history if not wanting full json encoder
-		w.Write([]byte(`["` + strings.Join(history, `","`) + `"]`))
-	})
-
-	// API: Set Clipboard Data
-	mux.HandleFunc("/clipboard", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			text := r.FormValue("text")
-			setClipboard(text)
-			http.Redirect(w, r, "/clipboard", http.StatusSeeOther)
+// pauseGuard answers with the paused page while sess is paused. It also
+// serves /api/status in every mode so that open pages notice when it resumes.
+func pauseGuard(sess *Session, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/status" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]bool{"paused": sess.isPaused()})
 			return
 		}
-
-		w.Write([]byte(GetClipboardTemplate(getHistory())))
+		if sess.isPaused() {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(GetPausedTemplate()))
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
+
+// downloadsLeft describes the remaining downloads for the landing page
+func downloadsLeft(info SessionInfo) string {
+	if info.DownloadLimit <= 0 {
+		return "UNLIMITED"
+	}
+	left := info.DownloadLimit - info.Downloads
+	if left < 0 {
+		left = 0
+	}
+	return strconv.Itoa(left)
+}
+
+// expiresAt describes the share expiry for the landing page
+func expiresAt(info SessionInfo) string {
+	if info.ExpiryTime == 0 {
+		return "NEVER"
+	}
+	return time.Unix(info.ExpiryTime, 0).Format("15:04:05")
+}
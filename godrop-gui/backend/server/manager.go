@@ -1,20 +1,495 @@
 package server
 
 import (
-	"context"
-	"os"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
+	"godrop-common/certs"
+	"godrop-common/discovery"
+	"godrop-common/netaccess"
 	"godrop-gui/backend"
+
+	"github.com/skip2/go-qrcode"
 )
 
-// Stop shuts down the server and cleans up resources
-func Stop(core *backend.Core) {
-	if core.Server != nil {
-		core.Server.Shutdown(context.Background())
-		core.Server = nil
+// DefaultGracePeriod is how long a stopping share waits for active transfers
+const DefaultGracePeriod = 30 * time.Second
+
+// Manager keeps track of every share that is currently running
+type Manager struct {
+	// GracePeriod bounds how long Stop and StopAll wait for transfers in
+	// progress before cutting them off
+	GracePeriod time.Duration
+
+	core      *backend.Core
+	mu        sync.Mutex // Never taken while holding a Session's mu
+	sessions  map[string]*Session
+	nextID    int
+	statePath string
+	saveMu    sync.Mutex
+	closed    bool     // Set by Close; shares still starting are turned away
+	iface     string   // Interface name or IP that shares are advertised on
+	bind      string   // Where new shares listen, see SetBind
+	tls       bool     // Serve new shares over HTTPS, see SetTLS
+	allow     []string // Access rules new shares start with, see SetAccess
+	deny      []string
+	mdns      *discovery.Responder // Set by EnableDiscovery
+	devices   *backend.Devices     // Paired devices, nil when pairing is off; see SetDevices
+}
+
+// NewManager creates a share manager backed by core
+func NewManager(core *backend.Core) *Manager {
+	return &Manager{GracePeriod: DefaultGracePeriod, core: core, sessions: make(map[string]*Session), allow: netaccess.DefaultAllow}
+}
+
+// newSession reserves an ID for a share that is about to start. A share
+// brought back from the state file (saved) keeps its ID and has its saved
+// state in place before it starts listening.
+func (m *Manager) newSession(mode, label string, saved *SavedShare) (*Session, error) {
+	m.mu.Lock()
+	var id string
+	if saved != nil && saved.ID != "" && m.sessions[saved.ID] == nil {
+		id = saved.ID
+	} else {
+		m.nextID++
+		id = fmt.Sprintf("%s-%d", mode, m.nextID)
+	}
+	m.mu.Unlock()
+
+	sess := newSession(id, mode, label)
+	if saved != nil {
+		if err := sess.restore(*saved); err != nil {
+			sess.cancel()
+			return nil, err
+		}
+	}
+	return sess, nil
+}
+
+// serve binds the session to a free port starting at the preferred one, builds
+// its URL and QR code, and registers it with the manager
+func (m *Manager) serve(sess *Session, port string, path string, handler http.Handler) (SessionInfo, error) {
+	prefPort, _ := strconv.Atoi(port)
+	if prefPort == 0 {
+		prefPort = 8080
+	}
+	m.mu.Lock()
+	bind, secure, allow, deny := m.bind, m.tls, m.allow, m.deny
+	m.mu.Unlock()
+	sess.mu.Lock()
+	restored := sess.access != nil // Restored shares keep their own rules
+	sess.mu.Unlock()
+	if !restored {
+		if err := sess.setAccess(allow, deny); err != nil {
+			sess.cancel()
+			return SessionInfo{}, err
+		}
+	}
+	hosts, err := backend.BindAddresses(bind)
+	if err != nil {
+		sess.cancel()
+		return SessionInfo{}, err
 	}
-	if core.IsTempArchive && core.ArchivePath != "" {
-		os.Remove(core.ArchivePath)
-		core.IsTempArchive = false
+	lns, actualPort, err := backend.ListenAvailable(hosts, prefPort)
+	if err != nil {
+		sess.cancel()
+		return SessionInfo{}, err
+	}
+	closeAll := func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}
+
+	sess.port = strconv.Itoa(actualPort) // Restored shares ask for the port they had
+	sess.path = path
+	sess.bind = bind
+	sess.reach = backend.ReachableAddresses(bind)
+	if secure {
+		// A fresh certificate for every share, naming each address it has
+		names := []string{m.advertisedIP(), m.DiscoveryHost(), "localhost"}
+		for _, addr := range sess.reach {
+			names = append(names, addr.IP)
+		}
+		cert, fp, err := certs.NewCertificate(names)
+		if err != nil {
+			closeAll()
+			sess.cancel()
+			return SessionInfo{}, err
+		}
+		sess.cert, sess.fingerprint = &cert, fp
+	}
+	sess.Response, err = newResponse(sess, m.advertisedIP(), strconv.Itoa(actualPort), m.DiscoveryHost())
+	if err != nil {
+		closeAll()
+		sess.cancel()
+		return SessionInfo{}, err
+	}
+	sess.server = &http.Server{Handler: m.accessGuard(sess, m.deviceRoutes(sess, pauseGuard(sess, handler)))}
+	if sess.cert != nil {
+		sess.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*sess.cert}}
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		closeAll()
+		sess.cancel()
+		return SessionInfo{}, fmt.Errorf("shutting down")
+	}
+	m.sessions[sess.ID] = sess
+	m.mu.Unlock()
+
+	// The expiry is armed only now, since it stops the share by ID
+	sess.mu.Lock()
+	expiry := sess.expiryTime
+	sess.mu.Unlock()
+	if !expiry.IsZero() {
+		sess.setExpiry(expiry, m.expire(sess))
+	}
+	m.persist()
+	m.advertise(sess)
+
+	var wg sync.WaitGroup
+	for _, ln := range lns {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			var err error
+			if sess.cert != nil {
+				err = sess.server.ServeTLS(ln, "", "") // Also negotiates HTTP/2
+			} else {
+				err = sess.server.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				m.emit("server_error", err.Error())
+				go m.Stop(sess.ID)
+			}
+		}(ln)
+	}
+	go func() {
+		wg.Wait()
+		m.emit("server_stopped", sess.ID)
+	}()
+
+	return sess.Info(), nil
+}
+
+// newResponse builds the URLs and QR codes for reaching sess, preferring ip.
+// When the share is bound to specific addresses and ip is not one of them,
+// the best of those is used instead. With discovery on, host adds a URL
+// using the machine's .local name.
+func newResponse(sess *Session, ip string, port string, host string) (ServerResponse, error) {
+	reach := sess.reach
+	if sess.bind != "" && sess.bind != backend.BindAll && len(reach) > 0 && !hasAddress(reach, ip) {
+		ip = reach[0].IP
+	}
+	if !hasAddress(reach, ip) {
+		reach = append([]backend.NetInterface{{IP: ip}}, reach...)
+	}
+	if host != "" && sess.bind != backend.BindLocalhost {
+		reach = append(reach, backend.NetInterface{Name: "mDNS", IP: host})
+	}
+
+	resp := ServerResponse{ID: sess.ID, Mode: sess.Mode, IP: ip, Port: port, Fingerprint: sess.fingerprint}
+	for _, addr := range reach {
+		url := backend.ShareURL(addr.IP, port, sess.path, sess.fingerprint)
+		png, err := qrcode.Encode(url, qrcode.Medium, 256)
+		if err != nil {
+			return ServerResponse{}, err
+		}
+		a := ShareAddress{Interface: addr.Name, IP: addr.IP, URL: url, QRCode: "data:image/png;base64," + backend.ToBase64(png)}
+		if addr.IP == ip {
+			resp.FullURL, resp.QRCode = a.URL, a.QRCode
+			resp.Addresses = append([]ShareAddress{a}, resp.Addresses...)
+		} else {
+			resp.Addresses = append(resp.Addresses, a)
+		}
+	}
+	return resp, nil
+}
+
+func hasAddress(list []backend.NetInterface, ip string) bool {
+	for _, addr := range list {
+		if addr.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBind chooses where new shares listen: BindAll, BindLocalhost, an
+// interface name or an IP address. Running shares are not rebound.
+func (m *Manager) SetBind(bind string) error {
+	if _, err := backend.BindAddresses(bind); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.bind = bind
+	m.mu.Unlock()
+	return nil
+}
+
+// Bind returns the bind setting for new shares
+func (m *Manager) Bind() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bind
+}
+
+// SetTLS chooses whether new shares are served over HTTPS, each with a
+// self-signed certificate of its own. Running shares are not changed.
+func (m *Manager) SetTLS(on bool) {
+	m.mu.Lock()
+	m.tls = on
+	m.mu.Unlock()
+}
+
+// TLS reports whether new shares are served over HTTPS
+func (m *Manager) TLS() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tls
+}
+
+// advertisedIP resolves the configured interface, falling back to the best
+// ranked one if it has gone away
+func (m *Manager) advertisedIP() string {
+	m.mu.Lock()
+	pref := m.iface
+	m.mu.Unlock()
+	ip, err := backend.ResolveIP(pref)
+	if err != nil {
+		ip, _ = backend.ResolveIP("")
+	}
+	return ip
+}
+
+// SetInterface chooses the interface (by name) or IP address that shares are
+// advertised on, empty meaning automatic. Running shares get a new URL and QR
+// code; they keep listening as before.
+func (m *Manager) SetInterface(pref string) ([]SessionInfo, error) {
+	ip, err := backend.ResolveIP(pref)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.iface = pref
+	m.mu.Unlock()
+	sessions := m.sortedSessions()
+
+	// Resolved before taking sess.mu, which must never be held while
+	// locking m.mu
+	host := m.DiscoveryHost()
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		sess.reach = backend.ReachableAddresses(sess.bind) // Addresses may have changed since the share started
+		resp, err := newResponse(sess, ip, sess.Response.Port, host)
+		if err == nil {
+			sess.Response = resp
+		}
+		sess.mu.Unlock()
+		m.emit("session_updated", sess.ID)
 	}
+	return m.List(), nil
+}
+
+// Interface returns the interface preference set with SetInterface
+func (m *Manager) Interface() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.iface
+}
+
+// Stop shuts down a single share and cleans up its resources. It returns once
+// the share's active transfers have finished or the grace period is over.
+func (m *Manager) Stop(id string) error {
+	m.mu.Lock()
+	sess, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no such session: %s", id)
+	}
+	m.withdraw(id)
+	sess.shutdown(m.core, m.GracePeriod)
+	m.persist()
+	return nil
+}
+
+// StopAll shuts down every running share, draining their transfers in
+// parallel. The state file still lists them, so they come back on the next
+// Restore.
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	sessions := m.sessions
+	m.sessions = make(map[string]*Session)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sess := range sessions {
+		m.withdraw(sess.ID)
+		wg.Add(1)
+		go func(sess *Session) {
+			defer wg.Done()
+			sess.shutdown(m.core, m.GracePeriod)
+		}(sess)
+	}
+	wg.Wait()
+}
+
+// Close refuses new shares, including ones still being prepared, and stops
+// every running share. It is called once when the process exits.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	mdns := m.mdns
+	m.mdns = nil
+	m.mu.Unlock()
+	if mdns != nil {
+		mdns.Close()
+	}
+	m.StopAll()
+}
+
+// List returns a snapshot of every running share, oldest first
+func (m *Manager) List() []SessionInfo {
+	sessions := m.sortedSessions()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, sess.Info())
+	}
+	return infos
+}
+
+// sortedSessions returns the running sessions, oldest first. The manager is
+// unlocked again when it returns, so the caller may lock each session.
+func (m *Manager) sortedSessions() []*Session {
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, sess := range m.sessions {
+		sessions = append(sessions, sess)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].StartedAt.Equal(sessions[j].StartedAt) {
+			return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+// Get returns the running session with the given ID
+func (m *Manager) Get(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[id]
+	return sess, ok
+}
+
+// Extend moves a share's expiry by the given number of minutes, which may be
+// negative. A share without an expiry gets one counted from now.
+func (m *Manager) Extend(id string, minutes int) (SessionInfo, error) {
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+
+	sess.mu.Lock()
+	base := sess.expiryTime
+	sess.mu.Unlock()
+	if base.IsZero() || base.Before(time.Now()) {
+		base = time.Now()
+	}
+
+	sess.setExpiry(base.Add(time.Duration(minutes)*time.Minute), m.expire(sess))
+	m.persist()
+	m.emit("session_updated", sess.ID)
+	return sess.Info(), nil
+}
+
+// SetLimit changes how many downloads a share allows, 0 meaning unlimited.
+// Lowering it to or below the downloads already served closes the share once
+// its active transfers finish.
+func (m *Manager) SetLimit(id string, limit int) (SessionInfo, error) {
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+	if limit < 0 {
+		return SessionInfo{}, fmt.Errorf("limit must not be negative")
+	}
+
+	sess.mu.Lock()
+	sess.downloadLimit = limit
+	exhausted := limit > 0 && sess.currentDownloads >= limit
+	sess.mu.Unlock()
+
+	if exhausted {
+		go m.Stop(sess.ID)
+	}
+	m.persist()
+	m.emit("session_updated", sess.ID)
+	return sess.Info(), nil
+}
+
+// SetPassword changes a share's password; an empty password removes it
+func (m *Manager) SetPassword(id string, password string) (SessionInfo, error) {
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+	sess.setPassword(password)
+	m.persist()
+	m.advertise(sess)
+	m.emit("session_updated", sess.ID)
+	return sess.Info(), nil
+}
+
+// Pause makes a share unavailable without closing it or changing its URL
+func (m *Manager) Pause(id string) (SessionInfo, error) {
+	return m.setPaused(id, true)
+}
+
+// Resume makes a paused share available again
+func (m *Manager) Resume(id string) (SessionInfo, error) {
+	return m.setPaused(id, false)
+}
+
+func (m *Manager) setPaused(id string, paused bool) (SessionInfo, error) {
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+	sess.setPaused(paused)
+	m.persist()
+	m.emit("session_updated", sess.ID)
+	return sess.Info(), nil
+}
+
+// expire returns the callback that closes sess when its expiry is reached
+func (m *Manager) expire(sess *Session) func() {
+	return func() {
+		m.emit("server_error", "Timeout Reached. Server Stopping.")
+		m.Stop(sess.ID)
+	}
+}
+
+// CancelArchive aborts a send that is still packaging its files, by the ID
+// its archive-progress events carry
+func (m *Manager) CancelArchive(id string) error {
+	return m.core.CancelArchive(id)
+}
+
+func (m *Manager) emit(name string, data ...interface{}) {
+	m.core.Events.Emit(name, data...)
 }
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"godrop-gui/backend"
+)
+
+// newTestManager returns a manager whose shares listen on localhost only and
+// whose events arrive on the returned sink
+func newTestManager(t *testing.T) (*Manager, *backend.ChannelSink) {
+	t.Helper()
+	events := backend.NewChannelSink(1024)
+	core := backend.NewCore(events)
+	m := NewManager(core)
+	if err := m.SetBind("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Close()
+		core.Shutdown()
+	})
+	return m, events
+}
+
+// testPayload writes size bytes to a file and returns it ready to send
+func testPayload(t *testing.T, size int) (SendPayload, []byte) {
+	t.Helper()
+	data := bytes.Repeat([]byte("godrop!\n"), size/8+1)[:size]
+	path := filepath.Join(t.TempDir(), "report.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return SendPayload{FilePath: path, FileName: "report.bin", Files: []string{path}}, data
+}
+
+// waitEvent waits for an event called name about the share with the given
+// ID, skipping any others
+func waitEvent(t *testing.T, events *backend.ChannelSink, name string, id string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events.C:
+			if e.Name != name || len(e.Data) == 0 {
+				continue
+			}
+			switch data := e.Data[0].(type) {
+			case string:
+				if data == id {
+					return
+				}
+			case map[string]string:
+				if data["session"] == id {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("no %s event for %s", name, id)
+		}
+	}
+}
+
+func shareURL(info SessionInfo, path string) string {
+	return "http://127.0.0.1:" + info.Port + path
+}
+
+// startDownload fetches the share's file in the background. The body, or
+// the error that cut it short, arrives on the returned channel.
+func startDownload(t *testing.T, info SessionInfo) <-chan []byte {
+	t.Helper()
+	body := make(chan []byte, 1)
+	go func() {
+		resp, err := http.Get(shareURL(info, "/download"))
+		if err != nil {
+			body <- nil
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- b
+	}()
+	return body
+}
+
+func TestConcurrentDownloadsRespectLimit(t *testing.T) {
+	m, _ := newTestManager(t)
+	payload, _ := testPayload(t, 4096)
+	info, err := m.StartSend("18600", "", payload, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, ok := m.Get(info.ID)
+	if !ok {
+		t.Fatal("the share is not registered")
+	}
+	handler := sess.server.Handler
+
+	var served atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/download", nil)
+			req.RemoteAddr = "127.0.0.1:40000"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code == http.StatusOK {
+				served.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := served.Load(); got != 3 {
+		t.Errorf("%d downloads were served, want 3", got)
+	}
+	if got := sess.Info().Downloads; got != 3 {
+		t.Errorf("the share counted %d downloads, want 3", got)
+	}
+}
+
+func TestStopThenStartSend(t *testing.T) {
+	m, events := newTestManager(t)
+	payload, data := testPayload(t, 4096)
+	first, err := m.StartSend("18610", "", payload, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, events, "server_stopped", first.ID)
+	if _, ok := m.Get(first.ID); ok {
+		t.Error("the stopped share is still registered")
+	}
+	if err := m.Stop(first.ID); err == nil {
+		t.Error("stopping the share twice succeeded")
+	}
+	if resp, err := http.Get(shareURL(first, "/api/info")); err == nil {
+		resp.Body.Close()
+		t.Error("the stopped share still answers")
+	}
+
+	second, err := m.StartSend("18610", "", payload, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID == first.ID {
+		t.Errorf("the new share reused the ID %s", first.ID)
+	}
+	if second.Port != first.Port {
+		t.Errorf("the new share got port %s, want the freed %s", second.Port, first.Port)
+	}
+	if got := <-startDownload(t, second); !bytes.Equal(got, data) {
+		t.Errorf("the new share served %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestExpiryStopsShare(t *testing.T) {
+	m, events := newTestManager(t)
+	payload, _ := testPayload(t, 4096)
+	info, err := m.StartSend("18620", "", payload, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, _ := m.Get(info.ID)
+	sess.setExpiry(time.Now().Add(50*time.Millisecond), m.expire(sess))
+
+	waitEvent(t, events, "server_stopped", info.ID)
+	if _, ok := m.Get(info.ID); ok {
+		t.Error("the expired share is still registered")
+	}
+}
+
+func TestExtendMovesExpiry(t *testing.T) {
+	m, _ := newTestManager(t)
+	payload, _ := testPayload(t, 4096)
+	info, err := m.StartSend("18630", "", payload, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, _ := m.Get(info.ID)
+	sess.setExpiry(time.Now().Add(100*time.Millisecond), m.expire(sess))
+	extended, err := m.Extend(info.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := time.Until(time.Unix(extended.ExpiryTime, 0)); left < 50*time.Second {
+		t.Errorf("the share expires in %s after extending, want about a minute", left)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := m.Get(info.ID); !ok {
+		t.Error("the share expired at its old time")
+	}
+}
+
+func TestStopWaitsForTransfers(t *testing.T) {
+	m, events := newTestManager(t)
+	m.GracePeriod = 10 * time.Second
+	payload, data := testPayload(t, 10*1024)
+	info, err := m.StartSend("18640", "", payload, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 KiB/s keeps the download running for half a second
+	if _, err := m.SetSessionFairUse(info.ID, FairUse{RateLimit: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := startDownload(t, info)
+	waitEvent(t, events, "download_started", info.ID)
+	if err := m.Stop(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-body:
+		if !bytes.Equal(got, data) {
+			t.Errorf("the download was cut off after %d of %d bytes", len(got), len(data))
+		}
+	default:
+		t.Error("Stop returned before the download finished")
+	}
+}
+
+func TestStopCutsOffAfterGracePeriod(t *testing.T) {
+	m, events := newTestManager(t)
+	m.GracePeriod = 100 * time.Millisecond
+	payload, data := testPayload(t, 100*1024)
+	info, err := m.StartSend("18650", "", payload, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 KiB/s would take five seconds
+	if _, err := m.SetSessionFairUse(info.ID, FairUse{RateLimit: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := startDownload(t, info)
+	waitEvent(t, events, "download_started", info.ID)
+	start := time.Now()
+	if err := m.Stop(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("Stop took %s with a grace period of %s", took, m.GracePeriod)
+	}
+	select {
+	case got := <-body:
+		if len(got) >= len(data) {
+			t.Error("the download finished despite the grace period running out")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the download kept going after the grace period")
+	}
+}
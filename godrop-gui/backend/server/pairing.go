@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"godrop-common/netaccess"
+	"godrop-gui/backend"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// deviceCookie holds the login token of a paired device. Cookies are shared
+// by every port of a host, so one login covers all shares.
+const deviceCookie = "godrop_device"
+
+// SetDevices turns on pairing, remembering paired devices in devices
+func (m *Manager) SetDevices(devices *backend.Devices) {
+	m.mu.Lock()
+	m.devices = devices
+	m.mu.Unlock()
+}
+
+// ListDevices returns the paired devices
+func (m *Manager) ListDevices() []backend.Device {
+	devices := m.pairedDevices()
+	if devices == nil {
+		return []backend.Device{}
+	}
+	return devices.List()
+}
+
+// RevokeDevice unpairs a device; it needs the password of a share again
+func (m *Manager) RevokeDevice(id string) error {
+	devices := m.pairedDevices()
+	if devices == nil {
+		return fmt.Errorf("pairing is not enabled")
+	}
+	if err := devices.Revoke(id); err != nil {
+		return err
+	}
+	m.emit("device_revoked", id)
+	return nil
+}
+
+// PairingLink returns a one-time link and QR code that pairs the device
+// opening it, served by the share with the given ID. Browsers only allow the
+// device's key to be made on HTTPS pages, so the share must use HTTPS.
+func (m *Manager) PairingLink(id string) (ShareAddress, error) {
+	devices := m.pairedDevices()
+	if devices == nil {
+		return ShareAddress{}, fmt.Errorf("pairing is not enabled")
+	}
+	sess, ok := m.Get(id)
+	if !ok {
+		return ShareAddress{}, fmt.Errorf("no such session: %s", id)
+	}
+	if sess.cert == nil {
+		return ShareAddress{}, fmt.Errorf("pairing needs a share served over HTTPS")
+	}
+
+	token, err := devices.NewPairingToken()
+	if err != nil {
+		return ShareAddress{}, err
+	}
+	resp := sess.Info().ServerResponse
+	url := backend.ShareURL(resp.IP, resp.Port, "/pair", sess.fingerprint) + "&token=" + token
+	png, err := qrcode.Encode(url, qrcode.Medium, 256)
+	if err != nil {
+		return ShareAddress{}, err
+	}
+	return ShareAddress{IP: resp.IP, URL: url, QRCode: "data:image/png;base64," + backend.ToBase64(png)}, nil
+}
+
+func (m *Manager) pairedDevices() *backend.Devices {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.devices
+}
+
+// deviceOf returns the paired device that sent r, if it is logged in
+func (m *Manager) deviceOf(r *http.Request) (backend.Device, bool) {
+	devices := m.pairedDevices()
+	c, err := r.Cookie(deviceCookie)
+	if devices == nil || err != nil {
+		return backend.Device{}, false
+	}
+	return devices.Lookup(c.Value)
+}
+
+// deviceRoutes serves pairing and device login on sess, when pairing is on
+// and the share uses HTTPS, and hands everything else to next
+func (m *Manager) deviceRoutes(sess *Session, next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", next)
+
+	mux.HandleFunc("GET /pair", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetPairTemplate()))
+	})
+
+	mux.HandleFunc("POST /api/pair", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Token     string
+			Name      string
+			PublicKey []byte
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		dev, login, err := m.pairedDevices().Pair(body.Token, body.Name, body.PublicKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		setDeviceCookie(w, login)
+		m.emit("device_paired", map[string]string{"id": dev.ID, "device": dev.Name, "ip": r.RemoteAddr, "session": sess.ID})
+		json.NewEncoder(w).Encode(map[string]string{"id": dev.ID, "name": dev.Name})
+	})
+
+	mux.HandleFunc("GET /api/device/challenge", func(w http.ResponseWriter, r *http.Request) {
+		challenge, err := m.pairedDevices().Challenge(netaccess.ClientIP(r.RemoteAddr))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"challenge": challenge})
+	})
+
+	mux.HandleFunc("POST /api/device/login", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ID        string
+			Challenge string
+			Signature []byte
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		dev, login, err := m.pairedDevices().Login(body.ID, body.Challenge, body.Signature)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		setDeviceCookie(w, login)
+		m.emit("device_login", map[string]string{"device": dev.Name, "ip": r.RemoteAddr, "session": sess.ID})
+		json.NewEncoder(w).Encode(map[string]string{"name": dev.Name})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sess.cert == nil || m.pairedDevices() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func setDeviceCookie(w http.ResponseWriter, login string) {
+	http.SetCookie(w, &http.Cookie{Name: deviceCookie, Value: login, Path: "/", HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+}
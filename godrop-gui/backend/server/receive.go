@@ -3,44 +3,74 @@ package server
 import (
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 
+	"godrop-common/atrest"
 	"godrop-gui/backend"
-
-	"github.com/skip2/go-qrcode"
-	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
-func StartReceive(core *backend.Core, port string, saveDir string) (ServerResponse, error) {
+// StartReceive starts a new dropzone share that saves uploads into saveDir.
+// When encryptTo is set, a passphrase or public key (see atrest.NewRecipient),
+// every upload is encrypted as it is written and gets the .godrop extension.
+func (m *Manager) StartReceive(port string, saveDir string, extract bool, encryptTo string) (SessionInfo, error) {
+	return m.startReceive(port, saveDir, extract, encryptTo, nil)
+}
+
+func (m *Manager) startReceive(port string, saveDir string, extract bool, encryptTo string, saved *SavedShare) (SessionInfo, error) {
 	if _, err := os.Stat(saveDir); os.IsNotExist(err) {
-		return ServerResponse{}, fmt.Errorf("save directory does not exist")
+		return SessionInfo{}, fmt.Errorf("save directory does not exist")
+	}
+	recipient, err := atrest.NewRecipient(encryptTo)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	if recipient != nil && extract {
+		return SessionInfo{}, fmt.Errorf("encrypted files can't be extracted")
+	}
+	core := m.core
+	sess, err := m.newSession("receive", filepath.Base(saveDir), saved)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	sess.saveDir = saveDir
+	sess.extract = extract
+	if recipient != nil {
+		sess.encryptTo = recipient.String()
 	}
 
 	mux := http.NewServeMux()
-	RegisterCommonHandlers(mux, core.GetSystemClipboard, core.SetSystemClipboard, core.GetHistory)
+	m.clipboardRoutes(sess, mux)
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(GetReceiveTemplate()))
+		dev, _ := m.deviceOf(r)
+		w.Write([]byte(GetReceiveTemplate(dev.Name)))
 	})
 
 	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		// The file part is streamed straight to its destination, so nothing
+		// but the saved (and maybe encrypted) file ever reaches the disk
 		r.Body = http.MaxBytesReader(w, r.Body, 10<<30)
-		if err := r.ParseMultipartForm(32 << 20); err != nil {
+		mr, err := r.MultipartReader()
+		if err != nil {
 			http.Error(w, "File too big or invalid", http.StatusBadRequest)
 			return
 		}
-
-		file, handler, err := r.FormFile("file")
+		file, err := nextFilePart(mr, "file")
 		if err != nil {
 			http.Error(w, "Error retrieving file", http.StatusBadRequest)
 			return
 		}
 		defer file.Close()
 
-		dstPath := filepath.Join(saveDir, handler.Filename)
+		filename := file.FileName()
+		name := filename
+		if recipient != nil {
+			name += atrest.Ext
+		}
+		dstPath := filepath.Join(saveDir, name)
 		dst, err := os.Create(dstPath)
 		if err != nil {
 			http.Error(w, "Error saving file", http.StatusInternalServerError)
@@ -48,40 +78,66 @@ func StartReceive(core *backend.Core, port string, saveDir string) (ServerRespon
 		}
 		defer dst.Close()
 
-		pt := &backend.ProgressTracker{Total: handler.Size, EventName: "transfer-progress", Ctx: core.Ctx, Reader: file}
-		if _, err := io.Copy(dst, pt); err != nil {
+		var out io.Writer = dst
+		var sealed io.WriteCloser
+		if recipient != nil {
+			if sealed, err = recipient.Encrypt(dst); err != nil {
+				http.Error(w, "Error saving file", http.StatusInternalServerError)
+				return
+			}
+			out = sealed
+		}
+
+		// The body's length stands in for the file's, which isn't known yet
+		pt := &backend.ProgressTracker{Total: r.ContentLength, EventName: "transfer-progress", Events: core.Events, Reader: file, Session: sess.ID}
+		dev, _ := m.deviceOf(r)
+		done := sess.track(r, dev.Name)
+		_, err = io.Copy(out, pt)
+		done(pt.Current)
+		if err == nil && sealed != nil {
+			err = sealed.Close()
+		}
+		if err != nil {
+			dst.Close()
+			if recipient != nil {
+				os.Remove(dstPath) // A cut off encrypted file can't be opened anyway
+			}
 			http.Error(w, "Error saving file content", http.StatusInternalServerError)
 			return
 		}
+		dst.Close()
+		pt.Total = pt.Current
+		pt.EmitProgress()
+
+		m.emit("file-received", name, sess.ID, dev.Name)
 
-		wailsRuntime.EventsEmit(core.Ctx, "file-received", handler.Filename)
+		if extract && backend.IsExtractable(filename) {
+			go func() {
+				result, err := backend.ExtractArchive(dstPath)
+				if err != nil {
+					m.emit("extract-error", map[string]string{"archive": filename, "error": err.Error()})
+					return
+				}
+				m.emit("archive-extracted", result)
+			}()
+		}
 		w.Write([]byte(`<h1 style='color:green; font-family:sans-serif; text-align:center;'>File Sent!</h1><script>setTimeout(() => window.location.href='/', 2000)</script>`))
 	})
 
-	ip := backend.GetOutboundIP()
-	prefPort, _ := strconv.Atoi(port)
-	if prefPort == 0 {
-		prefPort = 8080
-	}
-	actualPort, err := backend.FindAvailablePort(prefPort)
-	if err != nil {
-		return ServerResponse{}, err
-	}
-	portStr := strconv.Itoa(actualPort)
-	fullURL := fmt.Sprintf("http://%s:%s", ip, portStr)
-
-	png, err := qrcode.Encode(fullURL, qrcode.Medium, 256)
-	if err != nil {
-		return ServerResponse{}, err
-	}
+	return m.serve(sess, port, "", mux)
+}
 
-	core.Server = &http.Server{Addr: ":" + portStr, Handler: mux}
-	go func() {
-		if err := core.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			wailsRuntime.EventsEmit(core.Ctx, "server_error", err.Error())
+// nextFilePart skips ahead to the file uploaded as field. Its name has already
+// been stripped of any directories.
+func nextFilePart(mr *multipart.Reader, field string) (*multipart.Part, error) {
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, err
 		}
-		wailsRuntime.EventsEmit(core.Ctx, "server_stopped", true)
-	}()
-
-	return ServerResponse{IP: ip, Port: portStr, FullURL: fullURL, QRCode: "data:image/png;base64," + backend.ToBase64(png)}, nil
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
 }
@@ -2,112 +2,182 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	"godrop-gui/backend"
-
-	"github.com/skip2/go-qrcode"
-	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
-func StartSend(core *backend.Core, port string, password string, files []string, limit int, timeout int) (ServerResponse, error) {
+// PrepareSend resolves the selection into a single file to serve, archiving
+// folders and multi-file selections. It runs before the share is registered so
+// that a long archive job can be followed and cancelled from the UI.
+func PrepareSend(core *backend.Core, files []string) (SendPayload, error) {
 	if len(files) == 0 {
-		return ServerResponse{}, fmt.Errorf("no files selected")
+		return SendPayload{}, fmt.Errorf("no files selected")
 	}
 
-	var targetFile string
-	var fileName string
-
 	if len(files) == 1 {
 		fi, err := os.Stat(files[0])
-		if err == nil && fi.IsDir() {
-			var zipErr error
-			targetFile, fileName, zipErr = core.CreateZipArchive(files)
-			if zipErr != nil {
-				return ServerResponse{}, zipErr
-			}
-		} else {
-			targetFile = files[0]
-			fileName = filepath.Base(targetFile)
-			core.IsTempArchive = false
+		if err == nil && !fi.IsDir() {
+			return SendPayload{FilePath: files[0], FileName: filepath.Base(files[0]), Files: files}, nil
+		}
+	}
+
+	targetFile, fileName, cached, err := core.ArchiveSelection(files)
+	if err != nil {
+		return SendPayload{}, err
+	}
+	return SendPayload{FilePath: targetFile, FileName: fileName, Files: files, IsTemp: !cached, IsCached: cached}, nil
+}
+
+// Send archives files if needed and starts a send share for them
+func (m *Manager) Send(port string, password string, files []string, limit int, timeout int) (SessionInfo, error) {
+	return m.send(port, password, files, limit, timeout, nil)
+}
+
+func (m *Manager) send(port string, password string, files []string, limit int, timeout int, saved *SavedShare) (SessionInfo, error) {
+	payload, err := PrepareSend(m.core, files)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+
+	info, err := m.startSend(port, password, payload, limit, timeout, saved)
+	if err != nil {
+		if payload.IsTemp {
+			os.Remove(payload.FilePath)
 		}
-	} else {
-		var zipErr error
-		targetFile, fileName, zipErr = core.CreateZipArchive(files)
-		if zipErr != nil {
-			return ServerResponse{}, zipErr
+		if payload.IsCached {
+			m.core.ReleaseArchive(payload.FilePath)
 		}
 	}
+	return info, err
+}
+
+// StartSend starts a new share that serves payload until its limit or timeout is reached
+func (m *Manager) StartSend(port string, password string, payload SendPayload, limit int, timeout int) (SessionInfo, error) {
+	return m.startSend(port, password, payload, limit, timeout, nil)
+}
+
+func (m *Manager) startSend(port string, password string, payload SendPayload, limit int, timeout int, saved *SavedShare) (SessionInfo, error) {
+	targetFile := payload.FilePath
+	fileName := payload.FileName
+	core := m.core
 
 	info, err := os.Stat(targetFile)
 	if err != nil {
-		return ServerResponse{}, err
+		return SessionInfo{}, err
 	}
 	fileSize := info.Size()
 
-	core.DownloadLimit = limit
-	core.CurrentDownloads = 0
+	sess, err := m.newSession("send", fileName, saved)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	sess.downloadLimit = limit
+	sess.password = password
+	sess.files = payload.Files
+	sess.size = fileSize
+	sess.isTempArchive = payload.IsTemp
+	sess.isCachedArchive = payload.IsCached
+	if payload.IsTemp || payload.IsCached {
+		sess.archivePath = targetFile
+	}
 	if timeout > 0 {
-		core.ExpiryTime = time.Now().Add(time.Duration(timeout) * time.Minute)
-		go func() {
-			time.Sleep(time.Duration(timeout) * time.Minute)
-			core.ServerMutex.Lock()
-			defer core.ServerMutex.Unlock()
-			if core.Server != nil {
-				wailsRuntime.EventsEmit(core.Ctx, "server_error", "Timeout Reached. Server Stopping.")
-				Stop(core)
-			}
-		}()
-	} else {
-		// Infinity Connection
-		core.ExpiryTime = time.Time{}
+		sess.expiryTime = time.Now().Add(time.Duration(timeout) * time.Minute)
 	}
 
 	mux := http.NewServeMux()
-	RegisterCommonHandlers(mux, core.GetSystemClipboard, core.SetSystemClipboard, core.GetHistory)
+	m.clipboardRoutes(sess, mux)
 
 	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+		info := sess.Info()
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"filename": fileName,
-			"size":     fileSize,
-			"password": password != "",
+			"filename":   fileName,
+			"size":       fileSize,
+			"password":   info.HasPassword,
+			"paused":     info.Paused,
+			"limit":      info.DownloadLimit,
+			"downloads":  info.Downloads,
+			"expiryTime": info.ExpiryTime,
 		})
 	})
 
-	mux.HandleFunc("/api/verify", func(w http.ResponseWriter, r *http.Request) {
-		var body struct{ Code string }
-		json.NewDecoder(r.Body).Decode(&body)
-		authorized := password == "" || body.Code == password
-		json.NewEncoder(w).Encode(map[string]bool{"success": authorized})
+	// A landing page takes a place in the queue before downloading, and polls
+	// its position until a slot is free
+	mux.HandleFunc("POST /api/queue", func(w http.ResponseWriter, r *http.Request) {
+		dev, paired := m.deviceOf(r)
+		if !paired && !sess.authorized(r) {
+			http.Error(w, "Password Required", http.StatusForbidden)
+			return
+		}
+		client := sess.clientKey(r, dev.ID)
+		if err := sess.clientAllowed(client); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		entry, err := sess.joinQueue(client, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		position, _ := sess.queuePosition(entry.ticket)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ticket": entry.ticket, "position": position})
+	})
+
+	mux.HandleFunc("GET /api/queue", func(w http.ResponseWriter, r *http.Request) {
+		ticket := r.URL.Query().Get("ticket")
+		position, ok := sess.queuePosition(ticket)
+		if !ok {
+			http.Error(w, "Unknown ticket", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ticket": ticket, "position": position})
 	})
 
+	mux.HandleFunc("/api/verify", sess.unlockHandler(func() bool { return true }))
+
 	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
-		core.ServerMutex.Lock()
-		if !core.ExpiryTime.IsZero() && time.Now().After(core.ExpiryTime) {
-			core.ServerMutex.Unlock()
-			http.Error(w, "Link Expired", http.StatusGone)
+		dev, paired := m.deviceOf(r)
+		if !paired && !sess.authorized(r) {
+			http.Error(w, "Password Required", http.StatusForbidden)
+			return
+		}
+		client := sess.clientKey(r, dev.ID)
+		if err := sess.clientAllowed(client); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		release, err := sess.acquireSlot(r.Context(), client, r.URL.Query().Get("ticket"))
+		if errors.Is(err, errQueueFull) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
 			return
 		}
-		if core.DownloadLimit > 0 && core.CurrentDownloads >= core.DownloadLimit {
-			core.ServerMutex.Unlock()
-			http.Error(w, "Limit Exceeded", http.StatusGone)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
-		core.CurrentDownloads++
-		current := core.CurrentDownloads
-		core.ServerMutex.Unlock()
+		defer release()
+		current, limit, err := sess.reserveDownload(client)
+		if errors.Is(err, errClientLimit) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		m.persist()
 
-		wailsRuntime.EventsEmit(core.Ctx, "download_started", map[string]string{"ip": r.RemoteAddr})
-		pt := &backend.ProgressTracker{Total: fileSize, EventName: "transfer-progress", Ctx: core.Ctx}
-		pw := &backend.ProgressResponseWriter{ResponseWriter: w, Tracker: pt}
+		m.emit("download_started", map[string]string{"ip": r.RemoteAddr, "device": dev.Name, "session": sess.ID})
+		pt := &backend.ProgressTracker{Total: fileSize, EventName: "transfer-progress", Events: core.Events, Session: sess.ID}
+		pw := &backend.ProgressResponseWriter{ResponseWriter: throttle(w, r.Context(), sess.rateLimit()), Tracker: pt}
 
 		ext := strings.ToLower(filepath.Ext(fileName))
 		contentType := mime.TypeByExtension(ext)
@@ -140,41 +210,22 @@ func StartSend(core *backend.Core, port string, password string, files []string,
 		w.Header().Set("Pragma", "no-cache")
 		w.Header().Set("Expires", "0")
 
+		done := sess.track(r, dev.Name)
 		http.ServeFile(pw, r, targetFile)
+		done(pt.Current)
 
-		if core.DownloadLimit > 0 && current >= core.DownloadLimit {
-			go func() { time.Sleep(5 * time.Second); core.ServerMutex.Lock(); Stop(core); core.ServerMutex.Unlock() }()
+		// The last allowed download closes the share; others still running
+		// are drained by Stop rather than cut off
+		if limit > 0 && current >= limit {
+			go m.Stop(sess.ID)
 		}
 	})
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(GetSendTemplate(fileName, backend.FormatSize(fileSize), password != "")))
+		info := sess.Info()
+		dev, _ := m.deviceOf(r)
+		w.Write([]byte(GetSendTemplate(fileName, backend.FormatSize(fileSize), info.HasPassword, downloadsLeft(info), expiresAt(info), dev.Name)))
 	})
 
-	ip := backend.GetOutboundIP()
-	prefPort, _ := strconv.Atoi(port)
-	if prefPort == 0 {
-		prefPort = 8080
-	}
-	actualPort, err := backend.FindAvailablePort(prefPort)
-	if err != nil {
-		return ServerResponse{}, err
-	}
-	portStr := strconv.Itoa(actualPort)
-	fullURL := fmt.Sprintf("http://%s:%s", ip, portStr)
-
-	png, err := qrcode.Encode(fullURL, qrcode.Medium, 256)
-	if err != nil {
-		return ServerResponse{}, err
-	}
-
-	core.Server = &http.Server{Addr: ":" + portStr, Handler: mux}
-	go func() {
-		if err := core.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			wailsRuntime.EventsEmit(core.Ctx, "server_error", err.Error())
-		}
-		wailsRuntime.EventsEmit(core.Ctx, "server_stopped", true)
-	}()
-
-	return ServerResponse{IP: ip, Port: portStr, FullURL: fullURL, QRCode: "data:image/png;base64," + backend.ToBase64(png)}, nil
+	return m.serve(sess, port, "", mux)
 }
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"godrop-common/netaccess"
+	"godrop-common/ratelimit"
+	"godrop-gui/backend"
+)
+
+// Session holds everything that belongs to a single send, receive or
+// clipboard share. Its context is cancelled when the share stops, which also
+// ends any timers that were started for it.
+type Session struct {
+	ID        string
+	Mode      string
+	Label     string
+	StartedAt time.Time
+	Response  ServerResponse
+
+	// What the share was started with, recorded in the state file
+	port      string
+	path      string // Landing page path, part of the share URL
+	bind      string
+	reach     []backend.NetInterface // Addresses the share's listeners can be reached on
+	files     []string
+	saveDir   string
+	extract   bool
+	encryptTo string // Who received files are encrypted for, see atrest.Recipient
+	size      int64  // Bytes a send share hands out, advertised to nearby devices
+	origin    string // Config entry the share was started from, see Manager.SetOrigin
+
+	// The share's own certificate when it is served over HTTPS
+	cert        *tls.Certificate
+	fingerprint string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	server *http.Server
+
+	mu               sync.Mutex
+	downloadLimit    int
+	currentDownloads int
+	expiryTime       time.Time
+	expiryTimer      *time.Timer
+	password         string
+	grants           map[string]bool // Access tokens issued to clients that entered the password
+	allow            []string        // Access rules, see netaccess.List
+	deny             []string
+	access           *netaccess.List
+	rejected         map[string]time.Time // When each turned away address was last reported
+	failures         *ratelimit.Limiter   // Wrong passwords by address
+	paused           bool
+	clipboard        string // Clipboard access granted, see ClipboardRead
+	fairUse          FairUse
+	clientDownloads  map[string]int // Downloads made by each client, see clientKey
+	queue            []*queueEntry  // Downloads waiting for or holding a slot
+	running          int            // Downloads in progress that took a slot
+	clients          map[string]*ClientInfo
+	archivePath      string
+	isTempArchive    bool
+	isCachedArchive  bool
+}
+
+func newSession(id, mode, label string) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Session{
+		ID:        id,
+		Mode:      mode,
+		Label:     label,
+		StartedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		grants:    make(map[string]bool),
+		failures:  ratelimit.New(maxPasswordFailures, failureWindow),
+		fairUse:   FairUse{PerClientBy: ClientByIP},
+		rejected:  make(map[string]time.Time),
+		clients:   make(map[string]*ClientInfo),
+
+		clientDownloads: make(map[string]int),
+	}
+}
+
+// accessCookie holds the token a client receives after entering the password
+const accessCookie = "godrop_access"
+
+const (
+	maxPasswordFailures = 5 // Wrong passwords an address may enter per failureWindow
+	failureWindow       = 15 * time.Minute
+)
+
+// errTooManyFailures turns away an address that entered too many wrong passwords
+var errTooManyFailures = errors.New("Too many wrong passwords, try again later")
+
+// Context is cancelled once the session has been stopped
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// Info returns a snapshot of the session for the frontend
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := SessionInfo{
+		ServerResponse: s.Response,
+		Label:          s.Label,
+		StartedAt:      s.StartedAt.Unix(),
+		DownloadLimit:  s.downloadLimit,
+		Downloads:      s.currentDownloads,
+		HasPassword:    s.password != "",
+		Paused:         s.paused,
+		Clipboard:      s.clipboard,
+		FairUse:        s.fairUse,
+		Queued:         s.queued(),
+		Allow:          s.allow,
+		Deny:           s.deny,
+		Origin:         s.origin,
+	}
+	if !s.expiryTime.IsZero() {
+		info.ExpiryTime = s.expiryTime.Unix()
+	}
+	info.Clients = make([]ClientInfo, 0, len(s.clients))
+	for _, c := range s.clients {
+		info.Clients = append(info.Clients, *c)
+	}
+	sort.Slice(info.Clients, func(i, j int) bool { return info.Clients[i].FirstSeen < info.Clients[j].FirstSeen })
+	return info
+}
+
+// track records a transfer by the client behind r, named device when it is
+// paired. The returned func must be called with the number of bytes moved
+// once the transfer ends.
+func (s *Session) track(r *http.Request, device string) func(int64) {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	now := time.Now().Unix()
+
+	s.mu.Lock()
+	c, ok := s.clients[addr]
+	if !ok {
+		c = &ClientInfo{Addr: addr, FirstSeen: now}
+		s.clients[addr] = c
+	}
+	c.LastSeen = now
+	if device != "" {
+		c.Device = device
+	}
+	c.Active++
+	c.Transfers++
+	s.mu.Unlock()
+
+	return func(n int64) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c.Active--
+		c.Transferred += n
+		c.LastSeen = time.Now().Unix()
+	}
+}
+
+// setExpiry moves the session's expiry to t, or removes it when t is zero,
+// rescheduling onExpire accordingly
+func (s *Session) setExpiry(t time.Time, onExpire func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+		s.expiryTimer = nil
+	}
+	s.expiryTime = t
+	if t.IsZero() {
+		return
+	}
+	s.expiryTimer = time.AfterFunc(time.Until(t), func() {
+		if s.ctx.Err() == nil {
+			onExpire()
+		}
+	})
+}
+
+// unlock checks code against the session password and, when it matches,
+// issues a cookie that authorizes the client's downloads. An address that
+// entered too many wrong passwords gets errTooManyFailures without a check.
+func (s *Session) unlock(w http.ResponseWriter, r *http.Request, code string) (bool, error) {
+	ip := netaccess.ClientIP(r.RemoteAddr)
+	if s.failures.Exceeded(ip) {
+		return false, errTooManyFailures
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.password == "" {
+		return true, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(s.password)) != 1 {
+		s.failures.Add(ip)
+		return false, nil
+	}
+	token := randomToken()
+	s.grants[token] = true
+	http.SetCookie(w, &http.Cookie{Name: accessCookie, Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	return true, nil
+}
+
+// unlockHandler answers a password form with {"success": ...}, or 429 and an
+// error once the address entered too many wrong passwords. While enabled
+// reports false, every code is wrong.
+func (s *Session) unlockHandler(enabled func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Code string }
+		json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body)
+		var ok bool
+		var err error
+		if enabled() {
+			ok, err = s.unlock(w, r, body.Code)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": ok})
+	}
+}
+
+// authorized reports whether r may download, either because the share has no
+// password or because it carries a token issued by unlock
+func (s *Session) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.password == "" {
+		return true
+	}
+	c, err := r.Cookie(accessCookie)
+	return err == nil && s.grants[c.Value]
+}
+
+// unlocked reports whether r carries a token issued by unlock. Unlike
+// authorized, a share without a password unlocks nothing.
+func (s *Session) unlocked(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, err := r.Cookie(accessCookie)
+	return s.password != "" && err == nil && s.grants[c.Value]
+}
+
+// clipboardAccess returns the clipboard access the share grants
+func (s *Session) clipboardAccess() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clipboard
+}
+
+// setPassword replaces the password, or removes it when empty. Clients that
+// unlocked the old password have to enter the new one.
+func (s *Session) setPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+	s.grants = make(map[string]bool)
+}
+
+// setPaused makes the share temporarily unavailable without closing it
+func (s *Session) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+func (s *Session) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// reserveDownload claims a download for client, returning the download
+// number and the limit it was claimed against
+func (s *Session) reserveDownload(client string) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return 0, 0, fmt.Errorf("Share Closing")
+	}
+	if !s.expiryTime.IsZero() && time.Now().After(s.expiryTime) {
+		return 0, 0, fmt.Errorf("Link Expired")
+	}
+	if s.downloadLimit > 0 && s.currentDownloads >= s.downloadLimit {
+		return 0, 0, fmt.Errorf("Limit Exceeded")
+	}
+	if s.fairUse.PerClient > 0 && s.clientDownloads[client] >= s.fairUse.PerClient {
+		return 0, 0, errClientLimit
+	}
+	s.clientDownloads[client]++
+	s.currentDownloads++
+	return s.currentDownloads, s.downloadLimit, nil
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// shutdown cancels the session so no new downloads start, waits up to grace
+// for transfers in progress, then closes its server and removes any archive
+// it owns
+func (s *Session) shutdown(core *backend.Core, grace time.Duration) {
+	s.cancel()
+	if s.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		if err := s.server.Shutdown(ctx); err != nil {
+			s.server.Close()
+		}
+		cancel()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+	}
+	if s.isTempArchive && s.archivePath != "" {
+		os.Remove(s.archivePath)
+		s.isTempArchive = false
+	}
+	if s.isCachedArchive && s.archivePath != "" {
+		core.ReleaseArchive(s.archivePath)
+		s.isCachedArchive = false
+	}
+}
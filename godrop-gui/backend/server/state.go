@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SavedShare is a share as recorded in the state file, with enough detail to
+// start it again after a restart
+type SavedShare struct {
+	ID         string   `json:"id"`
+	Mode       string   `json:"mode"`
+	Port       string   `json:"port"`
+	Password   string   `json:"password,omitempty"`
+	Files      []string `json:"files,omitempty"`
+	SaveDir    string   `json:"saveDir,omitempty"`
+	Extract    bool     `json:"extract,omitempty"`
+	EncryptTo  string   `json:"encryptTo,omitempty"` // Never the passphrase itself, see atrest.Recipient
+	Limit      int      `json:"limit"`
+	Downloads  int      `json:"downloads"`
+	ExpiryTime int64    `json:"expiryTime"` // Unix seconds, 0 when the share never expires
+	Paused     bool     `json:"paused,omitempty"`
+	Clipboard  string   `json:"clipboard,omitempty"`
+	FairUse
+	Allow []string `json:"allow"` // Missing in older state files, which then get the default rules
+	Deny  []string `json:"deny,omitempty"`
+
+	ClientDownloads map[string]int `json:"clientDownloads,omitempty"` // Per-client download counts, see Session.clientKey
+	Origin          string         `json:"origin,omitempty"`          // See Manager.SetOrigin
+}
+
+// saved records the session for the state file
+func (s *Session) saved() SavedShare {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	share := SavedShare{
+		ID:        s.ID,
+		Mode:      s.Mode,
+		Port:      s.port,
+		Password:  s.password,
+		Files:     s.files,
+		SaveDir:   s.saveDir,
+		Extract:   s.extract,
+		EncryptTo: s.encryptTo,
+		Limit:     s.downloadLimit,
+		Downloads: s.currentDownloads,
+		Paused:    s.paused,
+		Clipboard: s.clipboard,
+		FairUse:   s.fairUse,
+		Allow:     s.allow,
+		Deny:      s.deny,
+		Origin:    s.origin,
+	}
+	if !s.expiryTime.IsZero() {
+		share.ExpiryTime = s.expiryTime.Unix()
+	}
+	for client, n := range s.clientDownloads {
+		// Password tokens don't outlive the process, so their counts are
+		// of no use after a restart
+		if strings.HasPrefix(client, "token:") {
+			continue
+		}
+		if share.ClientDownloads == nil {
+			share.ClientDownloads = make(map[string]int)
+		}
+		share.ClientDownloads[client] = n
+	}
+	return share
+}
+
+// SetStateFile makes the manager record every running share in path, so that
+// Restore can bring them back after a restart
+func (m *Manager) SetStateFile(path string) {
+	m.mu.Lock()
+	m.statePath = path
+	m.mu.Unlock()
+}
+
+// persist writes the running shares to the state file, if one is set, oldest
+// first. Shares stopped by StopAll are kept, since that is how the process
+// exits.
+func (m *Manager) persist() {
+	m.mu.Lock()
+	path := m.statePath
+	m.mu.Unlock()
+	if path == "" {
+		return
+	}
+
+	sessions := m.sortedSessions()
+	shares := make([]SavedShare, 0, len(sessions))
+	for _, sess := range sessions {
+		shares = append(shares, sess.saved())
+	}
+
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	if err := writeState(path, shares); err != nil {
+		m.emit("server_error", fmt.Sprintf("Could not save state: %v", err))
+	}
+}
+
+// writeState replaces the state file atomically. It may hold passwords, so it
+// is readable by the owner only.
+func writeState(path string, shares []SavedShare) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Restore starts the shares recorded in the state file again, keeping their
+// download counts, expiry and pause state. Expired or used-up shares are
+// dropped. A missing state file is not an error.
+func (m *Manager) Restore() ([]SessionInfo, error) {
+	m.mu.Lock()
+	path := m.statePath
+	m.statePath = "" // Hold off saving until every share is back
+	m.mu.Unlock()
+	defer func() {
+		m.SetStateFile(path)
+		m.persist()
+	}()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var shares []SavedShare
+	if err := json.Unmarshal(data, &shares); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+
+	// Shares keep their IDs, so new ones are numbered after them
+	m.mu.Lock()
+	for _, share := range shares {
+		if i := strings.LastIndexByte(share.ID, '-'); i >= 0 {
+			if n, err := strconv.Atoi(share.ID[i+1:]); err == nil && n > m.nextID {
+				m.nextID = n
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	var restored []SessionInfo
+	for _, share := range shares {
+		if share.ExpiryTime > 0 && time.Now().Unix() >= share.ExpiryTime {
+			continue
+		}
+		if share.Limit > 0 && share.Downloads >= share.Limit {
+			continue
+		}
+
+		info, err := m.restoreShare(share)
+		if err != nil {
+			m.emit("server_error", fmt.Sprintf("Could not restore %s: %v", share.ID, err))
+			continue
+		}
+		restored = append(restored, info)
+	}
+	return restored, nil
+}
+
+func (m *Manager) restoreShare(share SavedShare) (SessionInfo, error) {
+	switch share.Mode {
+	case "send":
+		return m.send(share.Port, share.Password, share.Files, share.Limit, 0, &share)
+	case "receive":
+		return m.startReceive(share.Port, share.SaveDir, share.Extract, share.EncryptTo, &share)
+	case "clipboard":
+		return m.startClipboard(share.Port, share.Password, share.Clipboard, &share)
+	}
+	return SessionInfo{}, fmt.Errorf("unknown share mode: %q", share.Mode)
+}
+
+// SetOrigin tags a share with the config entry it was started from. The tag
+// is saved with it, so that after a restart the entry is known to be running
+// already.
+func (m *Manager) SetOrigin(id string, origin string) (SessionInfo, error) {
+	sess, ok := m.Get(id)
+	if !ok {
+		return SessionInfo{}, fmt.Errorf("no such session: %s", id)
+	}
+	sess.mu.Lock()
+	sess.origin = origin
+	sess.mu.Unlock()
+	m.persist()
+	return sess.Info(), nil
+}
+
+// restore puts back what share recorded, before the session starts
+// listening. Its expiry timer is armed once it is registered.
+func (s *Session) restore(share SavedShare) error {
+	if s.Mode == "send" {
+		if err := s.setFairUse(share.FairUse); err != nil {
+			return err
+		}
+	}
+	if share.Allow != nil {
+		if err := s.setAccess(share.Allow, share.Deny); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentDownloads = share.Downloads
+	for client, n := range share.ClientDownloads {
+		s.clientDownloads[client] = n
+	}
+	s.paused = share.Paused
+	s.password = share.Password
+	s.origin = share.Origin
+	if share.Clipboard != "" {
+		s.clipboard = share.Clipboard
+	}
+	if share.ExpiryTime > 0 {
+		s.expiryTime = time.Unix(share.ExpiryTime, 0)
+	}
+	return nil
+}
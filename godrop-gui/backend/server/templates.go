@@ -1,7 +1,9 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
+	"html"
 	"strings"
 )
 
@@ -76,7 +78,7 @@ button:hover, .btn:hover { background: var(--accent); transform: scale(1.02); }
 button:active, .btn:active { transform: scale(0.98); }
 
 input[type="file"] { margin: 20px 0; font-size: 0.9rem; width: 100%; }
-input[type="password"], textarea {
+input[type="password"], input[type="text"], textarea {
   width: 100%;
   padding: 12px;
   background: #F0F0F0;
@@ -113,8 +115,110 @@ func baseLayout(title, content string) string {
 </html>`, title, sharedCSS, content)
 }
 
-// GetSendTemplate renders the Send landing page
-func GetSendTemplate(fileName, fileSize string, hasPassword bool) string {
+// liveReloadScript reloads the landing page when the share's password,
+// limit, expiry or paused state changes
+const liveReloadScript = `
+	<script>
+		let lastState = null;
+		setInterval(async () => {
+			if (window.queued) return; // Keeps the queue position on screen
+			try {
+				const j = await (await fetch('/api/info')).json();
+				const state = JSON.stringify([j.password, j.paused, j.limit, j.downloads, j.expiryTime]);
+				if (lastState !== null && state !== lastState) window.location.reload();
+				lastState = state;
+			} catch (e) {}
+		}, 5000);
+	</script>
+`
+
+// identityScript keeps a paired device's ID and private key in IndexedDB. The
+// key is made non-extractable, so not even this page can read it back.
+const identityScript = `
+	<script>
+		function identityStore(mode, fn) {
+			return new Promise((resolve, reject) => {
+				const open = indexedDB.open('godrop', 1);
+				open.onupgradeneeded = () => open.result.createObjectStore('identity');
+				open.onerror = () => reject(open.error);
+				open.onsuccess = () => {
+					const tx = open.result.transaction('identity', mode);
+					const req = fn(tx.objectStore('identity'));
+					tx.oncomplete = () => resolve(req.result);
+					tx.onerror = () => reject(tx.error);
+				};
+			});
+		}
+		const toBase64 = (buf) => btoa(String.fromCharCode(...new Uint8Array(buf)));
+	</script>
+`
+
+// deviceLoginScript logs a paired device in by signing a challenge, then
+// reloads the page, which now knows the device
+const deviceLoginScript = identityScript + `
+	<script>
+		(async () => {
+			if (!window.isSecureContext || !window.indexedDB) return;
+			try {
+				const identity = await identityStore('readonly', s => s.get('device'));
+				if (!identity) return;
+				const { challenge } = await (await fetch('/api/device/challenge')).json();
+				const signature = await crypto.subtle.sign({ name: 'ECDSA', hash: 'SHA-256' }, identity.key, new TextEncoder().encode('godrop login ' + challenge));
+				const r = await fetch('/api/device/login', { method: 'POST', body: JSON.stringify({ id: identity.id, challenge, signature: toBase64(signature) }) });
+				if (r.ok) window.location.reload();
+				else if ((await r.text()).includes('unknown device')) await identityStore('readwrite', s => s.delete('device')); // Revoked
+			} catch (e) {}
+		})();
+	</script>
+`
+
+// pairedItem shows which paired device is looking at the page
+func pairedItem(device string) string {
+	return fmt.Sprintf(`
+			<div class="info-item">
+				<div class="info-label">PAIRED DEVICE</div>
+				<div class="info-value">📱 %s</div>
+			</div>`, html.EscapeString(device))
+}
+
+// queueScript starts downloads through the share's queue, showing the
+// page's place in it while the share is at its concurrent download limit
+const queueScript = `
+	<div id="queue" style="color:var(--accent); font-size:0.8rem; margin-bottom:10px; font-weight:800;"></div>
+	<script>
+		async function startDownload() {
+			const show = (text) => document.getElementById('queue').innerText = text;
+			window.queued = true;
+			try {
+				let q = null;
+				while (!q || q.position > 0) {
+					if (q) {
+						show('QUEUE POSITION ' + q.position + ' • YOUR DOWNLOAD STARTS BY ITSELF');
+						await new Promise(done => setTimeout(done, 2000));
+					}
+					const r = q ? await fetch('/api/queue?ticket=' + q.ticket) : await fetch('/api/queue', {method: 'POST'});
+					if (r.status === 404) { q = null; continue; } // Lost the place; queue again
+					if (!r.ok) { show((await r.text()).toUpperCase()); window.queued = false; return false; }
+					q = await r.json();
+				}
+				show('');
+				window.location.href = '/download?ticket=' + q.ticket;
+			} catch (e) {
+				window.location.href = '/download';
+			}
+			window.queued = false;
+			return false;
+		}
+	</script>
+`
+
+// GetSendTemplate renders the Send landing page. A paired device, named by
+// device, downloads without the password.
+func GetSendTemplate(fileName, fileSize string, hasPassword bool, downloadsLeft, expires string, device string) string {
+	paired := ""
+	if device != "" {
+		paired = pairedItem(device)
+	}
 	content := fmt.Sprintf(`
 		<p>Sharing a file with you at light speed.</p>
 		<div class="info-card">
@@ -126,10 +230,23 @@ func GetSendTemplate(fileName, fileSize string, hasPassword bool) string {
 				<div class="info-label">SIZE</div>
 				<div class="info-value">%s</div>
 			</div>
+			<div class="info-item">
+				<div class="info-label">DOWNLOADS LEFT</div>
+				<div class="info-value">%s</div>
+			</div>
+			<div class="info-item">
+				<div class="info-label">EXPIRES</div>
+				<div class="info-value">%s</div>
+			</div>%s
 		</div>
-	`, fileName, fileSize)
+	`, html.EscapeString(fileName), fileSize, downloadsLeft, expires, paired)
+	content += liveReloadScript
 
-	if hasPassword {
+	if device == "" {
+		content += deviceLoginScript
+	}
+	content += queueScript
+	if hasPassword && device == "" {
 		content += `
 			<input type="password" id="pass" placeholder="ENTER PASSWORD">
 			<div id="msg" style="color:var(--accent-bright); font-size:0.7rem; margin-bottom:10px; font-weight:700;"></div>
@@ -139,22 +256,48 @@ func GetSendTemplate(fileName, fileSize string, hasPassword bool) string {
 					const c = document.getElementById('pass').value;
 					const r = await fetch('/api/verify', {method:'POST', body:JSON.stringify({Code:c})});
 					const j = await r.json();
-					if(j.success) window.location.href='/download';
-					else document.getElementById('msg').innerText = "ACCESS DENIED";
+					if(j.success) startDownload();
+					else document.getElementById('msg').innerText = j.error || "ACCESS DENIED";
 				}
 			</script>
 		`
 	} else {
-		content += `<a href="/download" class="btn">DOWNLOAD NOW</a>`
+		content += `<a href="/download" class="btn" onclick="return startDownload()">DOWNLOAD NOW</a>`
 	}
 
 	return baseLayout("Download", content)
 }
 
-// GetReceiveTemplate renders the Receive landing page
-func GetReceiveTemplate() string {
+// GetPausedTemplate renders the page shown while a share is paused
+func GetPausedTemplate() string {
+	content := `
+		<p>This share is paused by its owner. The page will refresh once it is available again.</p>
+		<div class="info-card" style="text-align:center;">
+			<div class="info-value">⏸ PAUSED</div>
+		</div>
+		<script>
+			setInterval(async () => {
+				try {
+					const j = await (await fetch('/api/status')).json();
+					if (!j.paused) window.location.reload();
+				} catch (e) {}
+			}, 5000);
+		</script>
+	`
+	return baseLayout("Paused", content)
+}
+
+// GetReceiveTemplate renders the Receive landing page, naming the paired
+// device that opened it, if any
+func GetReceiveTemplate(device string) string {
 	content := `
-		<p>Ready to receive. Drop your file into the box below to send it to the PC.</p>
+		<p>Ready to receive. Drop your file into the box below to send it to the PC.</p>`
+	if device != "" {
+		content += `<div class="info-card">` + pairedItem(device) + `</div>`
+	} else {
+		content += deviceLoginScript
+	}
+	content += `
 		<form action="/upload" method="post" enctype="multipart/form-data">
 			<div class="info-card" style="text-align:center;">
 				<input type="file" name="file" required id="file-input">
@@ -166,24 +309,110 @@ func GetReceiveTemplate() string {
 	return baseLayout("Receive", content)
 }
 
-// GetClipboardTemplate renders the Clipboard landing page
-func GetClipboardTemplate(history []string) string {
+// GetPairTemplate renders the page a pairing link opens. It makes the
+// device's key pair, sends the public key with the pairing token from the
+// link, and keeps the private key in the browser.
+func GetPairTemplate() string {
+	content := `
+		<p>Pair this device with the computer. Its shares will then know it by name and won't ask it for passwords.</p>
+		<div id="form">
+			<input type="text" id="name" placeholder="DEVICE NAME" maxlength="64">
+			<div id="msg" style="color:var(--accent-bright); font-size:0.7rem; margin-bottom:10px; font-weight:700;"></div>
+			<button onclick="pair()">PAIR THIS DEVICE</button>
+		</div>
+		<div id="done" class="info-card" style="display:none; text-align:center;">
+			<div class="info-value" id="paired"></div>
+		</div>
+	` + identityScript + `
+	<script>
+		const token = new URLSearchParams(location.hash.slice(1)).get('token');
+		const guess = /iPhone|iPad|Android|Macintosh|Windows|Linux/.exec(navigator.userAgent);
+		document.getElementById('name').value = guess ? (guess[0] === 'Macintosh' ? 'Mac' : guess[0]) : '';
+		const msg = (text) => document.getElementById('msg').innerText = text;
+
+		async function pair() {
+			if (!window.isSecureContext || !window.indexedDB) return msg('PAIRING NEEDS HTTPS');
+			if (!token) return msg('THIS PAIRING LINK IS INCOMPLETE');
+			try {
+				const keys = await crypto.subtle.generateKey({ name: 'ECDSA', namedCurve: 'P-256' }, false, ['sign', 'verify']);
+				const publicKey = toBase64(await crypto.subtle.exportKey('spki', keys.publicKey));
+				const name = document.getElementById('name').value;
+				const r = await fetch('/api/pair', { method: 'POST', body: JSON.stringify({ token, name, publicKey }) });
+				if (!r.ok) return msg((await r.text()).toUpperCase());
+				const device = await r.json();
+				await identityStore('readwrite', s => s.put({ id: device.id, name: device.name, key: keys.privateKey }, 'device'));
+				history.replaceState(null, '', '/pair');
+				document.getElementById('form').style.display = 'none';
+				document.getElementById('done').style.display = 'block';
+				document.getElementById('paired').innerText = '📱 PAIRED AS ' + device.name;
+			} catch (e) {
+				msg('PAIRING FAILED: ' + e.message);
+			}
+		}
+	</script>
+	`
+	return baseLayout("Pair", content)
+}
+
+// GetClipboardLockedTemplate renders the page asking for the share's password
+// before the clipboard is shown. Paired devices sign in instead.
+func GetClipboardLockedTemplate(hasPassword bool) string {
+	content := `
+		<p>This clipboard is private. Enter the share's password, or open it on a paired device.</p>` + deviceLoginScript
+	if hasPassword {
+		content += `
+			<input type="password" id="pass" placeholder="ENTER PASSWORD">
+			<div id="msg" style="color:var(--accent-bright); font-size:0.7rem; margin-bottom:10px; font-weight:700;"></div>
+			<button onclick="verify()">UNLOCK CLIPBOARD</button>
+			<script>
+				async function verify() {
+					const c = document.getElementById('pass').value;
+					const r = await fetch('/clipboard/unlock', {method:'POST', body:JSON.stringify({Code:c})});
+					const j = await r.json();
+					if(j.success) window.location.reload();
+					else document.getElementById('msg').innerText = j.error || "ACCESS DENIED";
+				}
+			</script>
+		`
+	}
+	return baseLayout("Clipboard", content)
+}
+
+// GetClipboardTemplate renders the Clipboard landing page. The form that
+// sends text to the PC is left out unless writable; device names the paired
+// device looking at it, if any.
+func GetClipboardTemplate(history []string, writable bool, device string) string {
 	var cardsHTML strings.Builder
 	for i, item := range history {
 		displayItem := item
 		if len(displayItem) > 200 {
 			displayItem = displayItem[:197] + "..."
 		}
-		// Escape backticks for JS
-		safeItem := strings.ReplaceAll(item, "`", "\\`")
 
 		cardsHTML.WriteString(fmt.Sprintf(`
-			<div class="clipboard-card" onclick="copyToPhone(`+"`"+`%s`+"`"+`, this)">
+			<div class="clipboard-card" onclick="copyToPhone(%d, this)">
 				<div class="card-header">ITEM #%d</div>
 				<div class="card-content">%s</div>
 				<div class="card-action">CLICK TO COPY</div>
 			</div>
-		`, safeItem, len(history)-i, displayItem))
+		`, i, len(history)-i, html.EscapeString(displayItem)))
+	}
+	if history == nil {
+		history = []string{}
+	}
+	// Marshal escapes <, > and &, so the items can't end the script
+	items, _ := json.Marshal(history)
+
+	form := `<p class="info-label">READ-ONLY: THIS CLIPBOARD CAN'T BE CHANGED FROM HERE</p>`
+	if writable {
+		form = `
+			<form action="/clipboard" method="POST" style="display:flex; flex-direction:column;">
+				<textarea name="text" id="txt" rows="3" placeholder="Type here to add to history..."></textarea>
+				<button type="submit">SEND TO PC</button>
+			</form>`
+	}
+	if device != "" {
+		form += `<div class="info-card">` + pairedItem(device) + `</div>`
 	}
 
 	content := fmt.Sprintf(`
@@ -210,11 +439,7 @@ func GetClipboardTemplate(history []string) string {
 		</style>
 		<p>Real-time clipboard synchronization.</p>
 		
-		<div class="add-section">
-			<form action="/clipboard" method="POST" style="display:flex; flex-direction:column;">
-				<textarea name="text" id="txt" rows="3" placeholder="Type here to add to history..."></textarea>
-				<button type="submit">SEND TO PC</button>
-			</form>
+		<div class="add-section">%s
 		</div>
 
 		<div class="history-container">
@@ -226,10 +451,11 @@ func GetClipboardTemplate(history []string) string {
 
 		<script>
 			const txt = document.getElementById('txt');
-			let lastItem = `+"`"+`%s`+"`"+`;
-			
+			const items = %s;
+			const lastItem = items.length > 0 ? items[0] : '';
+
 			setInterval(async () => {
-				if (document.activeElement === txt) return;
+				if (txt && document.activeElement === txt) return;
 				try {
 					const r = await fetch('/clipboard-history');
 					const history = await r.json();
@@ -239,8 +465,8 @@ func GetClipboardTemplate(history []string) string {
 				} catch(e) {}
 			}, 3000);
 
-			function copyToPhone(text, el) {
-				navigator.clipboard.writeText(text);
+			function copyToPhone(i, el) {
+				navigator.clipboard.writeText(items[i]);
 				const action = el.querySelector('.card-action');
 				const old = action.innerText;
 				action.innerText = "COPIED!";
@@ -251,11 +477,6 @@ func GetClipboardTemplate(history []string) string {
 				}, 1500);
 			}
 		</script>
-	`, cardsHTML.String(), func() string {
-		if len(history) > 0 {
-			return strings.ReplaceAll(history[0], "`", "\\`")
-		}
-		return ""
-	}())
+	`, form, cardsHTML.String(), items)
 	return baseLayout("Clipboard", content)
 }
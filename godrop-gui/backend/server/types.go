@@ -1,26 +1,72 @@
 package server
 
-import (
-	"context"
-	"net/http"
-	"sync"
-	"time"
-)
-
 // ServerResponse returns to the frontend
 type ServerResponse struct {
+	ID      string `json:"id"`
+	Mode    string `json:"mode"`
 	IP      string `json:"ip"`
 	Port    string `json:"port"`
 	FullURL string `json:"fullUrl"`
 	QRCode  string `json:"qrCode"` // Base64 encoded PNG
+
+	// Fingerprint is the SHA-256 hash of the share's certificate when it is
+	// served over HTTPS, empty otherwise
+	Fingerprint string `json:"fingerprint"`
+
+	// Addresses lists every address the share is reachable on, the one in
+	// FullURL first
+	Addresses []ShareAddress `json:"addresses"`
+}
+
+// ShareAddress is one URL a share can be reached at
+type ShareAddress struct {
+	Interface string `json:"interface"`
+	IP        string `json:"ip"`
+	URL       string `json:"url"`
+	QRCode    string `json:"qrCode"`
+}
+
+// SessionInfo describes a running share for the session list
+type SessionInfo struct {
+	ServerResponse
+	Label         string `json:"label"`
+	StartedAt     int64  `json:"startedAt"`
+	ExpiryTime    int64  `json:"expiryTime"` // Unix seconds, 0 when the share never expires
+	DownloadLimit int    `json:"downloadLimit"`
+	Downloads     int    `json:"downloads"`
+	HasPassword   bool   `json:"hasPassword"`
+	Paused        bool   `json:"paused"`
+	Clipboard     string `json:"clipboard"` // Clipboard access: "", "read" or "readwrite"
+	FairUse
+	Queued  int          `json:"queued"` // Downloads waiting for a slot
+	Allow   []string     `json:"allow"`  // Access rules; an empty allow list admits everyone
+	Deny    []string     `json:"deny"`
+	Clients []ClientInfo `json:"clients"`
+	Origin  string       `json:"origin,omitempty"` // Config entry the share was started from, see Manager.SetOrigin
+}
+
+// AccessRules are the allow and deny rules of a share, see netaccess.List
+type AccessRules struct {
+	Allow []string `json:"allow"` // An empty list admits everyone not denied
+	Deny  []string `json:"deny"`
+}
+
+// ClientInfo describes a device that has used a share
+type ClientInfo struct {
+	Addr        string `json:"addr"`
+	Device      string `json:"device"` // Name of the paired device, empty for anyone else
+	FirstSeen   int64  `json:"firstSeen"`
+	LastSeen    int64  `json:"lastSeen"`
+	Active      int    `json:"active"` // Transfers currently in progress
+	Transfers   int    `json:"transfers"`
+	Transferred int64  `json:"transferred"`
 }
 
-// Dedicated context for server state management
-type ServerState struct {
-	Server           *http.Server
-	Mutex            sync.Mutex
-	DownloadLimit    int
-	CurrentDownloads int
-	ExpiryTime       time.Time
-	Ctx              context.Context
+// SendPayload is the single file a send server hands out
+type SendPayload struct {
+	FilePath string
+	FileName string
+	Files    []string // The selection the payload was prepared from
+	IsTemp   bool     // The file is a temporary archive and is removed on Stop
+	IsCached bool     // The file is owned by the archive cache and released on Stop
 }
@@ -1,14 +1,15 @@
 import { useState, useEffect } from 'react';
 import './App.css';
 import logo from './assets/images/godrop-logo.png';
-import { GetHomeDir, ReadDir, StartServer, StopServer, StartReceiveServer, StartClipboardServer, GetDefaultSaveDir, GetSystemClipboard, GetHistory, SetSystemClipboard } from '../wailsjs/go/main/App';
-import { EventsOn } from '../wailsjs/runtime/runtime';
+import { GetHomeDir, ReadDir, StartServer, StopServer, ListSessions, StartAdminConsole, ExtendSession, SetSessionLimit, SetSessionPassword, PauseSession, ResumeSession, ListInterfaces, SetInterface, GetBind, SetBind, GetTLS, SetTLS, GetAccess, SetAccess, SetSessionAccess, CancelArchive, StartReceiveServer, StartClipboardServer, SetSessionClipboard, SetSessionFairUse, GetDefaultSaveDir, GetSystemClipboard, GetHistory, SetSystemClipboard, SelectFiles, DecryptFiles, PairDevice, ListDevices, RevokeDevice } from '../wailsjs/go/main/App';
+import { EventsOn, BrowserOpenURL } from '../wailsjs/runtime/runtime';
 
 // Components
 import { Explorer } from './components/Explorer/Explorer';
 import { ConfigPanel } from './components/Config/ConfigPanel';
 import { ServerOverlay } from './components/Server/ServerOverlay';
 import { ClipboardCard } from './components/Clipboard/ClipboardCard';
+import { NearbyPanel } from './components/Nearby/NearbyPanel';
 
 function App() {
     // Explorer State
@@ -17,21 +18,34 @@ function App() {
     const [selectedFiles, setSelectedFiles] = useState([]);
 
     // Server State
-    const [mode, setMode] = useState('send'); // 'send' | 'receive' | 'clipboard'
+    const [mode, setMode] = useState('send'); // 'send' | 'receive' | 'clipboard' | 'nearby'
     const [password, setPassword] = useState("");
     const [port, setPort] = useState("1111");
     const [limit, setLimit] = useState(1);
     const [timeout, setTimeoutVal] = useState(10);
     const [saveLocation, setSaveLocation] = useState("");
+    const [autoExtract, setAutoExtract] = useState(false);
+    const [encryptTo, setEncryptTo] = useState(""); // Passphrase or godrop-pub-... key, empty to store files as sent
+    const [fairUse, setFairUse] = useState({ perClient: 0, perClientBy: "ip", maxConcurrent: 0, rateLimit: 0 }); // Zero is no limit
+    const [clipboardAccess, setClipboardAccess] = useState(""); // "", "read" or "readwrite"; clipboard shares default to readwrite
 
     // UI State
-    const [isServerRunning, setIsServerRunning] = useState(false);
-    const [serverInfo, setServerInfo] = useState(null);
-    const [progress, setProgress] = useState(null);
+    const [sessions, setSessions] = useState([]);
+    const [activeSessionId, setActiveSessionId] = useState(null);
+    const [progressBySession, setProgressBySession] = useState({});
+    const [archiveProgress, setArchiveProgress] = useState(null);
     const [logs, setLogs] = useState([]);
+    const [consoleInfo, setConsoleInfo] = useState(null);
+    const [interfaces, setInterfaces] = useState([]);
+    const [selectedInterface, setSelectedInterface] = useState("");
+    const [bind, setBindState] = useState("all");
+    const [secure, setSecureState] = useState(false);
+    const [access, setAccessState] = useState({ allow: "subnet", deny: "" }); // Comma separated rules
     const [clipboardText, setClipboardText] = useState("");
     const [clipboardHistory, setClipboardHistory] = useState([]);
     const [receivedFiles, setReceivedFiles] = useState([]);
+    const [devices, setDevices] = useState([]);
+    const [pairing, setPairing] = useState(null); // Pairing link shown for a share
 
     // Initial Load
     useEffect(() => {
@@ -41,33 +55,58 @@ function App() {
             const defaultSave = await GetDefaultSaveDir();
             setSaveLocation(defaultSave);
 
+            setInterfaces(await ListInterfaces() || []);
+            setBindState(await GetBind() || "all");
+            setSecureState(await GetTLS());
+            const rules = await GetAccess();
+            setAccessState({ allow: (rules.allow || []).join(", "), deny: (rules.deny || []).join(", ") });
+            setDevices(await ListDevices() || []);
+
             // Initial clipboard history load
             const history = await GetHistory();
             setClipboardHistory(history);
         };
         init();
 
-        const onDownloadStarted = (data) => addLog(`Download started from ${data.ip}`);
-        const onFileReceived = (filename) => {
+        const onDownloadStarted = (data) => addLog(`Download started from ${data.device ? `📱 ${data.device}` : data.ip}`);
+        const onFileReceived = (filename, session, device) => {
             setReceivedFiles(prev => {
                 // Robust deduplication: check if this filename already exists in the current session list
                 if (prev.some(f => f.name === filename)) return prev;
-                addLog(`RECEIVED: ${filename}`);
+                addLog(`RECEIVED: ${filename}${device ? ` FROM 📱 ${device}` : ''}`);
                 return [
                     { name: filename, timestamp: new Date().toLocaleTimeString(), status: 'completed' },
                     ...prev
                 ];
             });
         };
+        const onArchiveExtracted = (result) => addLog(`EXTRACTED ${result.files.length} FILES -> ${result.dir}`);
+        const onExtractError = (data) => addLog(`EXTRACT FAILED: ${data.archive} (${data.error})`);
         const onServerError = (err) => addLog(`ERROR: ${err}`);
-        const onServerStopped = () => {
-            addLog("Server stopped.");
-            setIsServerRunning(false);
-            setServerInfo(null);
-            setProgress(null);
-            setReceivedFiles([]);
+        const onServerStopped = (id) => {
+            addLog(`Share ${id} stopped.`);
+            setActiveSessionId(prev => prev === id ? null : prev);
+            setProgressBySession(prev => {
+                const { [id]: _, ...rest } = prev;
+                return rest;
+            });
+            refreshSessions();
+        };
+        const onSessionUpdated = () => refreshSessions();
+        const onTransferProgress = (data) => {
+            setProgressBySession(prev => ({ ...prev, [data.session]: data }));
+            refreshSessions();
         };
-        const onTransferProgress = (data) => setProgress(data);
+        const onArchiveProgress = (data) => setArchiveProgress(data);
+        const onArchiveError = (failure) => addLog(`SKIPPED: ${failure.path} (${failure.error})`);
+        const onArchiveCancelled = () => addLog("Packaging cancelled.");
+        const onDevicePaired = (data) => {
+            addLog(`PAIRED: 📱 ${data.device} (${data.ip})`);
+            setPairing(null);
+            ListDevices().then(list => setDevices(list || []));
+        };
+        const onDeviceLogin = (data) => addLog(`📱 ${data.device} SIGNED IN (${data.ip})`);
+        const onAccessDenied = (data) => addLog(`BLOCKED: ${data.ip} (${data.path})`);
         const onClipboardChanged = (text) => {
             setClipboardHistory(prev => {
                 if (prev[0] === text) return prev;
@@ -79,10 +118,19 @@ function App() {
         const events = {
             "download_started": onDownloadStarted,
             "file-received": onFileReceived,
+            "archive-extracted": onArchiveExtracted,
+            "extract-error": onExtractError,
             "server_error": onServerError,
             "server_stopped": onServerStopped,
+            "session_updated": onSessionUpdated,
             "transfer-progress": onTransferProgress,
-            "clipboard-changed": onClipboardChanged
+            "archive-progress": onArchiveProgress,
+            "archive-error": onArchiveError,
+            "archive-cancelled": onArchiveCancelled,
+            "clipboard-changed": onClipboardChanged,
+            "device_paired": onDevicePaired,
+            "device_login": onDeviceLogin,
+            "access_denied": onAccessDenied
         };
 
         Object.entries(events).forEach(([name, fn]) => EventsOn(name, fn));
@@ -97,6 +145,10 @@ function App() {
         };
     }, []);
 
+    const serverInfo = sessions.find(s => s.id === activeSessionId) || null;
+    const isServerRunning = serverInfo !== null;
+    const progress = serverInfo ? progressBySession[serverInfo.id] : null;
+
     // Load directory when in receive mode to ensure saveLocation is ready
     useEffect(() => {
         if (mode === 'receive' && !isServerRunning) {
@@ -148,31 +200,159 @@ function App() {
 
         setLogs([`INITIALIZING ${mode.toUpperCase()}...`]);
         try {
+            // Running shares keep their rules; these apply to the new one
+            await SetAccess(splitRules(access.allow), splitRules(access.deny));
             let info;
             if (mode === 'send') {
                 info = await StartServer(port, password, selectedFiles, limit, timeout);
+                if (fairUse.perClient || fairUse.maxConcurrent || fairUse.rateLimit) {
+                    info = await SetSessionFairUse(info.id, fairUse);
+                }
                 addLog(`BROADCASTING ${selectedFiles.length} FILES`);
             } else if (mode === 'receive') {
-                info = await StartReceiveServer(port, saveLocation);
+                info = await StartReceiveServer(port, saveLocation, autoExtract && !encryptTo, encryptTo);
+                // Receive shares don't ask for it otherwise; it only guards the clipboard
+                if (clipboardAccess && password) info = await SetSessionPassword(info.id, password);
                 addLog(`DROPZONE ACTIVE -> ${saveLocation}`);
             } else {
-                info = await StartClipboardServer(port);
+                info = await StartClipboardServer(port, password, clipboardAccess);
                 addLog(`CLIPBOARD SYNC ACTIVE`);
             }
-            setServerInfo(info);
+            if (clipboardAccess && mode !== 'clipboard') {
+                try {
+                    info = await SetSessionClipboard(info.id, clipboardAccess);
+                    addLog(`CLIPBOARD SHARED (${clipboardAccess.toUpperCase()})`);
+                } catch (err) {
+                    addLog(`CLIPBOARD NOT SHARED: ${err}`);
+                }
+            }
             setPort(info.port);
-            setIsServerRunning(true);
+            setReceivedFiles([]);
+            await refreshSessions();
+            setActiveSessionId(info.id);
         } catch (err) {
             addLog(`STARTUP FAILED: ${err}`);
+        } finally {
+            setArchiveProgress(null);
+        }
+    };
+
+    const handleDecryptFiles = async () => {
+        const files = await SelectFiles();
+        if (!files || files.length === 0) return;
+        const secret = window.prompt('Passphrase or secret key (godrop-key-...):');
+        if (!secret) return;
+        try {
+            const opened = await DecryptFiles(files, secret);
+            opened.forEach(path => addLog(`DECRYPTED -> ${path}`));
+        } catch (err) {
+            addLog(`DECRYPT FAILED: ${err}`);
+        }
+    };
+
+    const handlePairDevice = async (id) => {
+        try {
+            setPairing({ session: id, ...await PairDevice(id) });
+        } catch (err) {
+            addLog(`PAIRING FAILED: ${err}`);
+        }
+    };
+
+    const handleRevokeDevice = async (device) => {
+        if (!window.confirm(`Revoke ${device.name}? It will have to pair again.`)) return;
+        try {
+            await RevokeDevice(device.id);
+            addLog(`REVOKED: 📱 ${device.name}`);
+        } catch (err) {
+            addLog(`REVOKE FAILED: ${err}`);
+        }
+        setDevices(await ListDevices() || []);
+    };
+
+    const handleCancelArchive = async () => {
+        if (!archiveProgress) return;
+        try {
+            await CancelArchive(archiveProgress.id);
+        } catch (err) {
+            addLog(`CANCEL FAILED: ${err}`);
         }
     };
 
-    const handleStopServer = async () => {
-        await StopServer();
-        setIsServerRunning(false);
-        setServerInfo(null);
+    const handleStopServer = async (id) => {
+        try {
+            await StopServer(id);
+        } catch (err) {
+            addLog(`STOP FAILED: ${err}`);
+        }
+        if (id === activeSessionId) setActiveSessionId(null);
+        refreshSessions();
     };
 
+    // Live controls for the selected share; each returns the updated session
+    const sessionControls = {
+        extend: (id, minutes) => ExtendSession(id, minutes),
+        setLimit: (id, n) => SetSessionLimit(id, n),
+        setPassword: (id, pw) => SetSessionPassword(id, pw),
+        togglePause: (s) => s.paused ? ResumeSession(s.id) : PauseSession(s.id),
+        setAccess: (id, allow, deny) => SetSessionAccess(id, splitRules(allow), splitRules(deny)),
+        setClipboard: (id, access) => SetSessionClipboard(id, access),
+        setFairUse: (id, limits) => SetSessionFairUse(id, limits),
+    };
+
+    const handleSessionControl = async (action, ...args) => {
+        try {
+            await sessionControls[action](...args);
+        } catch (err) {
+            addLog(`UPDATE FAILED: ${err}`);
+        }
+        refreshSessions();
+    };
+
+    // Switching interface regenerates the URL and QR code of running shares
+    const handleInterfaceChange = async (name) => {
+        try {
+            setSessions(await SetInterface(name));
+            setSelectedInterface(name);
+            addLog(`NETWORK -> ${name || 'auto'}`);
+        } catch (err) {
+            addLog(`NETWORK ERROR: ${err}`);
+        }
+        setInterfaces(await ListInterfaces() || []);
+    };
+
+    // Only shares started afterwards listen on the new address
+    const handleBindChange = async (value) => {
+        try {
+            await SetBind(value);
+            setBindState(value);
+        } catch (err) {
+            addLog(`BIND ERROR: ${err}`);
+        }
+    };
+
+    // Like the bind address, HTTPS only applies to shares started afterwards
+    const handleSecureChange = async (value) => {
+        await SetTLS(value);
+        setSecureState(value);
+    };
+
+    const refreshSessions = async () => {
+        setSessions(await ListSessions());
+    };
+
+    const handleOpenConsole = async () => {
+        try {
+            const info = await StartAdminConsole();
+            setConsoleInfo(info);
+            addLog(`ADMIN CONSOLE -> ${info.url}`);
+            BrowserOpenURL(info.url);
+        } catch (err) {
+            addLog(`CONSOLE FAILED: ${err}`);
+        }
+    };
+
+    const splitRules = (text) => text.split(',').map(rule => rule.trim()).filter(Boolean);
+
     const addLog = (msg) => setLogs(prev => [...prev, `> ${msg}`]);
 
     return (
@@ -192,9 +372,22 @@ function App() {
                     <button className={`tab-item ${mode === 'clipboard' ? 'active' : ''}`} onClick={() => setMode('clipboard')}>
                         <span className="icon">📋</span> CLIPBOARD
                     </button>
+                    <button className={`tab-item ${mode === 'nearby' ? 'active' : ''}`} onClick={() => setMode('nearby')}>
+                        <span className="icon">📡</span> NEARBY
+                    </button>
+                    <button className="tab-item" onClick={handleOpenConsole}>
+                        <span className="icon">🛠️</span> ADMIN
+                    </button>
                 </nav>
             </header>
 
+            {consoleInfo && (
+                <div className="section-label" style={{ padding: '6px 20px' }}>
+                    <span>🛠️ ADMIN CONSOLE: <code>{consoleInfo.url}</code></span>
+                    <span>TOKEN: <code>{consoleInfo.token}</code></span>
+                </div>
+            )}
+
             <div className="view-container">
                 <div className="main-panel">
                     {mode === 'send' ? (
@@ -236,6 +429,12 @@ function App() {
                                 </div>
                             </div>
                         )
+                    ) : mode === 'nearby' ? (
+                        <NearbyPanel
+                            saveLocation={saveLocation}
+                            progressBySession={progressBySession}
+                            onLog={addLog}
+                        />
                     ) : (
                         <div className="clipboard-history-view">
                             <div className="view-header">
@@ -267,20 +466,44 @@ function App() {
                     )}
                 </div>
 
-                <ConfigPanel
+                {mode !== 'nearby' && <ConfigPanel
                     mode={mode}
                     selectedFiles={selectedFiles} setSelectedFiles={setSelectedFiles}
                     saveLocation={saveLocation} setSaveLocation={setSaveLocation}
+                    autoExtract={autoExtract} setAutoExtract={setAutoExtract}
+                    encryptTo={encryptTo} setEncryptTo={setEncryptTo}
+                    clipboardAccess={clipboardAccess} setClipboardAccess={setClipboardAccess}
+                    fairUse={fairUse} setFairUse={setFairUse}
+                    onDecryptFiles={handleDecryptFiles}
                     clipboardText={clipboardText} setClipboardText={setClipboardText}
                     password={password} setPassword={setPassword}
                     timeout={timeout} setTimeoutVal={setTimeoutVal}
                     port={port} setPort={setPort}
+                    interfaces={interfaces}
+                    selectedInterface={selectedInterface}
+                    onInterfaceChange={handleInterfaceChange}
+                    bind={bind}
+                    onBindChange={handleBindChange}
+                    secure={secure}
+                    onSecureChange={handleSecureChange}
+                    access={access}
+                    setAccess={setAccessState}
                     isServerRunning={isServerRunning}
                     serverInfo={serverInfo}
+                    sessions={sessions}
+                    onSelectSession={setActiveSessionId}
+                    onSessionControl={handleSessionControl}
+                    devices={devices}
+                    onRevokeDevice={handleRevokeDevice}
+                    pairing={pairing}
+                    onPairDevice={handlePairDevice}
+                    onClosePairing={() => setPairing(null)}
                     progress={progress}
+                    archiveProgress={archiveProgress}
+                    onCancelArchive={handleCancelArchive}
                     onStartServer={handleStartServer}
                     onStopServer={handleStopServer}
-                />
+                />}
             </div>
         </div>
     );
@@ -1,28 +1,72 @@
+import { useState } from 'react';
 import { FileSelection } from './FileSelection';
 import { SelectDirectory, SetSystemClipboard } from '../../../wailsjs/go/main/App';
 import { RetroProgressBar } from '../Common/RetroProgressBar';
+import { SessionList } from '../Server/SessionList';
+import { DeviceList } from '../Server/DeviceList';
 
 export const ConfigPanel = ({
     mode,
     selectedFiles, setSelectedFiles,
     saveLocation, setSaveLocation,
+    autoExtract, setAutoExtract,
+    encryptTo, setEncryptTo,
+    clipboardAccess, setClipboardAccess,
+    fairUse, setFairUse,
+    onDecryptFiles,
     clipboardText, setClipboardText,
     password, setPassword,
     timeout, setTimeoutVal,
     port, setPort,
+    interfaces,
+    selectedInterface,
+    onInterfaceChange,
+    bind,
+    onBindChange,
+    secure,
+    onSecureChange,
+    access,
+    setAccess,
     isServerRunning,
     serverInfo,
+    sessions,
+    onSelectSession,
+    onSessionControl,
+    devices,
+    onRevokeDevice,
+    pairing,
+    onPairDevice,
+    onClosePairing,
     progress,
+    archiveProgress,
+    onCancelArchive,
     onStartServer,
     onStopServer
 }) => {
     const basename = (path) => path.split(/[/\\]/).pop();
 
+    // Which of the share's addresses the QR code is showing
+    const [addressIndex, setAddressIndex] = useState(0);
+    const addresses = (serverInfo && serverInfo.addresses && serverInfo.addresses.length > 0) ? serverInfo.addresses : null;
+    const shareAddress = addresses ? addresses[Math.min(addressIndex, addresses.length - 1)] : serverInfo && { url: serverInfo.fullUrl, qrCode: serverInfo.qrCode };
+    // While a pairing link is open for this share, its QR code takes the share's place
+    const pairingShown = pairing && serverInfo && pairing.session === serverInfo.id;
+    const shownAddress = pairingShown ? pairing : shareAddress;
+
     return (
         <aside className="config-panel">
             <div className="retro-card">
                 <div className="section-label">⚙️ SETUP</div>
 
+                <SessionList
+                    sessions={sessions}
+                    activeSessionId={serverInfo && serverInfo.id}
+                    onSelect={onSelectSession}
+                    onStop={onStopServer}
+                />
+
+                <DeviceList devices={devices} onRevoke={onRevokeDevice} />
+
                 {!isServerRunning ? (
                     <>
                         {mode === 'send' && (
@@ -32,6 +76,27 @@ export const ConfigPanel = ({
                             />
                         )}
 
+                        {mode === 'send' && (
+                            <div className="config-grid" title="0 means no limit">
+                                <div className="input-block">
+                                    <label className="input-label">👤 Per Client</label>
+                                    <input type="number" min="0" className="input-ui" value={fairUse.perClient} onChange={e => setFairUse({ ...fairUse, perClient: parseInt(e.target.value) || 0 })} />
+                                    <select className="input-ui" value={fairUse.perClientBy} onChange={e => setFairUse({ ...fairUse, perClientBy: e.target.value })}>
+                                        <option value="ip">by IP</option>
+                                        <option value="token">by password session</option>
+                                    </select>
+                                </div>
+                                <div className="input-block">
+                                    <label className="input-label">🚦 At Once</label>
+                                    <input type="number" min="0" className="input-ui" value={fairUse.maxConcurrent} onChange={e => setFairUse({ ...fairUse, maxConcurrent: parseInt(e.target.value) || 0 })} />
+                                </div>
+                                <div className="input-block">
+                                    <label className="input-label">🐢 KiB/s</label>
+                                    <input type="number" min="0" className="input-ui" value={fairUse.rateLimit} onChange={e => setFairUse({ ...fairUse, rateLimit: parseInt(e.target.value) || 0 })} />
+                                </div>
+                            </div>
+                        )}
+
                         {mode === 'receive' && (
                             <div className="config-group">
                                 <label className="input-label">📁 Dropzone</label>
@@ -41,6 +106,19 @@ export const ConfigPanel = ({
                                 }}>
                                     {basename(saveLocation) || "Select Path..."}
                                 </div>
+                                <label className="input-label">
+                                    <input type="checkbox" checked={autoExtract && !encryptTo} disabled={!!encryptTo} onChange={e => setAutoExtract(e.target.checked)} /> 📦 Extract received archives
+                                </label>
+                                <label className="input-label">🔐 Encrypt Received Files</label>
+                                <input
+                                    type="password"
+                                    className="input-ui"
+                                    placeholder="off — passphrase or godrop-pub-... key"
+                                    title="Files are encrypted as they are saved and get a .godrop extension. Only the passphrase or the matching secret key opens them."
+                                    value={encryptTo}
+                                    onChange={e => setEncryptTo(e.target.value)}
+                                />
+                                <button className="btn-secondary" onClick={onDecryptFiles}>🔓 Decrypt Files…</button>
                             </div>
                         )}
 
@@ -63,6 +141,15 @@ export const ConfigPanel = ({
                             </div>
                         )}
 
+                        <div className="input-block">
+                            <label className="input-label">📋 Clipboard Access</label>
+                            <select className="input-ui" value={clipboardAccess} onChange={e => setClipboardAccess(e.target.value)} title="Clients need the password or a paired device">
+                                {mode !== 'clipboard' && <option value="">Off</option>}
+                                <option value="read">Read only</option>
+                                <option value={mode === 'clipboard' ? '' : 'readwrite'}>Read &amp; write</option>
+                            </select>
+                        </div>
+
                         <div className="config-grid">
                             <div className="input-block">
                                 <label className="input-label">🔒 Pass</label>
@@ -92,10 +179,84 @@ export const ConfigPanel = ({
                         <div className="sidebar-session-info animated slideInUp">
                             <div className="sidebar-qr-container">
                                 <div className="sidebar-qr-frame">
-                                    <img src={serverInfo.qrCode} alt="QR Code" className="sidebar-qr-image" />
+                                    <img src={shownAddress.qrCode} alt="QR Code" className="sidebar-qr-image" />
                                 </div>
                                 <div className="sidebar-url-box">
-                                    <code>{serverInfo.fullUrl}</code>
+                                    <code>{shownAddress.url}</code>
+                                </div>
+                                {pairingShown && (
+                                    <label className="input-label">📱 Scan on the device to pair it (valid 5 min)</label>
+                                )}
+                                {serverInfo.fingerprint && (
+                                    <div className="sidebar-url-box" title="SHA-256 fingerprint of this share's certificate. Check it matches what the browser shows.">
+                                        <code>🔒 {serverInfo.fingerprint.toUpperCase().match(/../g).join(':')}</code>
+                                    </div>
+                                )}
+                                {addresses && addresses.length > 1 && !pairingShown && (
+                                    <select className="input-ui" value={Math.min(addressIndex, addresses.length - 1)} onChange={e => setAddressIndex(Number(e.target.value))}>
+                                        {addresses.map((a, i) => (
+                                            <option key={a.url} value={i}>{a.interface ? `${a.interface} — ` : ''}{a.ip}</option>
+                                        ))}
+                                    </select>
+                                )}
+                            </div>
+
+                            <div className="config-group">
+                                <label className="input-label">
+                                    {serverInfo.paused ? '⏸ PAUSED' : '● LIVE'}
+                                    {serverInfo.mode === 'send' && ` • ${serverInfo.downloads}/${serverInfo.downloadLimit > 0 ? serverInfo.downloadLimit : '∞'} • ${serverInfo.expiryTime ? new Date(serverInfo.expiryTime * 1000).toLocaleTimeString() : '∞'}`}
+                                    {serverInfo.queued > 0 && ` • ${serverInfo.queued} QUEUED`}
+                                </label>
+                                <div className="config-grid">
+                                    <button className="btn-secondary" onClick={() => onSessionControl('togglePause', serverInfo)}>
+                                        {serverInfo.paused ? 'Resume' : 'Pause'}
+                                    </button>
+                                    {serverInfo.fingerprint && (
+                                        pairingShown
+                                            ? <button className="btn-secondary" onClick={onClosePairing}>Hide pairing</button>
+                                            : <button className="btn-secondary" onClick={() => onPairDevice(serverInfo.id)}>📱 Pair device</button>
+                                    )}
+                                    {serverInfo.mode === 'send' && (
+                                        <button className="btn-secondary" onClick={() => onSessionControl('extend', serverInfo.id, 10)}>+10 min</button>
+                                    )}
+                                    {serverInfo.mode === 'send' && (
+                                        <button className="btn-secondary" onClick={() => onSessionControl('extend', serverInfo.id, -10)}>-10 min</button>
+                                    )}
+                                    {serverInfo.mode === 'send' && (
+                                        <button className="btn-secondary" onClick={() => onSessionControl('setLimit', serverInfo.id, serverInfo.downloadLimit + 1)}>+1 download</button>
+                                    )}
+                                    {serverInfo.mode === 'send' && (
+                                        <button className="btn-secondary" title={`Per client: ${serverInfo.perClient || '∞'} (by ${serverInfo.perClientBy})\nAt once: ${serverInfo.maxConcurrent || '∞'}\nKiB/s: ${serverInfo.rateLimit || '∞'}`} onClick={() => {
+                                            const ask = (label, value) => {
+                                                const answer = window.prompt(`${label} (0 = no limit):`, value);
+                                                return answer === null ? null : parseInt(answer) || 0;
+                                            };
+                                            const perClient = ask('Downloads per client', serverInfo.perClient);
+                                            if (perClient === null) return;
+                                            const maxConcurrent = ask('Downloads at once', serverInfo.maxConcurrent);
+                                            if (maxConcurrent === null) return;
+                                            const rateLimit = ask('KiB/s per download', serverInfo.rateLimit);
+                                            if (rateLimit === null) return;
+                                            onSessionControl('setFairUse', serverInfo.id, { perClient, perClientBy: serverInfo.perClientBy, maxConcurrent, rateLimit });
+                                        }}>⚖ Fair use</button>
+                                    )}
+                                    {serverInfo.mode === 'send' && (
+                                        <button className="btn-secondary" onClick={() => {
+                                            const pw = window.prompt('New password (leave empty to remove):');
+                                            if (pw !== null) onSessionControl('setPassword', serverInfo.id, pw);
+                                        }}>{serverInfo.hasPassword ? 'Change password' : 'Set password'}</button>
+                                    )}
+                                    <select className="input-ui" value={serverInfo.clipboard} onChange={e => onSessionControl('setClipboard', serverInfo.id, e.target.value)} title="Clipboard access">
+                                        {serverInfo.mode !== 'clipboard' && <option value="">📋 Off</option>}
+                                        <option value="read">📋 Read only</option>
+                                        <option value="readwrite">📋 Read &amp; write</option>
+                                    </select>
+                                    <button className="btn-secondary" title={`Allow: ${(serverInfo.allow || []).join(', ') || 'anyone'}\nDeny: ${(serverInfo.deny || []).join(', ') || 'nobody'}`} onClick={() => {
+                                        const allow = window.prompt('Allow (comma separated; empty allows anyone):', (serverInfo.allow || []).join(', '));
+                                        if (allow === null) return;
+                                        const deny = window.prompt('Deny (comma separated):', (serverInfo.deny || []).join(', '));
+                                        if (deny !== null) onSessionControl('setAccess', serverInfo.id, allow, deny);
+                                    }}>🧱 Access</button>
                                 </div>
                             </div>
 
@@ -112,9 +273,72 @@ export const ConfigPanel = ({
                     )
                 )}
 
+                <div className="input-block">
+                    <label className="input-label">📡 Network</label>
+                    <select className="input-ui" value={selectedInterface} onChange={e => onInterfaceChange(e.target.value)}>
+                        <option value="">Auto{interfaces.length > 0 ? ` (${interfaces[0].name} ${interfaces[0].ip})` : ''}</option>
+                        {interfaces.map(iface => (
+                            <option key={`${iface.name}-${iface.ip}`} value={iface.ip}>{iface.name} — {iface.ip}</option>
+                        ))}
+                    </select>
+                </div>
+
+                {!isServerRunning && (
+                    <div className="input-block">
+                        <label className="input-label">🛡 Listen On</label>
+                        <select className="input-ui" value={bind} onChange={e => onBindChange(e.target.value)}>
+                            <option value="all">All interfaces</option>
+                            <option value="localhost">This computer only</option>
+                            {[...new Set(interfaces.map(iface => iface.name))].map(name => (
+                                <option key={name} value={name}>{name} only</option>
+                            ))}
+                        </select>
+                        <label className="input-label">
+                            <input type="checkbox" checked={secure} onChange={e => onSecureChange(e.target.checked)} /> 🔒 HTTPS (self-signed certificate)
+                        </label>
+                        <label className="input-label">🧱 Allow</label>
+                        <input
+                            type="text"
+                            className="input-ui"
+                            placeholder="anyone"
+                            title='IPs, CIDR blocks, interface names, "subnet", "localhost" or "any"'
+                            value={access.allow}
+                            onChange={e => setAccess({ ...access, allow: e.target.value })}
+                        />
+                        <label className="input-label">🚫 Deny</label>
+                        <input
+                            type="text"
+                            className="input-ui"
+                            placeholder="nobody"
+                            value={access.deny}
+                            onChange={e => setAccess({ ...access, deny: e.target.value })}
+                        />
+                    </div>
+                )}
+
+                {archiveProgress && !isServerRunning && (
+                    <div className="sidebar-progress-section">
+                        <div className="progress-header">
+                            <span>PACKAGING {archiveProgress.filesDone}/{archiveProgress.filesTotal}</span>
+                            <span>{archiveProgress.bytesTotal > 0 ? Math.floor(archiveProgress.bytesDone / archiveProgress.bytesTotal * 100) : 0}%</span>
+                        </div>
+                        <RetroProgressBar percent={archiveProgress.bytesTotal > 0 ? Math.floor(archiveProgress.bytesDone / archiveProgress.bytesTotal * 100) : 0} />
+                        <div className="progress-header" style={{ marginTop: '8px', overflow: 'hidden', textOverflow: 'ellipsis' }}>
+                            {archiveProgress.current}
+                        </div>
+                        <button className="btn-secondary" onClick={onCancelArchive}>Cancel</button>
+                    </div>
+                )}
+
+                {isServerRunning && (
+                    <button className="btn-secondary" style={{ marginTop: '20px' }} onClick={() => onSelectSession(null)}>
+                        + New Share
+                    </button>
+                )}
+
                 <button
                     className={`btn-primary ${isServerRunning ? 'stop-btn pulse' : ''}`}
-                    onClick={isServerRunning ? onStopServer : onStartServer}
+                    onClick={isServerRunning ? () => onStopServer(serverInfo.id) : onStartServer}
                     disabled={!isServerRunning && (mode === 'send' && selectedFiles.length === 0)}
                     style={{ marginTop: isServerRunning ? '20px' : 'auto' }}
                 >
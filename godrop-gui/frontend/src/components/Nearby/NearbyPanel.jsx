@@ -0,0 +1,125 @@
+import React, { useState, useEffect } from 'react';
+import { DiscoverShares, DownloadNearby, UploadNearby, SelectFiles } from '../../../wailsjs/go/main/App';
+import { RetroProgressBar } from '../Common/RetroProgressBar';
+
+const formatSize = (bytes) => {
+    if (!bytes) return '';
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return `${bytes.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+};
+
+// Shares advertised by other devices on the local network (mDNS), ready to
+// download from or upload to without scanning a QR code
+export const NearbyPanel = ({ saveLocation, progressBySession, onLog }) => {
+    const [shares, setShares] = useState([]);
+    const [scanning, setScanning] = useState(false);
+    const [codes, setCodes] = useState({});
+    const [busy, setBusy] = useState(null);
+    const [status, setStatus] = useState("");
+
+    const report = (msg) => {
+        setStatus(msg);
+        onLog(msg);
+    };
+
+    const scan = async () => {
+        setScanning(true);
+        try {
+            setShares(await DiscoverShares() || []);
+        } catch (err) {
+            report(`SCAN FAILED: ${err}`);
+        }
+        setScanning(false);
+    };
+
+    useEffect(() => { scan(); }, []);
+
+    const handleDownload = async (share) => {
+        setBusy(share.instance);
+        try {
+            const path = await DownloadNearby(share, codes[share.instance] || "", saveLocation);
+            report(`DOWNLOADED -> ${path}`);
+        } catch (err) {
+            report(`DOWNLOAD FAILED: ${err}`);
+        }
+        setBusy(null);
+    };
+
+    const handleUpload = async (share) => {
+        const files = await SelectFiles();
+        if (!files || files.length === 0) return;
+        setBusy(share.instance);
+        try {
+            await UploadNearby(share, files);
+            report(`SENT ${files.length} FILES -> ${share.host}`);
+        } catch (err) {
+            report(`UPLOAD FAILED: ${err}`);
+        }
+        setBusy(null);
+    };
+
+    return (
+        <div className="clipboard-history-view">
+            <div className="view-header">
+                <h2>NEARBY SHARES</h2>
+                <p>{status || 'Shares other devices are advertising on this network.'}</p>
+                <button className="btn-secondary" onClick={scan} disabled={scanning}>
+                    {scanning ? 'SCANNING...' : '↻ Scan Again'}
+                </button>
+            </div>
+            <div className="received-list">
+                {shares.length > 0 ? (
+                    shares.map(share => {
+                        const progress = progressBySession[`nearby:${share.instance}`];
+                        return (
+                            <div key={share.instance} className="received-item vibrant-anim">
+                                <div className="file-info-group">
+                                    <span className="file-icon">{share.mode === 'receive' ? '📥' : share.mode === 'clipboard' ? '📋' : '📄'}</span>
+                                    <div className="file-details">
+                                        <span className="file-name">{share.name}</span>
+                                        <span className="file-meta">
+                                            {share.host} • {share.mode.toUpperCase()}
+                                            {share.size > 0 && ` • ${formatSize(share.size)}`}
+                                            {share.code && ' • 🔒 CODE'}
+                                        </span>
+                                        {busy === share.instance && progress && <RetroProgressBar percent={progress.percent} />}
+                                    </div>
+                                </div>
+                                {share.mode === 'send' && (
+                                    <div className="file-info-group">
+                                        {share.code && (
+                                            <input
+                                                type="password"
+                                                className="input-ui"
+                                                placeholder="code"
+                                                value={codes[share.instance] || ""}
+                                                onChange={e => setCodes({ ...codes, [share.instance]: e.target.value })}
+                                            />
+                                        )}
+                                        <button className="btn-secondary" disabled={busy !== null} onClick={() => handleDownload(share)}>Download</button>
+                                    </div>
+                                )}
+                                {share.mode === 'receive' && (
+                                    <button className="btn-secondary" disabled={busy !== null} onClick={() => handleUpload(share)}>Send Files</button>
+                                )}
+                                {share.mode === 'clipboard' && (
+                                    <div className="file-status-badge"><code>{share.url}</code></div>
+                                )}
+                            </div>
+                        );
+                    })
+                ) : (
+                    <div className="empty-state">
+                        <span className="empty-icon">📡</span>
+                        <p>{scanning ? 'Looking for shares...' : 'No shares found nearby.'}</p>
+                    </div>
+                )}
+            </div>
+        </div>
+    );
+};
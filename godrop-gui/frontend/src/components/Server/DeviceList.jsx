@@ -0,0 +1,22 @@
+export const DeviceList = ({ devices, onRevoke }) => {
+    if (devices.length === 0) return null;
+
+    return (
+        <>
+            <div className="section-label">
+                <span>Paired Devices</span>
+                <span>{devices.length}</span>
+            </div>
+            <div className="list-box">
+                {devices.map(d => (
+                    <div key={d.id} className="list-item" title={`Paired ${new Date(d.pairedAt * 1000).toLocaleDateString()}`}>
+                        <span style={{ overflow: 'hidden', textOverflow: 'ellipsis' }}>
+                            📱 {d.name} • {new Date(d.lastSeen * 1000).toLocaleString()}
+                        </span>
+                        <span style={{ opacity: 0.5, cursor: 'pointer' }} title="Revoke" onClick={() => onRevoke(d)}>×</span>
+                    </div>
+                ))}
+            </div>
+        </>
+    );
+};
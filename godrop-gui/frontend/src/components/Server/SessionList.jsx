@@ -0,0 +1,28 @@
+export const SessionList = ({ sessions, activeSessionId, onSelect, onStop }) => {
+    if (sessions.length === 0) return null;
+
+    return (
+        <>
+            <div className="section-label">
+                <span>Active Shares</span>
+                <span>{sessions.length}</span>
+            </div>
+            <div className="list-box">
+                {sessions.map(s => (
+                    <div
+                        key={s.id}
+                        className="list-item"
+                        style={{ cursor: 'pointer', fontWeight: s.id === activeSessionId ? 800 : 400 }}
+                        onClick={() => onSelect(s.id)}
+                    >
+                        <span style={{ overflow: 'hidden', textOverflow: 'ellipsis' }}>
+                            {s.mode.toUpperCase()} • {s.label} • :{s.port}
+                            {s.mode === 'send' && s.downloadLimit > 0 && ` • ${s.downloads}/${s.downloadLimit}`}
+                        </span>
+                        <span style={{ opacity: 0.5, cursor: 'pointer' }} onClick={(e) => { e.stopPropagation(); onStop(s.id); }}>×</span>
+                    </div>
+                ))}
+            </div>
+        </>
+    );
+};
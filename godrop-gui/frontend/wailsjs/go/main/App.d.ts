@@ -2,6 +2,22 @@
 // This file is automatically generated. DO NOT EDIT
 import {backend} from '../models';
 import {server} from '../models';
+import {control} from '../models';
+import {discovery} from '../models';
+
+export function CancelArchive(arg1:string):Promise<void>;
+
+export function DecryptFiles(arg1:Array<string>,arg2:string):Promise<Array<string>>;
+
+export function DiscoverShares():Promise<Array<discovery.Share>>;
+
+export function DownloadNearby(arg1:discovery.Share,arg2:string,arg3:string):Promise<string>;
+
+export function ExtendSession(arg1:string,arg2:number):Promise<server.SessionInfo>;
+
+export function GetAccess():Promise<server.AccessRules>;
+
+export function GetBind():Promise<string>;
 
 export function GetDefaultSaveDir():Promise<string>;
 
@@ -9,18 +25,64 @@ export function GetHistory():Promise<Array<string>>;
 
 export function GetHomeDir():Promise<string>;
 
+export function GetInterface():Promise<string>;
+
 export function GetSystemClipboard():Promise<string>;
 
+export function GetTLS():Promise<boolean>;
+
+export function ListDevices():Promise<Array<backend.Device>>;
+
+export function ListInterfaces():Promise<Array<backend.NetInterface>>;
+
+export function ListSessions():Promise<Array<server.SessionInfo>>;
+
+export function PairDevice(arg1:string):Promise<server.ShareAddress>;
+
+export function PauseSession(arg1:string):Promise<server.SessionInfo>;
+
 export function ReadDir(arg1:string):Promise<Array<backend.FileEntry>>;
 
+export function ResumeSession(arg1:string):Promise<server.SessionInfo>;
+
+export function RevokeDevice(arg1:string):Promise<void>;
+
 export function SelectDirectory():Promise<string>;
 
+export function SelectFiles():Promise<Array<string>>;
+
+export function SetAccess(arg1:Array<string>,arg2:Array<string>):Promise<void>;
+
+export function SetBind(arg1:string):Promise<void>;
+
+export function SetInterface(arg1:string):Promise<Array<server.SessionInfo>>;
+
+export function SetSessionAccess(arg1:string,arg2:Array<string>,arg3:Array<string>):Promise<server.SessionInfo>;
+
+export function SetSessionClipboard(arg1:string,arg2:string):Promise<server.SessionInfo>;
+
+export function SetSessionFairUse(arg1:string,arg2:server.FairUse):Promise<server.SessionInfo>;
+
+export function SetSessionLimit(arg1:string,arg2:number):Promise<server.SessionInfo>;
+
+export function SetSessionPassword(arg1:string,arg2:string):Promise<server.SessionInfo>;
+
 export function SetSystemClipboard(arg1:string):Promise<void>;
 
-export function StartClipboardServer(arg1:string):Promise<server.ServerResponse>;
+export function SetTLS(arg1:boolean):Promise<void>;
+
+export function StartAdminConsole():Promise<control.Console>;
+
+export function StartClipboardServer(arg1:string,arg2:string,arg3:string):Promise<server.SessionInfo>;
+
+export function StartReceiveServer(arg1:string,arg2:string,arg3:boolean,arg4:string):Promise<server.SessionInfo>;
+
+export function StartServer(arg1:string,arg2:string,arg3:Array<string>,arg4:number,arg5:number):Promise<server.SessionInfo>;
+
+export function StopAdminConsole():Promise<void>;
 
-export function StartReceiveServer(arg1:string,arg2:string):Promise<server.ServerResponse>;
+export function StopAllServers():Promise<void>;
 
-export function StartServer(arg1:string,arg2:string,arg3:Array<string>,arg4:number,arg5:number):Promise<server.ServerResponse>;
+export function StopServer(arg1:string):Promise<void>;
 
-export function StopServer():Promise<void>;
+export function UploadNearby(arg1:discovery.Share,arg2:Array<string>):Promise<void>;
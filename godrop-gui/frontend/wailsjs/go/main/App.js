@@ -2,6 +2,34 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function CancelArchive(arg1) {
+  return window['go']['main']['App']['CancelArchive'](arg1);
+}
+
+export function DecryptFiles(arg1, arg2) {
+  return window['go']['main']['App']['DecryptFiles'](arg1, arg2);
+}
+
+export function DiscoverShares() {
+  return window['go']['main']['App']['DiscoverShares']();
+}
+
+export function DownloadNearby(arg1, arg2, arg3) {
+  return window['go']['main']['App']['DownloadNearby'](arg1, arg2, arg3);
+}
+
+export function ExtendSession(arg1, arg2) {
+  return window['go']['main']['App']['ExtendSession'](arg1, arg2);
+}
+
+export function GetAccess() {
+  return window['go']['main']['App']['GetAccess']();
+}
+
+export function GetBind() {
+  return window['go']['main']['App']['GetBind']();
+}
+
 export function GetDefaultSaveDir() {
   return window['go']['main']['App']['GetDefaultSaveDir']();
 }
@@ -14,34 +42,126 @@ export function GetHomeDir() {
   return window['go']['main']['App']['GetHomeDir']();
 }
 
+export function GetInterface() {
+  return window['go']['main']['App']['GetInterface']();
+}
+
 export function GetSystemClipboard() {
   return window['go']['main']['App']['GetSystemClipboard']();
 }
 
+export function GetTLS() {
+  return window['go']['main']['App']['GetTLS']();
+}
+
+export function ListDevices() {
+  return window['go']['main']['App']['ListDevices']();
+}
+
+export function ListInterfaces() {
+  return window['go']['main']['App']['ListInterfaces']();
+}
+
+export function ListSessions() {
+  return window['go']['main']['App']['ListSessions']();
+}
+
+export function PairDevice(arg1) {
+  return window['go']['main']['App']['PairDevice'](arg1);
+}
+
+export function PauseSession(arg1) {
+  return window['go']['main']['App']['PauseSession'](arg1);
+}
+
 export function ReadDir(arg1) {
   return window['go']['main']['App']['ReadDir'](arg1);
 }
 
+export function ResumeSession(arg1) {
+  return window['go']['main']['App']['ResumeSession'](arg1);
+}
+
+export function RevokeDevice(arg1) {
+  return window['go']['main']['App']['RevokeDevice'](arg1);
+}
+
 export function SelectDirectory() {
   return window['go']['main']['App']['SelectDirectory']();
 }
 
+export function SelectFiles() {
+  return window['go']['main']['App']['SelectFiles']();
+}
+
+export function SetAccess(arg1, arg2) {
+  return window['go']['main']['App']['SetAccess'](arg1, arg2);
+}
+
+export function SetBind(arg1) {
+  return window['go']['main']['App']['SetBind'](arg1);
+}
+
+export function SetInterface(arg1) {
+  return window['go']['main']['App']['SetInterface'](arg1);
+}
+
+export function SetSessionAccess(arg1, arg2, arg3) {
+  return window['go']['main']['App']['SetSessionAccess'](arg1, arg2, arg3);
+}
+
+export function SetSessionClipboard(arg1, arg2) {
+  return window['go']['main']['App']['SetSessionClipboard'](arg1, arg2);
+}
+
+export function SetSessionFairUse(arg1, arg2) {
+  return window['go']['main']['App']['SetSessionFairUse'](arg1, arg2);
+}
+
+export function SetSessionLimit(arg1, arg2) {
+  return window['go']['main']['App']['SetSessionLimit'](arg1, arg2);
+}
+
+export function SetSessionPassword(arg1, arg2) {
+  return window['go']['main']['App']['SetSessionPassword'](arg1, arg2);
+}
+
 export function SetSystemClipboard(arg1) {
   return window['go']['main']['App']['SetSystemClipboard'](arg1);
 }
 
-export function StartClipboardServer(arg1) {
-  return window['go']['main']['App']['StartClipboardServer'](arg1);
+export function SetTLS(arg1) {
+  return window['go']['main']['App']['SetTLS'](arg1);
+}
+
+export function StartAdminConsole() {
+  return window['go']['main']['App']['StartAdminConsole']();
 }
 
-export function StartReceiveServer(arg1, arg2) {
-  return window['go']['main']['App']['StartReceiveServer'](arg1, arg2);
+export function StartClipboardServer(arg1, arg2, arg3) {
+  return window['go']['main']['App']['StartClipboardServer'](arg1, arg2, arg3);
+}
+
+export function StartReceiveServer(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['StartReceiveServer'](arg1, arg2, arg3, arg4);
 }
 
 export function StartServer(arg1, arg2, arg3, arg4, arg5) {
   return window['go']['main']['App']['StartServer'](arg1, arg2, arg3, arg4, arg5);
 }
 
-export function StopServer() {
-  return window['go']['main']['App']['StopServer']();
+export function StopAdminConsole() {
+  return window['go']['main']['App']['StopAdminConsole']();
+}
+
+export function StopAllServers() {
+  return window['go']['main']['App']['StopAllServers']();
+}
+
+export function StopServer(arg1) {
+  return window['go']['main']['App']['StopServer'](arg1);
+}
+
+export function UploadNearby(arg1, arg2) {
+  return window['go']['main']['App']['UploadNearby'](arg1, arg2);
 }
@@ -21,15 +21,148 @@ export namespace backend {
 	    }
 	}
 
+	export class NetInterface {
+	    name: string;
+	    ip: string;
+	    rank: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new NetInterface(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.ip = source["ip"];
+	        this.rank = source["rank"];
+	    }
+	}
+
+	export class Device {
+	    id: string;
+	    name: string;
+	    publicKey: number[];
+	    pairedAt: number;
+	    lastSeen: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Device(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.publicKey = source["publicKey"];
+	        this.pairedAt = source["pairedAt"];
+	        this.lastSeen = source["lastSeen"];
+	    }
+	}
+
+}
+
+export namespace control {
+	
+	export class Console {
+	    url: string;
+	    token: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Console(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.url = source["url"];
+	        this.token = source["token"];
+	    }
+	}
+
+}
+
+export namespace discovery {
+	
+	export class Share {
+	    instance: string;
+	    host: string;
+	    ip: string;
+	    addrs: string[];
+	    port: number;
+	    id: string;
+	    name: string;
+	    mode: string;
+	    size: number;
+	    code: boolean;
+	    path: string;
+	    transfer: string;
+	    url: string;
+	    nameplate: string;
+	    fingerprint: string;
+	    encrypted: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Share(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.instance = source["instance"];
+	        this.host = source["host"];
+	        this.ip = source["ip"];
+	        this.addrs = source["addrs"];
+	        this.port = source["port"];
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.mode = source["mode"];
+	        this.size = source["size"];
+	        this.code = source["code"];
+	        this.path = source["path"];
+	        this.transfer = source["transfer"];
+	        this.url = source["url"];
+	        this.nameplate = source["nameplate"];
+	        this.fingerprint = source["fingerprint"];
+	        this.encrypted = source["encrypted"];
+	    }
+	}
+
 }
 
 export namespace server {
 	
+	export class ClientInfo {
+	    addr: string;
+	    device: string;
+	    firstSeen: number;
+	    lastSeen: number;
+	    active: number;
+	    transfers: number;
+	    transferred: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ClientInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.addr = source["addr"];
+	        this.device = source["device"];
+	        this.firstSeen = source["firstSeen"];
+	        this.lastSeen = source["lastSeen"];
+	        this.active = source["active"];
+	        this.transfers = source["transfers"];
+	        this.transferred = source["transferred"];
+	    }
+	}
+
 	export class ServerResponse {
+	    id: string;
+	    mode: string;
 	    ip: string;
 	    port: string;
 	    fullUrl: string;
 	    qrCode: string;
+	    fingerprint: string;
+	    addresses: ShareAddress[];
 	
 	    static createFrom(source: any = {}) {
 	        return new ServerResponse(source);
@@ -37,10 +170,161 @@ export namespace server {
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.mode = source["mode"];
+	        this.ip = source["ip"];
+	        this.port = source["port"];
+	        this.fullUrl = source["fullUrl"];
+	        this.qrCode = source["qrCode"];
+	        this.fingerprint = source["fingerprint"];
+	        this.addresses = this.convertValues(source["addresses"], ShareAddress);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SessionInfo {
+	    id: string;
+	    mode: string;
+	    ip: string;
+	    port: string;
+	    fullUrl: string;
+	    qrCode: string;
+	    fingerprint: string;
+	    addresses: ShareAddress[];
+	    label: string;
+	    startedAt: number;
+	    expiryTime: number;
+	    downloadLimit: number;
+	    downloads: number;
+	    hasPassword: boolean;
+	    paused: boolean;
+	    clipboard: string;
+	    perClient: number;
+	    perClientBy: string;
+	    maxConcurrent: number;
+	    rateLimit: number;
+	    queued: number;
+	    allow: string[];
+	    deny: string[];
+	    clients: ClientInfo[];
+	
+	    static createFrom(source: any = {}) {
+	        return new SessionInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.mode = source["mode"];
 	        this.ip = source["ip"];
 	        this.port = source["port"];
 	        this.fullUrl = source["fullUrl"];
 	        this.qrCode = source["qrCode"];
+	        this.fingerprint = source["fingerprint"];
+	        this.addresses = this.convertValues(source["addresses"], ShareAddress);
+	        this.label = source["label"];
+	        this.startedAt = source["startedAt"];
+	        this.expiryTime = source["expiryTime"];
+	        this.downloadLimit = source["downloadLimit"];
+	        this.downloads = source["downloads"];
+	        this.hasPassword = source["hasPassword"];
+	        this.paused = source["paused"];
+	        this.clipboard = source["clipboard"];
+	        this.perClient = source["perClient"];
+	        this.perClientBy = source["perClientBy"];
+	        this.maxConcurrent = source["maxConcurrent"];
+	        this.rateLimit = source["rateLimit"];
+	        this.queued = source["queued"];
+	        this.allow = source["allow"];
+	        this.deny = source["deny"];
+	        this.clients = this.convertValues(source["clients"], ClientInfo);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+	export class ShareAddress {
+	    interface: string;
+	    ip: string;
+	    url: string;
+	    qrCode: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ShareAddress(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.interface = source["interface"];
+	        this.ip = source["ip"];
+	        this.url = source["url"];
+	        this.qrCode = source["qrCode"];
+	    }
+	}
+
+	export class AccessRules {
+	    allow: string[];
+	    deny: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new AccessRules(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.allow = source["allow"];
+	        this.deny = source["deny"];
+	    }
+	}
+
+	export class FairUse {
+	    perClient: number;
+	    perClientBy: string;
+	    maxConcurrent: number;
+	    rateLimit: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FairUse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.perClient = source["perClient"];
+	        this.perClientBy = source["perClientBy"];
+	        this.maxConcurrent = source["maxConcurrent"];
+	        this.rateLimit = source["rateLimit"];
 	    }
 	}
 
@@ -1,11 +1,12 @@
 module godrop-gui
 
-go 1.23
+go 1.24
 
 require (
 	github.com/atotto/clipboard v0.1.4
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/wailsapp/wails/v2 v2.11.0
+	godrop-common v0.0.0
 )
 
 require (
@@ -39,3 +40,5 @@ require (
 )
 
 // replace github.com/wailsapp/wails/v2 v2.11.0 => C:\Users\user\go\pkg\mod
+
+replace godrop-common => ../godrop-common
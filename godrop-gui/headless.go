@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"godrop-common/netaccess"
+	"godrop-common/workdir"
+	"godrop-gui/backend"
+	"godrop-gui/backend/control"
+	"godrop-gui/backend/server"
+)
+
+// HeadlessConfig configures the backend when it runs without a window
+type HeadlessConfig struct {
+	API    string                 `json:"api"`       // Address of the control API
+	Token  string                 `json:"token"`     // Token for the control API, generated when empty
+	State  string                 `json:"state"`     // File that running shares are saved to and restored from
+	Grace  int                    `json:"grace"`     // Seconds to wait for active transfers on shutdown
+	Work   string                 `json:"workDir"`   // Root for temporary archives
+	Iface  string                 `json:"interface"` // Interface name or IP to advertise shares on
+	Bind   string                 `json:"bind"`      // Where shares listen: "all", "localhost", an interface or an IP
+	MDNS   bool                   `json:"mdns"`      // Advertise shares on the local network
+	TLS    bool                   `json:"tls"`       // Serve shares over HTTPS with self-signed certificates
+	Allow  []string               `json:"allow"`     // Clients shares admit
+	Deny   []string               `json:"deny"`      // Clients shares turn away
+	Shares []control.ShareRequest `json:"shares"`
+}
+
+// defaultHeadlessConfig holds the settings a headless backend starts from,
+// before the config file and flags are applied
+func defaultHeadlessConfig() HeadlessConfig {
+	return HeadlessConfig{
+		API:   control.DefaultConsoleAddr,
+		Grace: 30,
+		Bind:  "all",
+		MDNS:  true,
+		Allow: netaccess.DefaultAllow,
+	}
+}
+
+// loadHeadlessConfig starts from the defaults, reads the optional config file
+// over them, then applies the settings in flags whose flag was set explicitly
+// on the command line. Shares in flags are added to the file's.
+func loadHeadlessConfig(fs *flag.FlagSet, path string, flags HeadlessConfig) (HeadlessConfig, error) {
+	cfg := defaultHeadlessConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "api":
+			cfg.API = flags.API
+		case "token":
+			cfg.Token = flags.Token
+		case "state":
+			cfg.State = flags.State
+		case "grace":
+			cfg.Grace = flags.Grace
+		case "workdir":
+			cfg.Work = flags.Work
+		case "interface", "ip":
+			cfg.Iface = flags.Iface
+		case "bind":
+			cfg.Bind = flags.Bind
+		case "mdns":
+			cfg.MDNS = flags.MDNS
+		case "tls":
+			cfg.TLS = flags.TLS
+		case "allow":
+			cfg.Allow = flags.Allow
+		case "deny":
+			cfg.Deny = flags.Deny
+		}
+	})
+	// --daemon picks a state file when neither the flags nor the file name one
+	if cfg.State == "" {
+		cfg.State = flags.State
+	}
+	cfg.Shares = append(cfg.Shares, flags.Shares...)
+	return cfg, nil
+}
+
+// runHeadless starts the GUI backend without Wails and serves the control API
+// and admin console until SIGINT or SIGTERM
+func runHeadless(cfg HeadlessConfig) error {
+	logger := log.New(os.Stdout, "godrop ", log.LstdFlags)
+	hub := backend.NewHub()
+	core := backend.NewCore(backend.MultiSink{backend.LogSink{Logger: logger}, hub})
+	shares := server.NewManager(core)
+	shares.GracePeriod = time.Duration(cfg.Grace) * time.Second
+	if err := shares.SetBind(cfg.Bind); err != nil {
+		return err
+	}
+	shares.SetTLS(cfg.TLS)
+	if err := shares.SetAccess(cfg.Allow, cfg.Deny); err != nil {
+		return err
+	}
+	if devices, err := backend.LoadDevices(defaultDevicesPath()); err != nil {
+		logger.Printf("pairing is off: %v", err)
+	} else {
+		shares.SetDevices(devices)
+	}
+	if cfg.Iface != "" {
+		if _, err := shares.SetInterface(cfg.Iface); err != nil {
+			return err
+		}
+	}
+
+	if cfg.MDNS {
+		if err := shares.EnableDiscovery(); err != nil {
+			logger.Printf("shares won't be advertised on the network: %v", err)
+		} else {
+			logger.Printf("advertising shares as %s", shares.DiscoveryHost())
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if removed, err := core.OpenWorkDir(cfg.Work); err != nil {
+		logger.Printf("work directory unavailable, using the system temp dir: %v", err)
+	} else {
+		for _, dir := range removed {
+			logger.Printf("removed stale archives in %s", dir)
+		}
+	}
+
+	go core.MonitorClipboard(ctx)
+
+	startShares(shares, cfg, logger)
+
+	var console *control.Console
+	ln, err := activationListener()
+	if ln != nil {
+		console = control.ServeConsole(ln, shares, hub, cfg.Token)
+	} else if err == nil {
+		console, err = control.StartConsole(cfg.API, shares, hub, cfg.Token)
+	}
+	if err != nil {
+		shares.Close()
+		core.Shutdown()
+		return err
+	}
+	logger.Printf("admin console at %s (token %s)", console.URL, console.Token)
+
+	<-ctx.Done()
+	stop() // A second signal terminates immediately instead of waiting
+	logger.Printf("shutting down, waiting up to %s for active transfers", shares.GracePeriod)
+
+	console.Stop()
+	shares.Close()
+	core.Shutdown()
+	return nil
+}
+
+// startShares restores the shares in the state file, then starts the ones in
+// cfg. Restored shares go first so their ports are preferred. Shares from cfg
+// are tagged with their entry, so one already restored isn't started again,
+// and a restored one whose entry is gone from cfg is stopped.
+func startShares(shares *server.Manager, cfg HeadlessConfig, logger *log.Logger) {
+	running := make(map[string]server.SessionInfo)
+	if cfg.State != "" {
+		shares.SetStateFile(cfg.State)
+		restored, err := shares.Restore()
+		if err != nil {
+			logger.Printf("could not restore shares: %v", err)
+		}
+		for _, info := range restored {
+			logger.Printf("restored %s share %s at %s", info.Mode, info.ID, info.FullURL)
+			if info.Origin != "" {
+				running[info.Origin] = info
+			}
+		}
+	}
+
+	seen := make(map[string]int)
+	for _, req := range cfg.Shares {
+		origin := shareOrigin(req, seen)
+		if _, ok := running[origin]; ok {
+			delete(running, origin)
+			continue
+		}
+		info, err := control.Start(shares, req)
+		if err != nil {
+			logger.Printf("could not start %s share: %v", req.Mode, err)
+			continue
+		}
+		shares.SetOrigin(info.ID, origin)
+		logger.Printf("%s share %s live at %s", info.Mode, info.ID, info.FullURL)
+	}
+	for _, info := range running {
+		logger.Printf("stopping %s share %s, it is no longer configured", info.Mode, info.ID)
+		shares.Stop(info.ID)
+	}
+}
+
+// shareOrigin names the config entry req, from a hash of its settings.
+// seen counts the entries named so far, telling identical ones apart.
+func shareOrigin(req control.ShareRequest, seen map[string]int) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	origin := "config:" + hex.EncodeToString(sum[:8])
+	seen[origin]++
+	if n := seen[origin]; n > 1 {
+		origin += "#" + strconv.Itoa(n)
+	}
+	return origin
+}
+
+// runCleanup implements `godrop-gui cleanup`, removing archives left behind by
+// godrop processes that are no longer running
+func runCleanup(args []string) {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	root := fs.String("workdir", "", "Directory for temporary archives (default in the system temp dir)")
+	fs.Parse(args)
+
+	removed, err := workdir.Sweep(*root)
+	for _, dir := range removed {
+		fmt.Println("removed", dir)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(removed) == 0 {
+		fmt.Println("nothing to clean up")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"godrop-gui/backend"
+	"godrop-gui/backend/control"
+	"godrop-gui/backend/server"
+)
+
+// restartDaemon starts the shares of cfg on a fresh manager, the way a
+// daemon restart does, and returns their IDs. The manager is closed again
+// before it returns, keeping the state file.
+func restartDaemon(t *testing.T, cfg HeadlessConfig) []string {
+	t.Helper()
+	core := backend.NewCore(nil)
+	shares := server.NewManager(core)
+	if err := shares.SetBind("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	startShares(shares, cfg, log.New(io.Discard, "", 0))
+	var ids []string
+	for _, info := range shares.List() {
+		ids = append(ids, info.ID)
+	}
+	sort.Strings(ids)
+	shares.Close()
+	core.Shutdown()
+	return ids
+}
+
+func TestRestartKeepsConfiguredShares(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "report.txt")
+	if err := os.WriteFile(file, []byte("quarterly numbers"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	send := control.ShareRequest{Mode: "send", Files: []string{file}, Port: "18700"}
+	cfg := HeadlessConfig{
+		State:  filepath.Join(dir, "shares.json"),
+		Shares: []control.ShareRequest{send, send}, // Two identical entries are two shares
+	}
+
+	first := restartDaemon(t, cfg)
+	if len(first) != 2 {
+		t.Fatalf("started %d shares, want 2", len(first))
+	}
+	for i := 0; i < 2; i++ {
+		ids := restartDaemon(t, cfg)
+		if len(ids) != len(first) || ids[0] != first[0] || ids[1] != first[1] {
+			t.Fatalf("restart %d left shares %v, want %v", i+1, ids, first)
+		}
+	}
+
+	// A share dropped from the config doesn't come back
+	cfg.Shares = cfg.Shares[:1]
+	if ids := restartDaemon(t, cfg); len(ids) != 1 {
+		t.Errorf("after removing an entry %d shares run, want 1", len(ids))
+	}
+}
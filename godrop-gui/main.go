@@ -2,6 +2,12 @@ package main
 
 import (
 	"embed"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"godrop-gui/backend/control"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -12,8 +18,96 @@ import (
 var assets embed.FS
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		runCleanup(os.Args[2:])
+		return
+	}
+
+	fs := flag.NewFlagSet("godrop-gui", flag.ExitOnError)
+	headless := fs.Bool("headless", false, "Run the backend without a window, controlled over a local HTTP API")
+	configPath := fs.String("config", "", "JSON config file for headless mode")
+	daemon := fs.Bool("daemon", false, "Run headless and keep shares across restarts (implies --headless)")
+
+	// Settings flags go straight into a config, which loadHeadlessConfig
+	// merges with the config file
+	flags := defaultHeadlessConfig()
+	fs.StringVar(&flags.API, "api", flags.API, "Address of the headless control API")
+	fs.StringVar(&flags.Token, "token", "", "Token for the headless control API (random when empty)")
+	fs.StringVar(&flags.Work, "workdir", "", "Directory for temporary archives (default in the system temp dir)")
+	fs.StringVar(&flags.Iface, "interface", "", "Network interface to advertise shares on (default: best ranked)")
+	ip := fs.String("ip", "", "IP address to put in share URLs, overriding --interface")
+	fs.StringVar(&flags.Bind, "bind", flags.Bind, `Where shares listen: "all", "localhost", an interface name or an IP`)
+	fs.BoolVar(&flags.MDNS, "mdns", flags.MDNS, "Advertise shares on the local network over mDNS")
+	fs.BoolVar(&flags.TLS, "tls", false, "Serve shares over HTTPS with a self-signed certificate per share")
+	allow := fs.String("allow", strings.Join(flags.Allow, ","), `Comma separated clients shares admit: IPs, CIDR blocks, interfaces, "subnet", "localhost" or "any"`)
+	deny := fs.String("deny", "", "Comma separated clients shares turn away, in the same forms as --allow")
+	fs.IntVar(&flags.Grace, "grace", flags.Grace, "Seconds to wait for active transfers when shutting down")
+	fs.StringVar(&flags.State, "state", "", "State file for running shares (default in the user config dir with --daemon)")
+	var share control.ShareRequest
+	fs.StringVar(&share.Port, "port", "8080", "Port for the share started from flags")
+	fs.StringVar(&share.Password, "password", "", "Password for the share started from flags")
+	fs.IntVar(&share.Limit, "limit", 1, "Download limit for the share started from flags")
+	fs.IntVar(&share.Timeout, "timeout", 0, "Timeout in minutes for the share started from flags")
+	fs.IntVar(&share.PerClient, "per-client", 0, "Downloads each client may make from the share started from flags (0 = no limit)")
+	fs.StringVar(&share.PerClientBy, "per-client-by", "", `How --per-client tells clients apart: "ip" (default) or "token"; paired devices always by device`)
+	fs.IntVar(&share.MaxConcurrent, "max-concurrent", 0, "Downloads running at once for the share started from flags; later ones queue (0 = no limit)")
+	fs.IntVar(&share.RateLimit, "rate-limit", 0, "KiB/s each download of the share started from flags may use (0 = no limit)")
+	fs.StringVar(&share.SaveDir, "receive", "", "Start a receive share saving into this directory")
+	fs.BoolVar(&share.Extract, "extract", false, "Extract archives received by the receive share")
+	fs.StringVar(&share.EncryptTo, "encrypt-to", "", "Encrypt files received by the receive share for this public key (godrop-pub-...) or passphrase")
+	fs.StringVar(&share.Clipboard, "clipboard-access", "", `Clipboard access of the share started from flags: "read" or "readwrite" (needs --password, or --tls with paired devices)`)
+	clipboard := fs.Bool("clipboard", false, "Start a clipboard share (read-write unless --clipboard-access says otherwise)")
+	fs.Parse(os.Args[1:])
+
+	if *daemon {
+		*headless = true
+		if flags.State == "" {
+			flags.State = defaultStatePath()
+		}
+	}
+
+	if *ip != "" {
+		flags.Iface = *ip
+	}
+	flags.Allow, flags.Deny = strings.Split(*allow, ","), strings.Split(*deny, ",")
+
+	if *headless {
+		share.Files = fs.Args()
+		switch {
+		case share.SaveDir != "":
+			share.Mode = "receive"
+		case *clipboard:
+			share.Mode = "clipboard"
+		case len(share.Files) > 0:
+			share.Mode = "send"
+		}
+		if share.Mode != "" {
+			flags.Shares = append(flags.Shares, share)
+		}
+		cfg, err := loadHeadlessConfig(fs, *configPath, flags)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runHeadless(cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Create an instance of the app structure
-	app := NewApp()
+	app := NewApp(flags.Work, flags.MDNS)
+	if err := app.shares.SetBind(flags.Bind); err != nil {
+		log.Fatal(err)
+	}
+	app.shares.SetTLS(flags.TLS)
+	if err := app.shares.SetAccess(flags.Allow, flags.Deny); err != nil {
+		log.Fatal(err)
+	}
+	if flags.Iface != "" {
+		if _, err := app.shares.SetInterface(flags.Iface); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Create application with options
 	err := wails.Run(&options.App{
@@ -26,6 +120,7 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 247, G: 244, B: 243, A: 1},
 		OnStartup:        app.startup,
+		OnShutdown:       app.shutdown,
 		Bind: []interface{}{
 			app,
 		},